@@ -3,11 +3,33 @@ package apierror
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 )
 
+// ProblemContentType - media type of error responses (RFC 7807)
+const ProblemContentType = "application/problem+json"
+
+// TypeBaseURI - base of problem type URIs, resolvable through errors catalog endpoint
+const TypeBaseURI = "/api/v1/errors#"
+
+// FieldError - reason why single field of request payload was rejected
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// ApiError - problem details (RFC 7807) of api error, extended with numeric api code
+// and list of offending fields for validation errors
 type ApiError struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	// Compensation - outcome of rollback of partially finished operation
+	Compensation string `json:"compensation,omitempty"`
 }
 
 func (e *ApiError) Error() string {
@@ -18,86 +40,252 @@ func (e *ApiError) Error() string {
 	return fmt.Sprintf("%s", errByteArr)
 }
 
+// newError - creates api error from catalog definition of code
+func newError(code string) *ApiError {
+	def, ok := registry[code]
+
+	if !ok {
+		def = registry[internalServerErrorCode]
+	}
+
+	return &ApiError{
+		Type:   TypeBaseURI + def.Code,
+		Title:  def.Title,
+		Status: def.Status,
+		Code:   def.Code,
+	}
+}
+
+// WithDetail - returns copy of api error with detail explaining this occurrence,
+// other errors are returned unchanged
+func WithDetail(err error, detail string) error {
+	apiErr, ok := err.(*ApiError)
+
+	if !ok {
+		return err
+	}
+
+	e := *apiErr
+	e.Detail = detail
+	return &e
+}
+
+// WithFieldErrors - returns copy of api error listing offending fields,
+// other errors are returned unchanged
+func WithFieldErrors(err error, fieldErrors []FieldError) error {
+	apiErr, ok := err.(*ApiError)
+
+	if !ok {
+		return err
+	}
+
+	e := *apiErr
+	e.Errors = fieldErrors
+	return &e
+}
+
+// WithCompensation - returns copy of api error with outcome of rollback,
+// other errors are returned unchanged
+func WithCompensation(err error, compensation string) error {
+	apiErr, ok := err.(*ApiError)
+
+	if !ok {
+		return err
+	}
+
+	e := *apiErr
+	e.Compensation = compensation
+	return &e
+}
+
+// CodeOf - returns api code of error, empty for errors not from catalog
+func CodeOf(err error) string {
+	if apiErr, ok := err.(*ApiError); ok {
+		return apiErr.Code
+	}
+
+	return ""
+}
+
+// StatusOf - returns http status of error, 500 for errors not from catalog
+func StatusOf(err error) int {
+	if apiErr, ok := err.(*ApiError); ok && apiErr.Status != 0 {
+		return apiErr.Status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// Write - writes error as problem+json response, errors not from catalog are
+// hidden behind InternalServerError so no internal message leaks to caller
+func Write(w http.ResponseWriter, err error, instance string) {
+	apiErr, ok := err.(*ApiError)
+
+	if !ok {
+		apiErr = newError(internalServerErrorCode)
+	}
+
+	e := *apiErr
+	e.Instance = instance
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(StatusOf(&e))
+	w.Write([]byte(e.Error()))
+}
+
 func TestError() error {
-	e := &ApiError{
-		Code:    "XXX",
-		Message: "Test Error"}
-	return e
+	return newError("XXX")
 }
 
 func NotFoundError() error {
-	e := &ApiError{
-		Code:    "1000",
-		Message: "Endpoint Not Found"}
-	return e
+	return newError("1000")
 }
 
 func NotImplementedError() error {
-	e := &ApiError{
-		Code:    "1001",
-		Message: "Api endpoint exists but is not yet implemented"}
-	return e
+	return newError("1001")
 }
 
 func InvalidIDError() error {
-	e := &ApiError{
-		Code:    "1002",
-		Message: "Invalid object ID"}
-	return e
+	return newError("1002")
 }
 
 func InvalidRequestPayload() error {
-	e := &ApiError{
-		Code:    "1003",
-		Message: "Invalid Request payload"}
-	return e
+	return newError("1003")
 }
 
 func QueryParamMissing() error {
-	e := &ApiError{
-		Code:    "1004",
-		Message: "Query param specified but value missing"}
-	return e
+	return newError("1004")
 }
 
 func ParamStartBadValue() error {
-	e := &ApiError{
-		Code:    "1005",
-		Message: "Query param start must be positive integer"}
-	return e
+	return newError("1005")
 }
 
 func ParamCountBadValue() error {
-	e := &ApiError{
-		Code:    "1006",
-		Message: "Query param count must be positive integer"}
-	return e
+	return newError("1006")
 }
 
 func MissingRequiredFieldsPayload() error {
-	e := &ApiError{
-		Code:    "1007",
-		Message: "Missing required fields"}
-	return e
+	return newError("1007")
 }
 
 func InvalidBasicAuthHeaders() error {
-	e := &ApiError{
-		Code:    "1008",
-		Message: "Invalid basic auth headers"}
-	return e
+	return newError("1008")
 }
 
 func BadClientSecret() error {
-	e := &ApiError{
-		Code:    "1009",
-		Message: "Bad client secret"}
-	return e
+	return newError("1009")
 }
 
 func InternalServerError() error {
-	e := &ApiError{
-		Code:    "1010",
-		Message: "InternalServerError"}
-	return e
+	return newError("1010")
+}
+
+func UpstreamTimeout() error {
+	return newError("1011")
+}
+
+func RequestCancelled() error {
+	return newError("1012")
+}
+
+func UpstreamUnavailable() error {
+	return newError("1013")
+}
+
+func UpstreamBadRequest() error {
+	return newError("1014")
+}
+
+func Forbidden() error {
+	return newError("1015")
+}
+
+func ResourceNotFound() error {
+	return newError("1016")
+}
+
+func Conflict() error {
+	return newError("1017")
+}
+
+func AuthenticationFailed() error {
+	return newError("1018")
+}
+
+func ClientNotFound() error {
+	return newError("1019")
+}
+
+func MethodNotAllowed() error {
+	return newError("1020")
+}
+
+func ValidationFailed() error {
+	return newError("1021")
+}
+
+func RedirectPolicyViolation() error {
+	return newError("1022")
+}
+
+func NamingPolicyViolation() error {
+	return newError("1023")
+}
+
+func ReservedClientName() error {
+	return newError("1024")
+}
+
+func QuotaExceeded() error {
+	return newError("1025")
+}
+
+func TooManyRequests() error {
+	return newError("1026")
+}
+
+func AuthenticationLocked() error {
+	return newError("1027")
+}
+
+func IdempotencyKeyMismatch() error {
+	return newError("1028")
+}
+
+func IdempotencyKeyInUse() error {
+	return newError("1029")
+}
+
+func InvalidIdempotencyKey() error {
+	return newError("1030")
+}
+
+func UnsupportedMediaType() error {
+	return newError("1031")
+}
+
+func PreconditionFailed() error {
+	return newError("1032")
+}
+
+func NotClientOwner() error {
+	return newError("1033")
+}
+
+func BadClientAssertion() error {
+	return newError("1034")
+}
+
+func RoleGrantNotAllowed() error {
+	return newError("1035")
+}
+
+func ClientFlagNotAllowed() error {
+	return newError("1036")
+}
+
+func UpstreamError() error {
+	return newError("10000")
 }
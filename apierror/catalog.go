@@ -0,0 +1,77 @@
+package apierror
+
+const internalServerErrorCode = "1010"
+
+// Definition - catalog entry of api error, maps constructor to code and http status
+type Definition struct {
+	Name   string `json:"name"`
+	Code   string `json:"code"`
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+}
+
+// catalog - every api error returned by api, constructor in api.go must have entry here
+var catalog = []Definition{
+	{Name: "NotFoundError", Code: "1000", Title: "Endpoint Not Found", Status: 404},
+	{Name: "NotImplementedError", Code: "1001", Title: "Api endpoint exists but is not yet implemented", Status: 501},
+	{Name: "InvalidIDError", Code: "1002", Title: "Invalid object ID", Status: 400},
+	{Name: "InvalidRequestPayload", Code: "1003", Title: "Invalid Request payload", Status: 400},
+	{Name: "QueryParamMissing", Code: "1004", Title: "Query param specified but value missing", Status: 400},
+	{Name: "ParamStartBadValue", Code: "1005", Title: "Query param start must be positive integer", Status: 400},
+	{Name: "ParamCountBadValue", Code: "1006", Title: "Query param count must be positive integer", Status: 400},
+	{Name: "MissingRequiredFieldsPayload", Code: "1007", Title: "Missing required fields", Status: 400},
+	{Name: "InvalidBasicAuthHeaders", Code: "1008", Title: "Invalid basic auth headers", Status: 401},
+	{Name: "BadClientSecret", Code: "1009", Title: "Bad client secret", Status: 401},
+	{Name: "InternalServerError", Code: "1010", Title: "InternalServerError", Status: 500},
+	{Name: "UpstreamTimeout", Code: "1011", Title: "Identity provider request timed out", Status: 504},
+	{Name: "RequestCancelled", Code: "1012", Title: "Request cancelled", Status: 499},
+	{Name: "UpstreamUnavailable", Code: "1013", Title: "Identity provider unavailable, circuit breaker open", Status: 503},
+	{Name: "UpstreamBadRequest", Code: "1014", Title: "Request rejected by identity provider", Status: 400},
+	{Name: "Forbidden", Code: "1015", Title: "Operation forbidden", Status: 403},
+	{Name: "ResourceNotFound", Code: "1016", Title: "Resource not found", Status: 404},
+	{Name: "Conflict", Code: "1017", Title: "Resource already exists", Status: 409},
+	{Name: "AuthenticationFailed", Code: "1018", Title: "Authentication failed", Status: 401},
+	{Name: "ClientNotFound", Code: "1019", Title: "Client not found", Status: 404},
+	{Name: "MethodNotAllowed", Code: "1020", Title: "Method not allowed", Status: 405},
+	{Name: "ValidationFailed", Code: "1021", Title: "Request payload failed validation", Status: 400},
+	{Name: "RedirectPolicyViolation", Code: "1022", Title: "Redirect URI or web origin violates policy", Status: 400},
+	{Name: "NamingPolicyViolation", Code: "1023", Title: "Client id violates naming policy", Status: 400},
+	{Name: "ReservedClientName", Code: "1024", Title: "Client id is reserved", Status: 403},
+	{Name: "QuotaExceeded", Code: "1025", Title: "Client quota exceeded", Status: 403},
+	{Name: "TooManyRequests", Code: "1026", Title: "Too many requests", Status: 429},
+	{Name: "AuthenticationLocked", Code: "1027", Title: "Too many failed authentications, temporarily locked", Status: 429},
+	{Name: "IdempotencyKeyMismatch", Code: "1028", Title: "Idempotency key already used with different payload", Status: 422},
+	{Name: "IdempotencyKeyInUse", Code: "1029", Title: "Request with same idempotency key is in progress", Status: 409},
+	{Name: "InvalidIdempotencyKey", Code: "1030", Title: "Idempotency key longer than 255 characters", Status: 400},
+	{Name: "UnsupportedMediaType", Code: "1031", Title: "Unsupported media type", Status: 415},
+	{Name: "PreconditionFailed", Code: "1032", Title: "Client changed since it was read", Status: 412},
+	{Name: "NotClientOwner", Code: "1033", Title: "Caller is not owner of client", Status: 403},
+	{Name: "BadClientAssertion", Code: "1034", Title: "Client assertion rejected", Status: 401},
+	{Name: "RoleGrantNotAllowed", Code: "1035", Title: "Role can't be granted to service account", Status: 403},
+	{Name: "ClientFlagNotAllowed", Code: "1036", Title: "Client flag can be enabled only by admin", Status: 403},
+	{Name: "UpstreamError", Code: "10000", Title: "Identity provider error", Status: 500},
+}
+
+// testErrorDefinition - error of TestError, known to registry but not published in catalog
+var testErrorDefinition = Definition{Name: "TestError", Code: "XXX", Title: "Test Error", Status: 500}
+
+// registry - catalog indexed by code
+var registry = map[string]Definition{}
+
+func init() {
+	for i := range catalog {
+		catalog[i].Type = TypeBaseURI + catalog[i].Code
+		registry[catalog[i].Code] = catalog[i]
+	}
+
+	testErrorDefinition.Type = TypeBaseURI + testErrorDefinition.Code
+	registry[testErrorDefinition.Code] = testErrorDefinition
+}
+
+// Catalog - returns definitions of all api errors
+func Catalog() []Definition {
+	definitions := make([]Definition, len(catalog))
+	copy(definitions, catalog)
+	return definitions
+}
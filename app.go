@@ -2,7 +2,11 @@ package main
 
 import (
 	"net/http"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -16,23 +20,48 @@ type App struct {
 
 // Config - structure holding configuration items for app
 type Config struct {
-	IdpURL          string
-	ClientID        string
-	ClientSecret    string
-	ApiClientID     string
-	ApiClientSecret string
-	IdpAdmin        string
-	IdpPass         string
-	IdpRealm        string
-	HTTPClient      APIClientIntf
-	CheckURI        string
-	ClientsURI      string
-	ClientURI       string
-	ClientSecretURI string
-	TokenURI        string
-	UsersURI        string
-	UserURI         string
-	UserPasswordURI string
+	IdpURL                 string
+	ClientID               string
+	ClientSecret           string
+	ApiClientID            string
+	ApiClientSecret        string
+	IdpAdmin               string
+	IdpPass                string
+	IdpRealm               string
+	HTTPClient             APIClientIntf
+	CheckURI               string
+	ClientsURI             string
+	ClientURI              string
+	ClientSecretURI        string
+	TokenURI               string
+	IntrospectURI          string
+	SamlDescriptorURI      string
+	ClientRolesURI         string
+	ClientRoleURI          string
+	ServiceAccountUserURI  string
+	RealmRoleURI           string
+	RoleMappingsURI        string
+	ClientScopesURI        string
+	ClientScopeMappingsURI string
+	AllowedClientScopes    []string
+	ProtocolMappersURI     string
+	AllowedMapperTypes     []string
+	ReservedClaims         []string
+	Templates              ClientTemplates
+	UsersURI               string
+	UserURI                string
+	UserPasswordURI        string
+	RequestTimeout         time.Duration
+	OperationTimeouts      map[string]time.Duration
+	ClientsPageSize        int
+	RedirectPolicy         RedirectPolicy
+	TokenPolicy            TokenPolicy
+	FlagPolicy             FlagPolicy
+	NamingPolicy           NamingPolicy
+	Quotas                 *Quotas
+	RateLimiter            *RateLimiter
+	Idempotency            *IdempotencyStore
+	ServiceAccountPolicy   ServiceAccountPolicy
 }
 
 // CreateApp - function for creating and initializing app
@@ -40,38 +69,125 @@ func CreateApp() *App {
 	logger := logging.GetLogger()
 	logger.Print("Starting...")
 
-	apiClient := &APIClient{BaseClient: &http.Client{}}
+	apiClient := &APIClient{
+		BaseClient: &http.Client{},
+		Retry: RetryPolicy{
+			MaxRetries: getEnvInt("IDP_RETRY_MAX", 2),
+			BaseDelay:  getEnvDuration("IDP_RETRY_BASE_DELAY", 100*time.Millisecond),
+			MaxDelay:   getEnvDuration("IDP_RETRY_MAX_DELAY", 2*time.Second),
+		},
+		Breaker: NewCircuitBreaker(
+			getEnvInt("IDP_BREAKER_THRESHOLD", 5),
+			getEnvDuration("IDP_BREAKER_COOLDOWN", 30*time.Second),
+		),
+	}
 
 	config := &Config{
-		IdpURL:          os.Getenv("IDP_URL"),
-		ClientID:        os.Getenv("CLIENT_ID"),
-		ClientSecret:    os.Getenv("CLIENT_SECRET"),
-		ApiClientID:     os.Getenv("API_CLIENT_ID"),
-		ApiClientSecret: os.Getenv("API_CLIENT_SECRET"),
-		IdpAdmin:        os.Getenv("IDP_ADMIN_USER"),
-		IdpPass:         os.Getenv("IDP_ADMIN_PASSWORD"),
-		IdpRealm:        os.Getenv("IDP_REALM"),
-		HTTPClient:      apiClient,
-		CheckURI:        "%s/auth/admin",
-		ClientsURI:      "%s/auth/admin/realms/%s/clients",
-		ClientURI:       "%s/auth/admin/realms/%s/clients/%s",
-		ClientSecretURI: "%s/auth/admin/realms/%s/clients/%s/client-secret",
-		TokenURI:        "%s/auth/realms/%s/protocol/openid-connect/token",
-		UsersURI:        "%s/auth/admin/realms/%s/users",
-		UserURI:         "%s/auth/admin/realms/%s/users/%s",
-		UserPasswordURI: "%s/auth/admin/realms/%s/users/%s/reset-password",
+		IdpURL:                 os.Getenv("IDP_URL"),
+		ClientID:               os.Getenv("CLIENT_ID"),
+		ClientSecret:           os.Getenv("CLIENT_SECRET"),
+		ApiClientID:            os.Getenv("API_CLIENT_ID"),
+		ApiClientSecret:        os.Getenv("API_CLIENT_SECRET"),
+		IdpAdmin:               os.Getenv("IDP_ADMIN_USER"),
+		IdpPass:                os.Getenv("IDP_ADMIN_PASSWORD"),
+		IdpRealm:               os.Getenv("IDP_REALM"),
+		HTTPClient:             apiClient,
+		CheckURI:               "%s/auth/admin",
+		ClientsURI:             "%s/auth/admin/realms/%s/clients",
+		ClientURI:              "%s/auth/admin/realms/%s/clients/%s",
+		ClientSecretURI:        "%s/auth/admin/realms/%s/clients/%s/client-secret",
+		TokenURI:               "%s/auth/realms/%s/protocol/openid-connect/token",
+		IntrospectURI:          "%s/auth/realms/%s/protocol/openid-connect/token/introspect",
+		SamlDescriptorURI:      "%s/auth/realms/%s/protocol/saml/descriptor",
+		ClientRolesURI:         "%s/auth/admin/realms/%s/clients/%s/roles",
+		ClientRoleURI:          "%s/auth/admin/realms/%s/clients/%s/roles/%s",
+		ServiceAccountUserURI:  "%s/auth/admin/realms/%s/clients/%s/service-account-user",
+		RealmRoleURI:           "%s/auth/admin/realms/%s/roles/%s",
+		RoleMappingsURI:        "%s/auth/admin/realms/%s/users/%s/role-mappings",
+		ClientScopesURI:        "%s/auth/admin/realms/%s/client-scopes",
+		ClientScopeMappingsURI: "%s/auth/admin/realms/%s/clients/%s/%s-client-scopes",
+		AllowedClientScopes:    getEnvList("CLIENT_SCOPES_ALLOWED", []string{}),
+		ProtocolMappersURI:     "%s/auth/admin/realms/%s/clients/%s/protocol-mappers/models",
+		AllowedMapperTypes:     getEnvList("PROTOCOL_MAPPER_TYPES", defaultMapperTypes),
+		ReservedClaims:         getEnvList("PROTOCOL_MAPPER_RESERVED_CLAIMS", defaultReservedClaims),
+		Templates:              getEnvTemplates("CLIENT_TEMPLATES_FILE"),
+		UsersURI:               "%s/auth/admin/realms/%s/users",
+		UserURI:                "%s/auth/admin/realms/%s/users/%s",
+		UserPasswordURI:        "%s/auth/admin/realms/%s/users/%s/reset-password",
+		RequestTimeout:         getEnvDuration("IDP_REQUEST_TIMEOUT", 10*time.Second),
+		OperationTimeouts:      getEnvDurationMap("IDP_OPERATION_TIMEOUTS"),
+		ClientsPageSize:        getEnvInt("CLIENTS_PAGE_SIZE", 100),
+		Quotas: NewQuotas(
+			getEnvInt("QUOTA_MAX_CLIENTS", 0),
+			getEnvInt("QUOTA_MAX_CREATES_PER_HOUR", 0),
+			getEnvIntMap("QUOTA_GROUP_MAX_CLIENTS"),
+			getEnvIntMap("QUOTA_GROUP_MAX_CREATES_PER_HOUR"),
+		),
+		RedirectPolicy: RedirectPolicy{
+			AllowedSchemes:     getEnvList("REDIRECT_ALLOWED_SCHEMES", []string{"https", "http"}),
+			DomainSuffixes:     getEnvListMap("REDIRECT_ALLOWED_DOMAINS"),
+			AllowPathWildcards: getEnvBool("REDIRECT_ALLOW_PATH_WILDCARDS", true),
+			AllowLocalhost:     getEnvBool("REDIRECT_ALLOW_LOCALHOST", true),
+		},
+		TokenPolicy: TokenPolicy{
+			AccessTokenLifespan:      getEnvBounds("TOKEN_ACCESS_LIFESPAN"),
+			ClientSessionIdleTimeout: getEnvBounds("TOKEN_CLIENT_SESSION_IDLE"),
+			ClientSessionMaxLifespan: getEnvBounds("TOKEN_CLIENT_SESSION_MAX"),
+		},
+		FlagPolicy: FlagPolicy{
+			NonAdminFlags: getEnvList("CLIENT_FLAGS_NON_ADMIN", defaultNonAdminFlags),
+			AdminGroups:   getEnvList("CLIENT_FLAGS_ADMIN_GROUPS", []string{}),
+		},
+		ServiceAccountPolicy: ServiceAccountPolicy{
+			RealmRoles:       getEnvList("SA_GRANTABLE_REALM_ROLES", []string{}),
+			ClientRoles:      getEnvListMap("SA_GRANTABLE_CLIENT_ROLES"),
+			OwnedClientRoles: getEnvBool("SA_GRANT_OWNED_CLIENT_ROLES", true),
+		},
+	}
+
+	config.RateLimiter = NewRateLimiter(
+		BucketPolicy{Rate: getEnvFloat("RATE_LIMIT_IP_RATE", 5), Burst: getEnvInt("RATE_LIMIT_IP_BURST", 20)},
+		BucketPolicy{Rate: getEnvFloat("RATE_LIMIT_USER_RATE", 1), Burst: getEnvInt("RATE_LIMIT_USER_BURST", 10)},
+		getEnvInt("AUTH_MAX_FAILURES", 5),
+		getEnvDuration("AUTH_LOCKOUT", 15*time.Minute),
+	)
+	config.RateLimiter.TrustForwarded = getEnvBool("RATE_LIMIT_TRUST_FORWARDED", false)
+
+	idempotency, err := NewIdempotencyStore(
+		getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		os.Getenv("IDEMPOTENCY_ENCRYPTION_KEY"),
+	)
+
+	if err != nil {
+		logger.Fatalf("Can't create idempotency store %s", err)
+	}
+
+	config.Idempotency = idempotency
+
+	config.NamingPolicy = NamingPolicy{
+		Pattern:           getEnvRegexp("CLIENT_ID_PATTERN", defaultClientIDPattern),
+		EntityIDPattern:   getEnvRegexp("SAML_ENTITY_ID_PATTERN", defaultEntityIDPattern),
+		MaxLength:         getEnvInt("CLIENT_ID_MAX_LENGTH", 64),
+		RequireTeamPrefix: getEnvBool("CLIENT_ID_TEAM_PREFIX", false),
+		PrefixSeparator:   getEnvString("CLIENT_ID_PREFIX_SEPARATOR", "-"),
+		Reserved:          reservedClients(config, getEnvList("CLIENT_ID_RESERVED", []string{})),
 	}
 
 	controller := &Controller{Config: config}
 
 	r := mux.NewRouter()
+	// SAML clientIds are urls, so they come percent encoded in path
+	r.UseEncodedPath()
+	r.Use(requestIDMiddleware)
+	r.NotFoundHandler = requestIDMiddleware(http.HandlerFunc(notFoundHandler))
+	r.MethodNotAllowedHandler = requestIDMiddleware(http.HandlerFunc(methodNotAllowedHandler))
 	s := r.PathPrefix("/api/v1").Subrouter()
+	s.Use(config.RateLimiter.Middleware)
 
-	s.HandleFunc("/client", controller.DeleteResource).Methods("DELETE")
-	s.HandleFunc("/client", controller.CreateResource).Methods("POST")
-	s.HandleFunc("/client", controller.UpdateResource).Methods("PUT")
+	apiRoutes(s, controller)
 
 	r.HandleFunc("/health", controller.HealthCheck).Methods("GET")
+	r.HandleFunc("/metrics", controller.Metrics).Methods("GET")
 
 	r.HandleFunc("/swagger.yml", controller.ReadSwagger).Methods("GET")
 
@@ -84,6 +200,44 @@ func CreateApp() *App {
 	return app
 }
 
+// apiRoutes - registers endpoints of api on router, path variables are matched encoded
+// and have to be read by pathVar
+func apiRoutes(s *mux.Router, controller *Controller) {
+	s.UseEncodedPath()
+	s.HandleFunc("/client", controller.DeleteResource).Methods("DELETE")
+	s.HandleFunc("/client", controller.CreateResource).Methods("POST")
+	s.HandleFunc("/client", controller.UpdateResource).Methods("PUT")
+	s.HandleFunc("/client/{clientId}", controller.GetResource).Methods("GET")
+	s.HandleFunc("/client/{clientId}", controller.PatchResource).Methods("PATCH")
+	s.HandleFunc("/client/{clientId}/roles", controller.ListClientRoles).Methods("GET")
+	s.HandleFunc("/client/{clientId}/roles", controller.CreateClientRole).Methods("POST")
+	s.HandleFunc("/client/{clientId}/roles/{roleName}", controller.UpdateClientRole).Methods("PUT")
+	s.HandleFunc("/client/{clientId}/roles/{roleName}", controller.DeleteClientRole).Methods("DELETE")
+	s.HandleFunc("/templates", controller.ListTemplates).Methods("GET")
+	s.HandleFunc("/client/{clientId}/mappers", controller.ListProtocolMappers).Methods("GET")
+	s.HandleFunc("/client/{clientId}/mappers", controller.CreateProtocolMapper).Methods("POST")
+	s.HandleFunc("/client/{clientId}/mappers/{mapperName}", controller.UpdateProtocolMapper).Methods("PUT")
+	s.HandleFunc("/client/{clientId}/mappers/{mapperName}", controller.DeleteProtocolMapper).Methods("DELETE")
+	s.HandleFunc("/client/{clientId}/service-account/roles", controller.GetServiceAccountRoles).Methods("GET")
+	s.HandleFunc("/client/{clientId}/service-account/roles", controller.GrantServiceAccountRoles).Methods("POST")
+	s.HandleFunc("/client/{clientId}/service-account/roles", controller.RevokeServiceAccountRoles).Methods("DELETE")
+	s.HandleFunc("/errors", controller.ListErrors).Methods("GET")
+	s.HandleFunc("/quota", controller.GetQuota).Methods("GET")
+	s.HandleFunc("/saml/metadata", controller.SamlMetadata).Methods("GET")
+}
+
+// pathVar - returns decoded path variable of request, raw value when it is not valid escape
+func pathVar(r *http.Request, name string) string {
+	value := mux.Vars(r)[name]
+	decoded, err := url.PathUnescape(value)
+
+	if err != nil {
+		return value
+	}
+
+	return decoded
+}
+
 func (app *App) run() {
 	logger := logging.GetLogger()
 
@@ -98,3 +252,220 @@ func (app *App) run() {
 	logger.Fatal(srv.ListenAndServe())
 	logger.Print("Hello, log file!")
 }
+
+// getEnvDuration - reads duration (e.g. 10s) from env var, returns default when unset or invalid
+func getEnvDuration(name string, defaultValue time.Duration) time.Duration {
+	logger := logging.GetLogger()
+	value := os.Getenv(name)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+
+	if err != nil {
+		logger.Printf("Invalid duration %s in %s, using default %s", value, name, defaultValue)
+		return defaultValue
+	}
+
+	return duration
+}
+
+// getEnvBounds - reads bounds in seconds from durations in env vars with _MIN and _MAX suffix, unset means no limit
+func getEnvBounds(prefix string) Bounds {
+	return Bounds{
+		Min: int(getEnvDuration(prefix+"_MIN", 0).Seconds()),
+		Max: int(getEnvDuration(prefix+"_MAX", 0).Seconds()),
+	}
+}
+
+// getEnvInt - reads integer from env var, returns default when unset or invalid
+func getEnvInt(name string, defaultValue int) int {
+	logger := logging.GetLogger()
+	value := os.Getenv(name)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+
+	if err != nil {
+		logger.Printf("Invalid number %s in %s, using default %d", value, name, defaultValue)
+		return defaultValue
+	}
+
+	return number
+}
+
+// getEnvDurationMap - reads comma separated list of name=duration pairs from env var
+func getEnvDurationMap(name string) map[string]time.Duration {
+	logger := logging.GetLogger()
+	durations := map[string]time.Duration{}
+
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		pair := strings.SplitN(item, "=", 2)
+
+		if len(pair) != 2 {
+			logger.Printf("Invalid item %s in %s, skipping", item, name)
+			continue
+		}
+
+		duration, err := time.ParseDuration(strings.TrimSpace(pair[1]))
+
+		if err != nil {
+			logger.Printf("Invalid duration %s in %s, skipping", pair[1], name)
+			continue
+		}
+
+		durations[strings.TrimSpace(pair[0])] = duration
+	}
+
+	return durations
+}
+
+// getEnvBool - reads boolean from env var, returns default when unset or invalid
+func getEnvBool(name string, defaultValue bool) bool {
+	logger := logging.GetLogger()
+	value := os.Getenv(name)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	flag, err := strconv.ParseBool(value)
+
+	if err != nil {
+		logger.Printf("Invalid boolean %s in %s, using default %t", value, name, defaultValue)
+		return defaultValue
+	}
+
+	return flag
+}
+
+// getEnvList - reads comma separated list from env var, returns default when unset
+func getEnvList(name string, defaultValue []string) []string {
+	items := []string{}
+
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if strings.TrimSpace(item) != "" {
+			items = append(items, strings.TrimSpace(item))
+		}
+	}
+
+	if len(items) == 0 {
+		return defaultValue
+	}
+
+	return items
+}
+
+// getEnvListMap - reads comma separated list of name=value1|value2 pairs from env var
+func getEnvListMap(name string) map[string][]string {
+	logger := logging.GetLogger()
+	lists := map[string][]string{}
+
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		pair := strings.SplitN(item, "=", 2)
+
+		if len(pair) != 2 {
+			logger.Printf("Invalid item %s in %s, skipping", item, name)
+			continue
+		}
+
+		key := strings.TrimSpace(pair[0])
+
+		for _, value := range strings.Split(pair[1], "|") {
+			if strings.TrimSpace(value) != "" {
+				lists[key] = append(lists[key], strings.TrimSpace(value))
+			}
+		}
+	}
+
+	return lists
+}
+
+// getEnvString - reads string from env var, returns default when unset
+func getEnvString(name string, defaultValue string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+
+	return defaultValue
+}
+
+// getEnvRegexp - reads regular expression from env var, returns default when unset or invalid
+func getEnvRegexp(name string, defaultValue string) *regexp.Regexp {
+	logger := logging.GetLogger()
+	value := os.Getenv(name)
+
+	if value != "" {
+		re, err := regexp.Compile(value)
+
+		if err == nil {
+			return re
+		}
+
+		logger.Printf("Invalid regexp %s in %s, using default %s", value, name, defaultValue)
+	}
+
+	return regexp.MustCompile(defaultValue)
+}
+
+// getEnvIntMap - reads comma separated list of name=number pairs from env var
+func getEnvIntMap(name string) map[string]int {
+	logger := logging.GetLogger()
+	numbers := map[string]int{}
+
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		pair := strings.SplitN(item, "=", 2)
+
+		if len(pair) != 2 {
+			logger.Printf("Invalid item %s in %s, skipping", item, name)
+			continue
+		}
+
+		number, err := strconv.Atoi(strings.TrimSpace(pair[1]))
+
+		if err != nil {
+			logger.Printf("Invalid number %s in %s, skipping", pair[1], name)
+			continue
+		}
+
+		numbers[strings.TrimSpace(pair[0])] = number
+	}
+
+	return numbers
+}
+
+// getEnvFloat - reads decimal number from env var, returns default when unset or invalid
+func getEnvFloat(name string, defaultValue float64) float64 {
+	logger := logging.GetLogger()
+	value := os.Getenv(name)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+
+	if err != nil {
+		logger.Printf("Invalid number %s in %s, using default %g", value, name, defaultValue)
+		return defaultValue
+	}
+
+	return number
+}
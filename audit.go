@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/p53/idp-api/logging"
+)
+
+const (
+	auditSucceeded = "succeeded"
+	auditFailed    = "failed"
+)
+
+// AuditEvent - record of audit trail
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	ClientID  string    `json:"clientId"`
+	Outcome   string    `json:"outcome"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// audit - writes event to audit trail
+func audit(ctx context.Context, event AuditEvent) {
+	event.Time = time.Now().UTC()
+	event.RequestID = requestID(ctx)
+	record, err := json.Marshal(event)
+
+	if err != nil {
+		logging.GetLogger().Printf("Can't write audit record %s", err)
+		return
+	}
+
+	logging.GetAuditLogger().Println(string(record))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/p53/idp-api/logging"
+)
+
+// Caller - authenticated user or client calling api
+type Caller struct {
+	Name   string
+	Groups []string
+}
+
+// tokenClaims - claims of caller access token used by api
+type tokenClaims struct {
+	Groups []string `json:"groups"`
+}
+
+// newCaller - creates caller from its access token, groups are read from groups claim
+// (keycloak group membership mapper), token signature is not checked as token was
+// just received from idp token endpoint
+func newCaller(name string, token string) Caller {
+	logger := logging.GetLogger()
+	caller := Caller{Name: name, Groups: []string{}}
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return caller
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+
+	if err != nil {
+		logger.Printf("Invalid token payload of %s %s", name, err)
+		return caller
+	}
+
+	claims := &tokenClaims{}
+
+	if errUnm := json.Unmarshal(payload, claims); errUnm != nil {
+		logger.Printf("Invalid token claims of %s %s", name, errUnm)
+		return caller
+	}
+
+	for _, group := range claims.Groups {
+		// full group path is used when mapper has full path enabled
+		caller.Groups = append(caller.Groups, strings.TrimPrefix(group, "/"))
+	}
+
+	return caller
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestNewCaller(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","groups":["/team-a","team-b"]}`))
+	caller := newCaller("test", "header."+payload+".signature")
+
+	assert.Equal(t, caller.Name, "test")
+	assert.DeepEqual(t, caller.Groups, []string{"team-a", "team-b"})
+
+	caller = newCaller("test", "not_a_jwt")
+	assert.Equal(t, len(caller.Groups), 0)
+}
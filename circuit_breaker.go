@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerHalfOpen = "half-open"
+	breakerOpen     = "open"
+)
+
+// CircuitBreaker - guards idp calls, after Threshold consecutive failures it opens
+// and fast-fails all calls until Cooldown passes, then lets single trial call through
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	mu        sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	trial     bool
+	now       func() time.Time
+}
+
+// NewCircuitBreaker - creates closed circuit breaker, zero threshold disables breaker
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+		state:     breakerClosed,
+		now:       time.Now,
+	}
+}
+
+// allow - reports whether call may proceed to idp
+func (cb *CircuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.Threshold <= 0 {
+		return true
+	}
+
+	switch cb.state {
+	case breakerOpen:
+		if cb.now().Sub(cb.openedAt) < cb.Cooldown {
+			return false
+		}
+
+		cb.state = breakerHalfOpen
+		cb.trial = true
+		return true
+	case breakerHalfOpen:
+		if cb.trial {
+			return false
+		}
+
+		cb.trial = true
+		return true
+	}
+
+	return true
+}
+
+// success - records successful call, closes breaker
+func (cb *CircuitBreaker) success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.state = breakerClosed
+	cb.failures = 0
+	cb.trial = false
+}
+
+// failure - records failed call, opens breaker when threshold is reached
+// or when trial call in half-open state fails
+func (cb *CircuitBreaker) failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.Threshold <= 0 {
+		return
+	}
+
+	cb.failures++
+	cb.trial = false
+
+	if cb.state == breakerHalfOpen || cb.failures >= cb.Threshold {
+		cb.state = breakerOpen
+		cb.openedAt = cb.now()
+	}
+}
+
+// cancelled - records call cancelled by caller, it says nothing about idp, but trial call
+// of half-open breaker frees its slot, breaker opens again for new cooldown
+func (cb *CircuitBreaker) cancelled() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != breakerHalfOpen || !cb.trial {
+		return
+	}
+
+	cb.trial = false
+	cb.state = breakerOpen
+	cb.openedAt = cb.now()
+}
+
+// State - returns current state of breaker (closed, half-open, open)
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == "" {
+		return breakerClosed
+	}
+
+	return cb.state
+}
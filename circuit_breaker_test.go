@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOpenAfterThresholdCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(2, time.Minute)
+
+	breaker.failure()
+
+	if !breaker.allow() {
+		t.Fatalf("Breaker should allow calls under threshold, state %s", breaker.State())
+	}
+
+	breaker.failure()
+
+	if breaker.allow() {
+		t.Fatalf("Breaker should reject calls, state %s", breaker.State())
+	}
+
+	if breaker.State() != breakerOpen {
+		t.Fatalf("Bad breaker state %s", breaker.State())
+	}
+}
+
+func TestSuccessResetsCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(2, time.Minute)
+
+	breaker.failure()
+	breaker.success()
+	breaker.failure()
+
+	if !breaker.allow() {
+		t.Fatalf("Breaker should count only consecutive failures, state %s", breaker.State())
+	}
+}
+
+func TestHalfOpenCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.failure()
+
+	if breaker.allow() {
+		t.Fatalf("Breaker should reject calls during cooldown, state %s", breaker.State())
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	if !breaker.allow() {
+		t.Fatalf("Breaker should allow trial call after cooldown, state %s", breaker.State())
+	}
+
+	if breaker.allow() {
+		t.Fatalf("Breaker should allow only single trial call, state %s", breaker.State())
+	}
+
+	breaker.failure()
+
+	if breaker.State() != breakerOpen {
+		t.Fatalf("Failed trial should open breaker, state %s", breaker.State())
+	}
+
+	now = now.Add(2 * time.Minute)
+	breaker.allow()
+	breaker.success()
+
+	if breaker.State() != breakerClosed {
+		t.Fatalf("Successful trial should close breaker, state %s", breaker.State())
+	}
+}
+
+func TestCancelledTrialCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(1, time.Minute)
+	breaker.now = func() time.Time { return now }
+	apiClient := &APIClient{Breaker: breaker}
+
+	breaker.failure()
+	now = now.Add(2 * time.Minute)
+
+	if !breaker.allow() {
+		t.Fatalf("Breaker should allow trial call after cooldown, state %s", breaker.State())
+	}
+
+	apiClient.recordAttempt(0, context.Canceled)
+
+	if breaker.State() != breakerOpen {
+		t.Fatalf("Cancelled trial should open breaker again, state %s", breaker.State())
+	}
+
+	if breaker.allow() {
+		t.Fatalf("Breaker should reject calls during new cooldown, state %s", breaker.State())
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	if !breaker.allow() {
+		t.Fatalf("Breaker should allow new trial call after cancelled one, state %s", breaker.State())
+	}
+
+	apiClient.recordAttempt(200, nil)
+
+	if breaker.State() != breakerClosed {
+		t.Fatalf("Successful trial should close breaker, state %s", breaker.State())
+	}
+}
+
+func TestDisabledCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(0, time.Minute)
+
+	for i := 0; i < 10; i++ {
+		breaker.failure()
+	}
+
+	if !breaker.allow() {
+		t.Fatalf("Disabled breaker should allow all calls, state %s", breaker.State())
+	}
+}
+
+func TestBackoffRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		if delay := policy.backoff(attempt); delay < 0 || delay > policy.MaxDelay {
+			t.Fatalf("Delay %s out of bounds for attempt %d", delay, attempt)
+		}
+	}
+}
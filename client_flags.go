@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/p53/idp-api/apierror"
+)
+
+const (
+	backchannelLogoutURLAttribute   = "backchannel.logout.url"
+	postLogoutRedirectURIsAttribute = "post.logout.redirect.uris"
+	deviceGrantAttribute            = "oauth2.device.authorization.grant.enabled"
+	cibaGrantAttribute              = "oidc.ciba.grant.enabled"
+	tokenExchangeAttribute          = "standard.token.exchange.enabled"
+	// postLogoutRedirectURISeparator - keycloak joins post logout redirect uris with it
+	postLogoutRedirectURISeparator = "##"
+	reasonCibaPublic               = "ciba grant requires confidential client"
+	reasonTokenExchangePublic      = "token exchange requires confidential client"
+	reasonFlagNotAllowed           = "may be enabled only by admin"
+)
+
+// defaultNonAdminFlags - flags any caller may enable when CLIENT_FLAGS_NON_ADMIN is not set
+var defaultNonAdminFlags = []string{"consentRequired", "frontchannelLogout", "backchannelLogoutUrl", "postLogoutRedirectUris"}
+
+// FlagPolicy - which client flags callers outside of admin groups may enable,
+// flags already enabled on client are kept, zero value allows none of them
+type FlagPolicy struct {
+	// NonAdminFlags - json names of flags any caller may enable
+	NonAdminFlags []string
+	// AdminGroups - callers in these groups may enable every flag
+	AdminGroups []string
+}
+
+// enabledFlags - json names of flags enabled on client
+func enabledFlags(client Client) []string {
+	flags := map[string]bool{
+		"consentRequired":                 client.ConsentRequired,
+		"frontchannelLogout":              client.FrontchannelLogout,
+		"backchannelLogoutUrl":            client.BackchannelLogoutURL != "",
+		"postLogoutRedirectUris":          len(client.PostLogoutRedirectUris) > 0,
+		"deviceAuthorizationGrantEnabled": client.DeviceAuthorizationGrantEnabled,
+		"cibaGrantEnabled":                client.CibaGrantEnabled,
+		"tokenExchangeEnabled":            client.TokenExchangeEnabled,
+	}
+	enabled := []string{}
+
+	for flag, set := range flags {
+		if set {
+			enabled = append(enabled, flag)
+		}
+	}
+
+	sort.Strings(enabled)
+	return enabled
+}
+
+// check - returns error listing flags caller may not enable, clientInfo is client before change, nil on create
+func (policy FlagPolicy) check(caller Caller, client Client, clientInfo *ClientOut) error {
+	for _, group := range caller.Groups {
+		if contains(policy.AdminGroups, group) {
+			return nil
+		}
+	}
+
+	kept := []string{}
+
+	if clientInfo != nil {
+		kept = enabledFlags(clientInfo.client())
+	}
+
+	fieldErrors := []apierror.FieldError{}
+
+	for _, flag := range enabledFlags(client) {
+		if !contains(kept, flag) && !contains(policy.NonAdminFlags, flag) {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: flag, Reason: reasonFlagNotAllowed})
+		}
+	}
+
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+
+	return apierror.WithFieldErrors(apierror.ClientFlagNotAllowed(), fieldErrors)
+}
+
+// clientFlagErrors - checks logout uris, ciba and token exchange need credentials of client
+func clientFlagErrors(client Client) []apierror.FieldError {
+	fieldErrors := []apierror.FieldError{}
+
+	if client.BackchannelLogoutURL != "" {
+		if reason := redirectURIReason(client.BackchannelLogoutURL); reason != "" {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: "backchannelLogoutUrl", Reason: reason})
+		}
+	}
+
+	for i, uri := range client.PostLogoutRedirectUris {
+		// "+" is keycloak shortcut for redirect uris of client
+		if uri == "+" {
+			continue
+		}
+
+		if reason := redirectURIReason(uri); reason != "" {
+			fieldErrors = append(fieldErrors, apierror.FieldError{
+				Field:  fmt.Sprintf("postLogoutRedirectUris[%d]", i),
+				Reason: reason,
+			})
+		}
+	}
+
+	if client.CibaGrantEnabled && client.PublicClient {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "cibaGrantEnabled", Reason: reasonCibaPublic})
+	}
+
+	if client.TokenExchangeEnabled && client.PublicClient {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "tokenExchangeEnabled", Reason: reasonTokenExchangePublic})
+	}
+
+	return fieldErrors
+}
+
+// configureClientFlags - stores flags which are not part of keycloak client representation in attributes
+func configureClientFlags(client *Client) {
+	if client.Protocol == samlProtocol {
+		return
+	}
+
+	attributes := clientAttributes(client)
+	attributes[backchannelLogoutURLAttribute] = client.BackchannelLogoutURL
+	attributes[postLogoutRedirectURIsAttribute] = strings.Join(client.PostLogoutRedirectUris, postLogoutRedirectURISeparator)
+	attributes[deviceGrantAttribute] = strconv.FormatBool(client.DeviceAuthorizationGrantEnabled)
+	attributes[cibaGrantAttribute] = strconv.FormatBool(client.CibaGrantEnabled)
+	attributes[tokenExchangeAttribute] = strconv.FormatBool(client.TokenExchangeEnabled)
+
+	client.BackchannelLogoutURL = ""
+	client.PostLogoutRedirectUris = nil
+	client.DeviceAuthorizationGrantEnabled = false
+	client.CibaGrantEnabled = false
+	client.TokenExchangeEnabled = false
+}
+
+// readClientFlags - reads flags back from keycloak attributes
+func readClientFlags(clientOut *ClientOut, client *Client) {
+	attributes := clientOut.Attributes
+	client.BackchannelLogoutURL = attributes[backchannelLogoutURLAttribute]
+	client.DeviceAuthorizationGrantEnabled = attributes[deviceGrantAttribute] == "true"
+	client.CibaGrantEnabled = attributes[cibaGrantAttribute] == "true"
+	client.TokenExchangeEnabled = attributes[tokenExchangeAttribute] == "true"
+
+	if uris := attributes[postLogoutRedirectURIsAttribute]; uris != "" {
+		client.PostLogoutRedirectUris = strings.Split(uris, postLogoutRedirectURISeparator)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+func TestConfigureClientFlags(t *testing.T) {
+	client := mustDecodeClient(t, `{"clientId": "test", "backchannelLogoutUrl": "https://app.example.com/logout",
+		"postLogoutRedirectUris": ["https://app.example.com/", "+"], "deviceAuthorizationGrantEnabled": true}`)
+	configureClientFlags(&client)
+
+	assert.Equal(t, client.BackchannelLogoutURL, "")
+	assert.Assert(t, !client.DeviceAuthorizationGrantEnabled)
+	assert.DeepEqual(t, client.Attributes, map[string]string{
+		backchannelLogoutURLAttribute:   "https://app.example.com/logout",
+		postLogoutRedirectURIsAttribute: "https://app.example.com/##+",
+		deviceGrantAttribute:            "true",
+		cibaGrantAttribute:              "false",
+		tokenExchangeAttribute:          "false",
+	})
+
+	read := (&ClientOut{ClientID: "test", Attributes: client.Attributes}).client()
+	assert.Equal(t, read.BackchannelLogoutURL, "https://app.example.com/logout")
+	assert.DeepEqual(t, read.PostLogoutRedirectUris, []string{"https://app.example.com/", "+"})
+	assert.Assert(t, read.DeviceAuthorizationGrantEnabled)
+	assert.Assert(t, !read.CibaGrantEnabled)
+}
+
+func TestClientFlagRules(t *testing.T) {
+	client := mustDecodeClient(t, `{"clientId": "test", "publicClient": true, "backchannelLogoutUrl": "/logout",
+		"postLogoutRedirectUris": ["https://app.example.com/#end"], "cibaGrantEnabled": true, "tokenExchangeEnabled": true}`)
+
+	assert.DeepEqual(t, clientFlagErrors(client), []apierror.FieldError{
+		{Field: "backchannelLogoutUrl", Reason: reasonRelativeURI},
+		{Field: "postLogoutRedirectUris[0]", Reason: reasonURIFragment},
+		{Field: "cibaGrantEnabled", Reason: reasonCibaPublic},
+		{Field: "tokenExchangeEnabled", Reason: reasonTokenExchangePublic},
+	})
+}
+
+func TestFlagPolicy(t *testing.T) {
+	policy := FlagPolicy{NonAdminFlags: defaultNonAdminFlags, AdminGroups: []string{"platform"}}
+	client := mustDecodeClient(t, `{"clientId": "test", "consentRequired": true, "deviceAuthorizationGrantEnabled": true, "tokenExchangeEnabled": true}`)
+
+	err := policy.check(Caller{Name: "alice", Groups: []string{"team-a"}}, client, nil)
+
+	assert.Equal(t, apierror.CodeOf(err), "1036")
+	assert.DeepEqual(t, err.(*apierror.ApiError).Errors, []apierror.FieldError{
+		{Field: "deviceAuthorizationGrantEnabled", Reason: reasonFlagNotAllowed},
+		{Field: "tokenExchangeEnabled", Reason: reasonFlagNotAllowed},
+	})
+	assert.NilError(t, policy.check(Caller{Name: "bob", Groups: []string{"platform"}}, client, nil))
+
+	// flags enabled by admin are kept on update by non-admin
+	current := &ClientOut{ClientID: "test", Attributes: map[string]string{deviceGrantAttribute: "true", tokenExchangeAttribute: "true"}}
+	assert.NilError(t, policy.check(Caller{Name: "alice"}, client, current))
+}
+
+func TestCreateClientFlagNotAllowed(t *testing.T) {
+	apiClient := newAPIClientScopesMock()
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.FlagPolicy = FlagPolicy{NonAdminFlags: defaultNonAdminFlags}
+
+	payload := `{"clientId": "test", "serviceAccountsEnabled": true, "cibaGrantEnabled": true}`
+	rr := sendRequest(t, testConfig, "POST", "/client", payload, nil)
+
+	assert.Equal(t, rr.Code, 403, rr.Body.String())
+	assert.DeepEqual(t, fieldErrorsOf(t, rr), []apierror.FieldError{
+		{Field: "cibaGrantEnabled", Reason: reasonFlagNotAllowed},
+	})
+	assert.Assert(t, apiClient.created == nil)
+}
+
+func TestSamlRejectsOidcFlags(t *testing.T) {
+	client := mustDecodeClient(t, `{"clientId": "sp", "protocol": "saml", "tokenExchangeEnabled": true, "consentRequired": true}`)
+
+	assert.DeepEqual(t, samlErrors(client)[0], apierror.FieldError{Field: "tokenExchangeEnabled", Reason: reasonNotSaml})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// ClientIterator - iterates over all realm clients page by page, for places
+// which really need whole client list (single client is looked up by getClient)
+type ClientIterator struct {
+	ctx        context.Context
+	w          http.ResponseWriter
+	controller *Controller
+	token      string
+	first      int
+	pageSize   int
+	done       bool
+}
+
+// newClientIterator - creates iterator starting at first client
+func newClientIterator(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string) *ClientIterator {
+	pageSize := controller.Config.ClientsPageSize
+
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+
+	return &ClientIterator{
+		ctx:        ctx,
+		w:          w,
+		controller: controller,
+		token:      token,
+		pageSize:   pageSize,
+	}
+}
+
+// Next - returns next page of clients, empty page signals end of list
+func (it *ClientIterator) Next() (clients []ClientOut, err error) {
+	if it.done {
+		return nil, nil
+	}
+
+	httpClient := it.controller.Config.HTTPClient
+	clients, err = httpClient.listClients(it.ctx, it.w, it.controller, it.token, it.first, it.pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	it.first += len(clients)
+
+	if len(clients) < it.pageSize {
+		it.done = true
+	}
+
+	return clients, nil
+}
+
+// forEachClient - calls f for every realm client until f returns false
+func forEachClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	f func(client ClientOut) bool) error {
+	it := newClientIterator(ctx, w, controller, token)
+
+	for {
+		clients, err := it.Next()
+
+		if err != nil {
+			return err
+		}
+
+		if len(clients) == 0 {
+			return nil
+		}
+
+		for _, client := range clients {
+			if !f(client) {
+				return nil
+			}
+		}
+	}
+}
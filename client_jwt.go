@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/p53/idp-api/apierror"
+)
+
+const (
+	clientSecretAuthenticator = "client-secret"
+	clientJWTAuthenticator    = "client-jwt"
+	clientAssertionType       = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// ClientAssertionHeader - header carrying signed client assertion of client-jwt client
+	ClientAssertionHeader = "X-Client-Assertion"
+
+	useJwksURLAttribute    = "use.jwks.url"
+	jwksURLAttribute       = "jwks.url"
+	useJwksStringAttribute = "use.jwks.string"
+	jwksStringAttribute    = "jwks.string"
+	certificateAttribute   = "jwt.credential.certificate"
+)
+
+// jsonWebKey - fields of JWK checked by api
+type jsonWebKey struct {
+	Kty string   `json:"kty"`
+	Use string   `json:"use"`
+	X5c []string `json:"x5c"`
+	D   string   `json:"d"`
+}
+
+// jsonWebKeySet - JWKS of client-jwt client
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// clientJWTErrors - checks authenticator type and keys of client-jwt client
+func clientJWTErrors(client Client) []apierror.FieldError {
+	fieldErrors := []apierror.FieldError{}
+	hasKeys := client.JwksURL != "" || len(client.Jwks) > 0
+
+	switch client.ClientAuthenticatorType {
+	case "", clientSecretAuthenticator:
+		if hasKeys {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: "clientAuthenticatorType", Reason: reasonKeysWithoutJWT})
+		}
+
+		return fieldErrors
+	case clientJWTAuthenticator:
+	default:
+		return append(fieldErrors, apierror.FieldError{Field: "clientAuthenticatorType", Reason: reasonUnsupportedAuth})
+	}
+
+	if client.PublicClient {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "clientAuthenticatorType", Reason: reasonPublicJWT})
+	}
+
+	if client.JwksURL != "" && len(client.Jwks) > 0 {
+		return append(fieldErrors, apierror.FieldError{Field: "jwks", Reason: reasonJwksExclusive})
+	}
+
+	if !hasKeys {
+		return append(fieldErrors, apierror.FieldError{Field: "jwks", Reason: reasonMissing})
+	}
+
+	if client.JwksURL != "" {
+		if u, err := url.Parse(client.JwksURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: "jwksUrl", Reason: reasonJwksURL})
+		}
+
+		return fieldErrors
+	}
+
+	return append(fieldErrors, jwksErrors(client.Jwks)...)
+}
+
+// jwksErrors - JWKS must contain at least one public key
+func jwksErrors(raw json.RawMessage) []apierror.FieldError {
+	keySet := jsonWebKeySet{}
+
+	if err := json.Unmarshal(raw, &keySet); err != nil || len(keySet.Keys) == 0 {
+		return []apierror.FieldError{{Field: "jwks", Reason: reasonMalformedJwks}}
+	}
+
+	fieldErrors := []apierror.FieldError{}
+
+	for i, key := range keySet.Keys {
+		field := fmt.Sprintf("jwks.keys[%d]", i)
+
+		if key.Kty == "" {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: field, Reason: reasonMalformedJwks})
+		}
+
+		if key.D != "" {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: field, Reason: reasonPrivateKey})
+		}
+	}
+
+	return fieldErrors
+}
+
+// configureClientJWT - moves keys of client-jwt client to keycloak attributes, certificate
+// attribute is filled from first signing key with certificate chain
+func configureClientJWT(client *Client) {
+	if client.ClientAuthenticatorType != clientJWTAuthenticator {
+		return
+	}
+
+	attributes := clientAttributes(client)
+
+	if client.JwksURL != "" {
+		attributes[useJwksURLAttribute] = "true"
+		attributes[jwksURLAttribute] = client.JwksURL
+		attributes[useJwksStringAttribute] = "false"
+	}
+
+	if len(client.Jwks) > 0 {
+		attributes[useJwksStringAttribute] = "true"
+		attributes[jwksStringAttribute] = string(client.Jwks)
+		attributes[useJwksURLAttribute] = "false"
+
+		keySet := jsonWebKeySet{}
+		json.Unmarshal(client.Jwks, &keySet)
+
+		for _, key := range keySet.Keys {
+			if (key.Use == "" || key.Use == "sig") && len(key.X5c) > 0 {
+				attributes[certificateAttribute] = key.X5c[0]
+				break
+			}
+		}
+	}
+
+	// keys are not part of keycloak client representation
+	client.JwksURL = ""
+	client.Jwks = nil
+}
+
+// clientKeys - reads keys of client-jwt client back from keycloak attributes
+func clientKeys(clientOut *ClientOut) (jwksURL string, jwks json.RawMessage) {
+	if clientOut.ClientAuthenticatorType != clientJWTAuthenticator {
+		return "", nil
+	}
+
+	if clientOut.Attributes[useJwksURLAttribute] == "true" {
+		return clientOut.Attributes[jwksURLAttribute], nil
+	}
+
+	if clientOut.Attributes[useJwksStringAttribute] == "true" {
+		return "", json.RawMessage(clientOut.Attributes[jwksStringAttribute])
+	}
+
+	return "", nil
+}
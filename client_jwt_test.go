@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+var testJWTPayload = `{
+  "clientId": "test",
+  "serviceAccountsEnabled": true,
+  "clientAuthenticatorType": "client-jwt",
+  "jwks": {"keys": [{"kty": "RSA", "use": "sig", "n": "AQAB", "e": "AQAB", "x5c": ["MIIC"]}]}
+}`
+
+// APIClientJWTMock - manages client-jwt client, accepts only assertion "valid"
+type APIClientJWTMock struct {
+	APIClientRecordingMock
+	assertions []string
+}
+
+func newAPIClientJWTMock() *APIClientJWTMock {
+	return &APIClientJWTMock{APIClientRecordingMock: APIClientRecordingMock{client: ClientOut{
+		ID:                      "test-uid",
+		ServiceAccountsEnabled:  true,
+		ClientAuthenticatorType: clientJWTAuthenticator,
+		Attributes: map[string]string{
+			useJwksURLAttribute: "true",
+			jwksURLAttribute:    "https://example.com/jwks",
+		},
+	}}}
+}
+
+func (s *APIClientJWTMock) verifyClientAssertion(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	clientID string,
+	assertion string) (err error) {
+	s.assertions = append(s.assertions, assertion)
+
+	if assertion != "valid" {
+		inverr := apierror.BadClientAssertion()
+		writeError(ctx, w, inverr)
+		return inverr
+	}
+
+	return nil
+}
+
+func TestClientJWTRules(t *testing.T) {
+	cases := map[string][]apierror.FieldError{
+		`{"clientId": "test", "clientAuthenticatorType": "x509"}`: {
+			{Field: "clientAuthenticatorType", Reason: reasonUnsupportedAuth},
+		},
+		`{"clientId": "test", "jwksUrl": "https://example.com/jwks"}`: {
+			{Field: "clientAuthenticatorType", Reason: reasonKeysWithoutJWT},
+		},
+		`{"clientId": "test", "clientAuthenticatorType": "client-jwt"}`: {
+			{Field: "jwks", Reason: reasonMissing},
+		},
+		`{"clientId": "test", "clientAuthenticatorType": "client-jwt", "jwksUrl": "http://example.com/jwks"}`: {
+			{Field: "jwksUrl", Reason: reasonJwksURL},
+		},
+		`{"clientId": "test", "clientAuthenticatorType": "client-jwt", "jwks": {"keys": []}}`: {
+			{Field: "jwks", Reason: reasonMalformedJwks},
+		},
+		`{"clientId": "test", "clientAuthenticatorType": "client-jwt", "jwks": {"keys": [{"kty": "RSA", "d": "secret"}]}}`: {
+			{Field: "jwks.keys[0]", Reason: reasonPrivateKey},
+		},
+	}
+
+	for payload, expected := range cases {
+		assert.DeepEqual(t, validateClient(mustDecodeClient(t, payload)), expected)
+	}
+}
+
+func TestCreateClientJWT(t *testing.T) {
+	apiClient := newAPIClientJWTMock()
+	rr := sendRequest(t, getMockedTestConfig(apiClient), "POST", "/client", testJWTPayload, nil)
+
+	if rr.Code != 201 {
+		t.Fatalf("Wrong response code %d %s", rr.Code, rr.Body.String())
+	}
+
+	created := apiClient.created
+	assert.Equal(t, created.ClientAuthenticatorType, clientJWTAuthenticator)
+	assert.Equal(t, created.Attributes[useJwksStringAttribute], "true")
+	assert.Equal(t, created.Attributes[certificateAttribute], "MIIC")
+	assert.Assert(t, created.Jwks == nil)
+
+	client := &Client{}
+
+	if err := json.Unmarshal(rr.Body.Bytes(), client); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, client.JwksURL, "https://example.com/jwks")
+}
+
+func TestUpdateClientJWT(t *testing.T) {
+	payload := `{"clientId": "test", "clientAuthenticatorType": "client-jwt", "jwksUrl": "https://example.com/jwks2", "clientAssertion": "valid"}`
+	apiClient := newAPIClientJWTMock()
+	rr := sendRequest(t, getMockedTestConfig(apiClient), "PUT", "/client", payload, nil)
+
+	if rr.Code != 201 {
+		t.Fatalf("Wrong response code %d %s", rr.Code, rr.Body.String())
+	}
+
+	assert.DeepEqual(t, apiClient.assertions, []string{"valid"})
+	assert.Equal(t, apiClient.updated.Attributes[jwksURLAttribute], "https://example.com/jwks2")
+	assert.Equal(t, apiClient.updated.JwksURL, "")
+}
+
+func TestDeleteClientJWTRejected(t *testing.T) {
+	cases := map[string]string{
+		"1007": `{"clientId": "test", "clientSecret": "testsecret"}`,
+		"1034": `{"clientId": "test", "clientAssertion": "forged"}`,
+	}
+
+	for code, payload := range cases {
+		rr := sendRequest(t, getMockedTestConfig(newAPIClientJWTMock()), "DELETE", "/client", payload, nil)
+
+		retErr := &apierror.ApiError{}
+
+		if errAPI := json.Unmarshal(rr.Body.Bytes(), retErr); errAPI != nil {
+			t.Fatalf("Single problem expected %s", rr.Body.String())
+		}
+
+		assert.Equal(t, retErr.Code, code)
+	}
+}
+
+// APIClientJWTFailureMock - returns client-jwt client, idp fails to verify assertion
+type APIClientJWTFailureMock struct {
+	APIClientInternalServerErrorMock
+}
+
+func (s *APIClientJWTFailureMock) getClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client) (clientOut *ClientOut, err error) {
+	return &ClientOut{ID: "test-uid", ClientID: client.ClientID, ClientAuthenticatorType: clientJWTAuthenticator}, nil
+}
+
+func TestDeleteClientJWTVerificationFailed(t *testing.T) {
+	payload := `{"clientId": "test", "clientAssertion": "valid"}`
+	rr := sendRequest(t, getMockedTestConfig(&APIClientJWTFailureMock{}), "DELETE", "/client", payload, nil)
+
+	assert.Equal(t, rr.Code, 500, rr.Body.String())
+
+	retErr := &apierror.ApiError{}
+
+	if errAPI := json.Unmarshal(rr.Body.Bytes(), retErr); errAPI != nil {
+		t.Fatalf("Single problem expected %s", rr.Body.String())
+	}
+
+	assert.Equal(t, retErr.Code, apierror.CodeOf(apierror.UpstreamError()))
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/p53/idp-api/apierror"
+	"github.com/p53/idp-api/logging"
+)
+
+const (
+	reasonSelfComposite = "role can't contain itself"
+	reasonUnknownRole   = "unknown role of client"
+	reasonRoleRename    = "role can't be renamed, must equal role in path"
+)
+
+// Role - keycloak role representation
+type Role struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Composite   bool   `json:"composite"`
+	ClientRole  bool   `json:"clientRole"`
+	ContainerID string `json:"containerId,omitempty"`
+}
+
+// ClientRole - role of client managed through api, composites are limited to roles
+// of same client so owner can't grant roles it doesn't control
+type ClientRole struct {
+	Name        string `json:"name" validate:"nonzero"`
+	Description string `json:"description"`
+	// Composites - names of roles of same client contained in role
+	Composites []string `json:"composites"`
+}
+
+// ListClientRoles - returns roles of client with their composites
+func (controller *Controller) ListClientRoles(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetLogger()
+	token, _, clientInfo, err := controller.ownedClient(w, r)
+
+	if err != nil {
+		return
+	}
+
+	roles, err := controller.clientRoles(r.Context(), w, token, clientInfo)
+
+	if err != nil {
+		return
+	}
+
+	rolesOut, errMar := json.Marshal(roles)
+
+	if errMar != nil {
+		logger.Println(errMar)
+		writeError(r.Context(), w, apierror.InternalServerError())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(rolesOut)
+}
+
+// CreateClientRole - creates role of client
+func (controller *Controller) CreateClientRole(w http.ResponseWriter, r *http.Request) {
+	httpClient := controller.Config.HTTPClient
+	token, caller, clientInfo, err := controller.ownedClient(w, r)
+
+	if err != nil {
+		return
+	}
+
+	role, existing, err := controller.readClientRole(w, r, token, clientInfo)
+
+	if err != nil {
+		return
+	}
+
+	err = httpClient.createClientRole(r.Context(), w, controller, token, clientInfo.ID, Role{Name: role.Name, Description: role.Description})
+	auditRole(r.Context(), caller, "client.role.create", clientInfo, role.Name, err)
+
+	if err != nil {
+		return
+	}
+
+	if err := controller.reconcileComposites(r.Context(), w, token, clientInfo, role, []string{}, existing); err != nil {
+		return
+	}
+
+	writeClientRole(r.Context(), w, http.StatusCreated, role)
+}
+
+// UpdateClientRole - updates description and composites of client role
+func (controller *Controller) UpdateClientRole(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetLogger()
+	httpClient := controller.Config.HTTPClient
+	token, caller, clientInfo, err := controller.ownedClient(w, r)
+
+	if err != nil {
+		return
+	}
+
+	role, existing, err := controller.readClientRole(w, r, token, clientInfo)
+
+	if err != nil {
+		return
+	}
+
+	if roleName := pathVar(r, "roleName"); role.Name != roleName {
+		inverr := validationError([]apierror.FieldError{{Field: "name", Reason: reasonRoleRename}})
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	current, err := controller.roleComposites(r.Context(), w, token, clientInfo, role.Name, existing)
+
+	if err != nil {
+		return
+	}
+
+	err = httpClient.updateClientRole(r.Context(), w, controller, token, clientInfo.ID, role.Name, Role{Name: role.Name, Description: role.Description})
+	auditRole(r.Context(), caller, "client.role.update", clientInfo, role.Name, err)
+
+	if err != nil {
+		return
+	}
+
+	if err := controller.reconcileComposites(r.Context(), w, token, clientInfo, role, current, existing); err != nil {
+		return
+	}
+
+	writeClientRole(r.Context(), w, http.StatusOK, role)
+}
+
+// DeleteClientRole - deletes role of client
+func (controller *Controller) DeleteClientRole(w http.ResponseWriter, r *http.Request) {
+	httpClient := controller.Config.HTTPClient
+	token, caller, clientInfo, err := controller.ownedClient(w, r)
+
+	if err != nil {
+		return
+	}
+
+	roleName := pathVar(r, "roleName")
+	err = httpClient.deleteClientRole(r.Context(), w, controller, token, clientInfo.ID, roleName)
+	auditRole(r.Context(), caller, "client.role.delete", clientInfo, roleName, err)
+
+	if err != nil {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readClientRole - decodes and validates role from request, returns it with existing roles of client
+func (controller *Controller) readClientRole(
+	w http.ResponseWriter,
+	r *http.Request,
+	token string,
+	clientInfo *ClientOut) (ClientRole, []Role, error) {
+	logger := logging.GetLogger()
+	var role ClientRole
+	defer r.Body.Close()
+
+	if errDec := decodeStrict(r.Body, &role); errDec != nil {
+		logger.Println(errDec)
+		writeError(r.Context(), w, errDec)
+		return role, nil, errDec
+	}
+
+	existing, err := controller.Config.HTTPClient.listClientRoles(r.Context(), w, controller, token, clientInfo.ID)
+
+	if err != nil {
+		return role, nil, err
+	}
+
+	fieldErrors := append(requiredFieldErrors(role), roleRuleErrors(role, existing)...)
+
+	if inverr := validationError(fieldErrors); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return role, nil, inverr
+	}
+
+	return role, existing, nil
+}
+
+// roleRuleErrors - composites must be other existing roles of client
+func roleRuleErrors(role ClientRole, existing []Role) []apierror.FieldError {
+	fieldErrors := []apierror.FieldError{}
+
+	for i, composite := range role.Composites {
+		field := fmt.Sprintf("composites[%d]", i)
+
+		if composite == role.Name {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: field, Reason: reasonSelfComposite})
+		} else if findRole(existing, composite) == nil {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: field, Reason: reasonUnknownRole})
+		}
+	}
+
+	return fieldErrors
+}
+
+// findRole - returns role with given name or nil
+func findRole(roles []Role, name string) *Role {
+	for i := range roles {
+		if roles[i].Name == name {
+			return &roles[i]
+		}
+	}
+
+	return nil
+}
+
+// clientRoles - roles of client in api representation
+func (controller *Controller) clientRoles(
+	ctx context.Context,
+	w http.ResponseWriter,
+	token string,
+	clientInfo *ClientOut) ([]ClientRole, error) {
+	existing, err := controller.Config.HTTPClient.listClientRoles(ctx, w, controller, token, clientInfo.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []ClientRole{}
+
+	for _, role := range existing {
+		composites, err := controller.roleComposites(ctx, w, token, clientInfo, role.Name, existing)
+
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, ClientRole{Name: role.Name, Description: role.Description, Composites: composites})
+	}
+
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Name < roles[j].Name
+	})
+
+	return roles, nil
+}
+
+// roleComposites - names of roles of same client contained in role, composites from
+// other containers were not added through api and are left untouched
+func (controller *Controller) roleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	token string,
+	clientInfo *ClientOut,
+	roleName string,
+	existing []Role) ([]string, error) {
+	names := []string{}
+	role := findRole(existing, roleName)
+
+	if role == nil || !role.Composite {
+		return names, nil
+	}
+
+	composites, err := controller.Config.HTTPClient.getClientRoleComposites(ctx, w, controller, token, clientInfo.ID, roleName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, composite := range composites {
+		if composite.ClientRole && composite.ContainerID == clientInfo.ID {
+			names = append(names, composite.Name)
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// reconcileComposites - adds and removes composites so role contains exactly requested roles of client
+func (controller *Controller) reconcileComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	token string,
+	clientInfo *ClientOut,
+	role ClientRole,
+	current []string,
+	existing []Role) error {
+	httpClient := controller.Config.HTTPClient
+	added := []Role{}
+	removed := []Role{}
+
+	for _, name := range role.Composites {
+		if !contains(current, name) {
+			added = append(added, *findRole(existing, name))
+		}
+	}
+
+	for _, name := range current {
+		if !contains(role.Composites, name) {
+			removed = append(removed, *findRole(existing, name))
+		}
+	}
+
+	if len(added) > 0 {
+		if err := httpClient.addClientRoleComposites(ctx, w, controller, token, clientInfo.ID, role.Name, added); err != nil {
+			return err
+		}
+	}
+
+	if len(removed) > 0 {
+		if err := httpClient.removeClientRoleComposites(ctx, w, controller, token, clientInfo.ID, role.Name, removed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeClientRole - writes role as json response
+func writeClientRole(ctx context.Context, w http.ResponseWriter, status int, role ClientRole) {
+	if role.Composites == nil {
+		role.Composites = []string{}
+	}
+
+	roleOut, err := json.Marshal(role)
+
+	if err != nil {
+		logging.GetLogger().Println(err)
+		writeError(ctx, w, apierror.InternalServerError())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(roleOut)
+}
+
+// auditRole - writes change of client role to audit trail
+func auditRole(ctx context.Context, caller Caller, action string, clientInfo *ClientOut, roleName string, err error) {
+	outcome := auditSucceeded
+
+	if err != nil {
+		outcome = auditFailed
+	}
+
+	audit(ctx, AuditEvent{
+		Actor:    caller.Name,
+		Action:   action,
+		ClientID: clientInfo.ClientID,
+		Outcome:  outcome,
+		Detail:   fmt.Sprintf("role %s", roleName),
+	})
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+// APIClientRolesMock - keeps roles and composites of client "test-uid" in memory
+type APIClientRolesMock struct {
+	APIClientRecordingMock
+	roles      []Role
+	composites map[string][]Role
+}
+
+func newAPIClientRolesMock(names ...string) *APIClientRolesMock {
+	mock := &APIClientRolesMock{composites: map[string][]Role{}}
+	mock.client = testExistingClient
+
+	for _, name := range names {
+		mock.roles = append(mock.roles, Role{ID: name + "-id", Name: name, ClientRole: true, ContainerID: "test-uid"})
+	}
+
+	return mock
+}
+
+func (s *APIClientRolesMock) listClientRoles(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (roles []Role, err error) {
+	return append([]Role{}, s.roles...), nil
+}
+
+func (s *APIClientRolesMock) createClientRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	role Role) (err error) {
+	role.ClientRole = true
+	role.ContainerID = clientUID
+	s.roles = append(s.roles, role)
+	return nil
+}
+
+func (s *APIClientRolesMock) updateClientRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string,
+	role Role) (err error) {
+	findRole(s.roles, roleName).Description = role.Description
+	return nil
+}
+
+func (s *APIClientRolesMock) deleteClientRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string) (err error) {
+	if findRole(s.roles, roleName) == nil {
+		inverr := apierror.ResourceNotFound()
+		writeError(ctx, w, inverr)
+		return inverr
+	}
+
+	return nil
+}
+
+func (s *APIClientRolesMock) getClientRoleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string) (roles []Role, err error) {
+	return s.composites[roleName], nil
+}
+
+func (s *APIClientRolesMock) addClientRoleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string,
+	roles []Role) (err error) {
+	s.composites[roleName] = append(s.composites[roleName], roles...)
+	findRole(s.roles, roleName).Composite = true
+	return nil
+}
+
+func (s *APIClientRolesMock) removeClientRoleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string,
+	roles []Role) (err error) {
+	kept := []Role{}
+
+	for _, composite := range s.composites[roleName] {
+		if findRole(roles, composite.Name) == nil {
+			kept = append(kept, composite)
+		}
+	}
+
+	s.composites[roleName] = kept
+	return nil
+}
+
+func TestClientRoles(t *testing.T) {
+	apiClient := newAPIClientRolesMock("reader", "writer")
+
+	rr := sendRequest(t, getMockedTestConfig(apiClient), "POST", "/client/test/roles", `{"name": "admin", "composites": ["reader", "writer"]}`, nil)
+	assert.Equal(t, rr.Code, 201, rr.Body.String())
+
+	composites := []string{}
+
+	for _, role := range apiClient.composites["admin"] {
+		composites = append(composites, role.ID)
+	}
+
+	sort.Strings(composites)
+	assert.DeepEqual(t, composites, []string{"reader-id", "writer-id"})
+
+	rr = sendRequest(t, getMockedTestConfig(apiClient), "PUT", "/client/test/roles/admin", `{"name": "admin", "description": "all", "composites": ["reader"]}`, nil)
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+
+	rr = sendRequest(t, getMockedTestConfig(apiClient), "GET", "/client/test/roles", "", nil)
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+
+	roles := []ClientRole{}
+
+	if err := json.Unmarshal(rr.Body.Bytes(), &roles); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.DeepEqual(t, roles, []ClientRole{
+		{Name: "admin", Description: "all", Composites: []string{"reader"}},
+		{Name: "reader", Composites: []string{}},
+		{Name: "writer", Composites: []string{}},
+	})
+
+	rr = sendRequest(t, getMockedTestConfig(apiClient), "DELETE", "/client/test/roles/admin", "", nil)
+	assert.Equal(t, rr.Code, 204)
+}
+
+func TestClientRolesRejected(t *testing.T) {
+	cases := []struct {
+		method  string
+		path    string
+		payload string
+		code    string
+	}{
+		{"POST", "/client/test/roles", `{"name": "admin", "composites": ["admin", "unknown"]}`, "1021"},
+		{"POST", "/client/test/roles", `{"description": "no name"}`, "1007"},
+		{"PUT", "/client/test/roles/reader", `{"name": "renamed"}`, "1021"},
+		{"DELETE", "/client/test/roles/unknown", "", "1016"},
+	}
+
+	for _, c := range cases {
+		rr := sendRequest(t, getMockedTestConfig(newAPIClientRolesMock("reader")), c.method, c.path, c.payload, nil)
+
+		retErr := &apierror.ApiError{}
+
+		if errAPI := json.Unmarshal(rr.Body.Bytes(), retErr); errAPI != nil {
+			t.Fatalf("Single problem expected %s", rr.Body.String())
+		}
+
+		assert.Equal(t, retErr.Code, c.code)
+	}
+}
+
+func TestClientRolesNotOwner(t *testing.T) {
+	apiClient := &APIClientRecordingMock{caller: "bob", client: ClientOut{
+		ID:           "test-uid",
+		PublicClient: true,
+		Attributes:   map[string]string{ownerAttribute: "alice"},
+	}}
+	rr := sendRequest(t, getMockedTestConfig(apiClient), "GET", "/client/test/roles", "", nil)
+	assert.Equal(t, rr.Code, 403)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/p53/idp-api/apierror"
+	"github.com/p53/idp-api/logging"
+)
+
+const (
+	defaultScopeKind  = "default"
+	optionalScopeKind = "optional"
+)
+
+// ClientScope - structure for client scope of realm
+type ClientScope struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+}
+
+// clientScopeErrors - scope can be assigned to client either as default or as optional
+func clientScopeErrors(client Client) []apierror.FieldError {
+	fieldErrors := []apierror.FieldError{}
+	defaults := map[string]bool{}
+
+	for _, name := range client.DefaultClientScopes {
+		defaults[name] = true
+	}
+
+	for i, name := range client.OptionalClientScopes {
+		if defaults[name] {
+			fieldErrors = append(fieldErrors, apierror.FieldError{
+				Field:  fmt.Sprintf("optionalClientScopes[%d]", i),
+				Reason: reasonScopeDefaultOptional,
+			})
+		}
+	}
+
+	return fieldErrors
+}
+
+// withoutClientScopes - client scopes are assigned after creation, so idp assigns realm defaults first
+func withoutClientScopes(client Client) Client {
+	client.DefaultClientScopes = nil
+	client.OptionalClientScopes = nil
+	return client
+}
+
+// realmScopes - checks requested client scopes exist in realm, match protocol of client and are
+// in allow-list, empty allow-list permits no new scopes, scopes already assigned to clientInfo
+// are kept even when not allowed, returns realm scopes by name, nil when client doesn't request any scopes
+func (controller *Controller) realmScopes(
+	ctx context.Context,
+	w http.ResponseWriter,
+	token string,
+	client Client,
+	clientInfo *ClientOut) (map[string]ClientScope, error) {
+	if client.DefaultClientScopes == nil && client.OptionalClientScopes == nil {
+		return nil, nil
+	}
+
+	realmScopes, err := controller.Config.HTTPClient.listClientScopes(ctx, w, controller, token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := map[string]ClientScope{}
+
+	for _, scope := range realmScopes {
+		scopes[scope.Name] = scope
+	}
+
+	assigned := map[string]bool{}
+
+	if clientInfo != nil {
+		for _, name := range clientInfo.DefaultClientScopes {
+			assigned[name] = true
+		}
+
+		for _, name := range clientInfo.OptionalClientScopes {
+			assigned[name] = true
+		}
+	}
+
+	fieldErrors := []apierror.FieldError{}
+	check := func(field string, names []string) {
+		for i, name := range names {
+			scope, ok := scopes[name]
+			reason := ""
+
+			switch {
+			case !ok:
+				reason = reasonUnknownScope
+			case protocolOf(scope.Protocol) != protocolOf(client.Protocol):
+				reason = reasonScopeProtocol
+			case !assigned[name] && !contains(controller.Config.AllowedClientScopes, name):
+				reason = reasonScopeNotAllowed
+			}
+
+			if reason != "" {
+				fieldErrors = append(fieldErrors, apierror.FieldError{Field: fmt.Sprintf("%s[%d]", field, i), Reason: reason})
+			}
+		}
+	}
+
+	check("defaultClientScopes", client.DefaultClientScopes)
+	check("optionalClientScopes", client.OptionalClientScopes)
+
+	if inverr := validationError(fieldErrors); inverr != nil {
+		logging.GetLogger().Println(inverr)
+		writeError(ctx, w, inverr)
+		return nil, inverr
+	}
+
+	return scopes, nil
+}
+
+// reconcileClientScopes - assigns client scopes requested by client to clientInfo, removals go first
+// so scope can move between default and optional, removed scopes are looked up in current assignment
+// as they may be already deleted or renamed in realm, clientInfo is updated with assigned scopes
+func (controller *Controller) reconcileClientScopes(
+	ctx context.Context,
+	w http.ResponseWriter,
+	token string,
+	clientInfo *ClientOut,
+	client Client,
+	scopes map[string]ClientScope) error {
+	httpClient := controller.Config.HTTPClient
+	kinds := []struct {
+		kind     string
+		current  *[]string
+		expected []string
+	}{
+		{defaultScopeKind, &clientInfo.DefaultClientScopes, client.DefaultClientScopes},
+		{optionalScopeKind, &clientInfo.OptionalClientScopes, client.OptionalClientScopes},
+	}
+
+	for _, k := range kinds {
+		if k.expected == nil {
+			continue
+		}
+
+		var assigned map[string]string
+
+		for _, name := range *k.current {
+			if contains(k.expected, name) {
+				continue
+			}
+
+			if assigned == nil {
+				current, err := httpClient.listAssignedClientScopes(ctx, w, controller, token, clientInfo.ID, k.kind)
+
+				if err != nil {
+					return err
+				}
+
+				assigned = map[string]string{}
+
+				for _, scope := range current {
+					assigned[scope.Name] = scope.ID
+				}
+			}
+
+			scopeID, ok := assigned[name]
+
+			// scope is no longer assigned to client
+			if !ok {
+				continue
+			}
+
+			if err := httpClient.removeClientScope(ctx, w, controller, token, clientInfo.ID, k.kind, scopeID); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, k := range kinds {
+		if k.expected == nil {
+			continue
+		}
+
+		for _, name := range k.expected {
+			if contains(*k.current, name) {
+				continue
+			}
+
+			if err := httpClient.addClientScope(ctx, w, controller, token, clientInfo.ID, k.kind, scopes[name].ID); err != nil {
+				return err
+			}
+		}
+
+		*k.current = k.expected
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+// APIClientScopesMock - returns confidential client with realm default scopes assigned, records scope changes
+type APIClientScopesMock struct {
+	APIClientRecordingMock
+	changes []string
+	// assigned - ids of assigned scopes by name, realm scope ids are used when missing
+	assigned map[string]string
+}
+
+func newAPIClientScopesMock() *APIClientScopesMock {
+	return &APIClientScopesMock{APIClientRecordingMock: APIClientRecordingMock{client: ClientOut{
+		ID:                        "test-uid",
+		DirectAccessGrantsEnabled: true,
+		DefaultClientScopes:       []string{"profile", "email"},
+		OptionalClientScopes:      []string{},
+	}}}
+}
+
+func (s *APIClientScopesMock) listAssignedClientScopes(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	kind string) (scopes []ClientScope, err error) {
+	names := s.client.DefaultClientScopes
+
+	if kind == optionalScopeKind {
+		names = s.client.OptionalClientScopes
+	}
+
+	for _, name := range names {
+		scope := ClientScope{ID: s.assigned[name], Name: name}
+
+		for _, realmScope := range testClientScopes {
+			if scope.ID == "" && realmScope.Name == name {
+				scope.ID = realmScope.ID
+			}
+		}
+
+		scopes = append(scopes, scope)
+	}
+
+	return scopes, nil
+}
+
+func (s *APIClientScopesMock) addClientScope(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	kind string,
+	scopeID string) (err error) {
+	s.changes = append(s.changes, "add "+kind+" "+scopeID)
+	return nil
+}
+
+func (s *APIClientScopesMock) removeClientScope(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	kind string,
+	scopeID string) (err error) {
+	s.changes = append(s.changes, "remove "+kind+" "+scopeID)
+	return nil
+}
+
+func TestCreateClientWithScopes(t *testing.T) {
+	apiClient := newAPIClientScopesMock()
+	payload := `{"clientId": "test", "directAccessGrantsEnabled": true, "defaultClientScopes": ["orders-audience"]}`
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.AllowedClientScopes = []string{"orders-audience"}
+	rr := sendRequest(t, testConfig, "POST", "/client", payload, nil)
+
+	assert.Equal(t, rr.Code, 201, rr.Body.String())
+	assert.Assert(t, apiClient.created.DefaultClientScopes == nil)
+	assert.DeepEqual(t, apiClient.changes, []string{
+		"remove default scope-profile",
+		"remove default scope-email",
+		"add default scope-audience",
+	})
+}
+
+func TestPatchClientScopes(t *testing.T) {
+	apiClient := newAPIClientScopesMock()
+	payload := `{"defaultClientScopes": ["profile"], "optionalClientScopes": ["email", "orders-audience"]}`
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.AllowedClientScopes = []string{"orders-audience"}
+	rr := sendRequest(t, testConfig, "PATCH", "/client/test", payload, nil)
+
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+	client := mustDecodeClient(t, rr.Body.String())
+	assert.DeepEqual(t, client.DefaultClientScopes, []string{"profile"})
+	assert.DeepEqual(t, client.OptionalClientScopes, []string{"email", "orders-audience"})
+	assert.DeepEqual(t, apiClient.changes, []string{
+		"remove default scope-email",
+		"add optional scope-email",
+		"add optional scope-audience",
+	})
+}
+
+func TestPatchClientScopesRemovedFromRealm(t *testing.T) {
+	apiClient := newAPIClientScopesMock()
+	apiClient.client.OptionalClientScopes = []string{"deleted-scope"}
+	apiClient.assigned = map[string]string{"deleted-scope": "scope-deleted"}
+	rr := sendRequest(t, getMockedTestConfig(apiClient), "PATCH", "/client/test", `{"defaultClientScopes": ["profile"], "optionalClientScopes": ["email"]}`, nil)
+
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+	assert.DeepEqual(t, apiClient.changes, []string{
+		"remove default scope-email",
+		"remove optional scope-deleted",
+		"add optional scope-email",
+	})
+}
+
+func TestPatchClientScopesNotAllowedByDefault(t *testing.T) {
+	apiClient := newAPIClientScopesMock()
+	payload := `{"defaultClientScopes": ["profile", "orders-audience"]}`
+	rr := sendRequest(t, getMockedTestConfig(apiClient), "PATCH", "/client/test", payload, nil)
+
+	assert.Equal(t, rr.Code, 400)
+	assert.DeepEqual(t, fieldErrorsOf(t, rr), []apierror.FieldError{
+		{Field: "defaultClientScopes[1]", Reason: reasonScopeNotAllowed},
+	})
+	assert.Equal(t, len(apiClient.changes), 0)
+}
+
+func TestPatchClientScopesRejected(t *testing.T) {
+	apiClient := newAPIClientScopesMock()
+	payload := `{"defaultClientScopes": ["profile", "unknown", "role_list"], "optionalClientScopes": ["orders-audience"]}`
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.AllowedClientScopes = []string{"email"}
+	rr := sendRequest(t, testConfig, "PATCH", "/client/test", payload, nil)
+
+	retErr := &apierror.ApiError{}
+
+	if errAPI := json.Unmarshal(rr.Body.Bytes(), retErr); errAPI != nil {
+		t.Fatalf("Single problem expected %s", rr.Body.String())
+	}
+
+	assert.Equal(t, rr.Code, 400)
+	assert.DeepEqual(t, retErr.Errors, []apierror.FieldError{
+		{Field: "defaultClientScopes[1]", Reason: reasonUnknownScope},
+		{Field: "defaultClientScopes[2]", Reason: reasonScopeProtocol},
+		{Field: "optionalClientScopes[0]", Reason: reasonScopeNotAllowed},
+	})
+	assert.Equal(t, len(apiClient.changes), 0)
+}
+
+func TestClientScopeDefaultAndOptional(t *testing.T) {
+	client := mustDecodeClient(t, `{"clientId": "test", "defaultClientScopes": ["email"], "optionalClientScopes": ["profile", "email"]}`)
+
+	assert.DeepEqual(t, clientScopeErrors(client), []apierror.FieldError{
+		{Field: "optionalClientScopes[1]", Reason: reasonScopeDefaultOptional},
+	})
+}
@@ -2,17 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/p53/idp-api/apierror"
 	"github.com/p53/idp-api/logging"
-	validator "gopkg.in/validator.v2"
 )
 
 // Controller - controller structure
@@ -24,13 +25,68 @@ type Controller struct {
 // ClientOut - structure for output idp client definition - there is bug/feature? in keycloak
 // when you set json with ID to keycloak it will set it as uid of object
 type ClientOut struct {
-	ID                        string `json:"id"`
-	ClientID                  string `json:"clientId" validate:"nonzero"`
-	PublicClient              bool   `json:"publicClient"`
-	DirectAccessGrantsEnabled bool   `json:"directAccessGrantsEnabled"`
-	ServiceAccountsEnabled    bool   `json:"serviceAccountsEnabled"`
-	StandardFlowEnabled       bool   `json:"standardFlowEnabled"`
-	ImplicitFlowEnabled       bool   `json:"implicitFlowEnabled"`
+	ID                        string            `json:"id"`
+	ClientID                  string            `json:"clientId" validate:"nonzero"`
+	PublicClient              bool              `json:"publicClient"`
+	DirectAccessGrantsEnabled bool              `json:"directAccessGrantsEnabled"`
+	ServiceAccountsEnabled    bool              `json:"serviceAccountsEnabled"`
+	StandardFlowEnabled       bool              `json:"standardFlowEnabled"`
+	ImplicitFlowEnabled       bool              `json:"implicitFlowEnabled"`
+	RedirectUris              []string          `json:"redirectUris"`
+	RootUrl                   string            `json:"rootUrl"`
+	AdminUrl                  string            `json:"adminUrl"`
+	WebOrigins                []string          `json:"webOrigins"`
+	Description               string            `json:"description"`
+	ConsentRequired           bool              `json:"consentRequired"`
+	FrontchannelLogout        bool              `json:"frontchannelLogout"`
+	ClientAuthenticatorType   string            `json:"clientAuthenticatorType"`
+	Protocol                  string            `json:"protocol"`
+	Attributes                map[string]string `json:"attributes"`
+	DefaultClientScopes       []string          `json:"defaultClientScopes"`
+	OptionalClientScopes      []string          `json:"optionalClientScopes"`
+	ProtocolMappers           []ProtocolMapper  `json:"protocolMappers"`
+	// Version - hash of whole representation stored in idp, used as ETag
+	Version string `json:"-"`
+}
+
+// client - input definition of client, attributes are managed by api so they are left out
+func (clientOut *ClientOut) client() Client {
+	jwksURL, jwks := clientKeys(clientOut)
+
+	client := Client{
+		ClientID:                  clientOut.ClientID,
+		PublicClient:              clientOut.PublicClient,
+		RedirectUris:              clientOut.RedirectUris,
+		RootUrl:                   clientOut.RootUrl,
+		WebOrigins:                clientOut.WebOrigins,
+		AdminUrl:                  clientOut.AdminUrl,
+		DirectAccessGrantsEnabled: clientOut.DirectAccessGrantsEnabled,
+		ServiceAccountsEnabled:    clientOut.ServiceAccountsEnabled,
+		StandardFlowEnabled:       clientOut.StandardFlowEnabled,
+		ImplicitFlowEnabled:       clientOut.ImplicitFlowEnabled,
+		Description:               clientOut.Description,
+		ConsentRequired:           clientOut.ConsentRequired,
+		FrontchannelLogout:        clientOut.FrontchannelLogout,
+		ClientAuthenticatorType:   clientOut.ClientAuthenticatorType,
+		JwksURL:                   jwksURL,
+		Jwks:                      jwks,
+		Protocol:                  clientOut.Protocol,
+		DefaultClientScopes:       clientOut.DefaultClientScopes,
+		OptionalClientScopes:      clientOut.OptionalClientScopes,
+		ProtocolMappers:           apiMappers(clientOut.ProtocolMappers),
+		TokenSettings:             tokenSettings(clientOut),
+	}
+
+	if clientOut.Protocol == samlProtocol {
+		// acs urls are stored as redirect uris
+		client.Saml = samlSettings(clientOut)
+		client.RedirectUris = nil
+		client.ClientAuthenticatorType = ""
+	} else {
+		readClientFlags(clientOut, &client)
+	}
+
+	return client
 }
 
 // Client - structure for input idp client definition
@@ -46,30 +102,70 @@ type Client struct {
 	StandardFlowEnabled       bool     `json:"standardFlowEnabled"`
 	ImplicitFlowEnabled       bool     `json:"implicitFlowEnabled"`
 	Description               string   `json:"description"`
+	ConsentRequired           bool     `json:"consentRequired"`
+	FrontchannelLogout        bool     `json:"frontchannelLogout"`
+	// BackchannelLogoutURL, PostLogoutRedirectUris, grants and token exchange are stored in attributes
+	// so they are never sent to idp
+	BackchannelLogoutURL            string   `json:"backchannelLogoutUrl,omitempty"`
+	PostLogoutRedirectUris          []string `json:"postLogoutRedirectUris,omitempty"`
+	DeviceAuthorizationGrantEnabled bool     `json:"deviceAuthorizationGrantEnabled,omitempty"`
+	CibaGrantEnabled                bool     `json:"cibaGrantEnabled,omitempty"`
+	TokenExchangeEnabled            bool     `json:"tokenExchangeEnabled,omitempty"`
+	// ClientAuthenticatorType - client-secret (default) or client-jwt (private_key_jwt)
+	ClientAuthenticatorType string `json:"clientAuthenticatorType,omitempty"`
+	// JwksURL, Jwks - keys of client-jwt client, stored in attributes so they are never sent to idp
+	JwksURL string          `json:"jwksUrl,omitempty"`
+	Jwks    json.RawMessage `json:"jwks,omitempty"`
+	// Protocol - openid-connect (default) or saml
+	Protocol string `json:"protocol,omitempty"`
+	// Saml - service provider settings of saml client, stored in attributes so they are never sent to idp
+	Saml *SamlSettings `json:"saml,omitempty"`
+	// DefaultClientScopes, OptionalClientScopes - names of assigned client scopes, omitted lists are left unchanged
+	DefaultClientScopes  []string `json:"defaultClientScopes,omitempty"`
+	OptionalClientScopes []string `json:"optionalClientScopes,omitempty"`
+	// ProtocolMappers - mappers shaping tokens of client, omitted list is left unchanged
+	ProtocolMappers []ProtocolMapper `json:"protocolMappers,omitempty"`
+	// TokenSettings - token lifetimes of client, omitted settings are left unchanged
+	TokenSettings *TokenSettings `json:"tokenSettings,omitempty"`
+	// Attributes - keycloak client attributes, managed by api only
+	Attributes map[string]string `json:"attributes,omitempty"`
 }
 
 // ClientWithSecret - structure for input idp client definition, containing secret
 type ClientWithSecret struct {
-	ClientID                  string `json:"clientId" validate:"nonzero"`
-	PublicClient              bool   `json:"publicClient"`
-	DirectAccessGrantsEnabled bool   `json:"directAccessGrantsEnabled"`
-	ServiceAccountsEnabled    bool   `json:"serviceAccountsEnabled"`
-	StandardFlowEnabled       bool   `json:"standardFlowEnabled"`
-	ImplicitFlowEnabled       bool   `json:"implicitFlowEnabled"`
-	Secret                    string `json:"clientSecret" validate:"nonzero"`
+	Client
+	// Secret - required for confidential clients, public clients are verified by owner
+	Secret string `json:"clientSecret"`
+	// Assertion - signed client assertion, replaces secret of client-jwt clients
+	Assertion string `json:"clientAssertion"`
+}
+
+// ClientView - client definition returned on read, with roles granted to its service account
+type ClientView struct {
+	Client
+	ServiceAccountRoles *RoleGrants `json:"serviceAccountRoles,omitempty"`
+}
+
+// Health - structure for health check output
+type Health struct {
+	Status         string `json:"status"`
+	CircuitBreaker string `json:"circuitBreaker"`
 }
 
 func (controller *Controller) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	logger := logging.GetLogger()
 	httpClient := controller.Config.HTTPClient
 
+	ctx, cancel := operationContext(r.Context(), controller.Config, "healthCheck")
+	defer cancel()
+
 	url := fmt.Sprintf(controller.Config.CheckURI, controller.Config.IdpURL)
 	byteArr := []byte("")
-	req, err := http.NewRequest("GET", url, bytes.NewBuffer(byteArr))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, bytes.NewBuffer(byteArr))
 
 	if err != nil {
 		logger.Println(err)
-		http.Error(w, err.Error(), 500)
+		writeError(ctx, w, apierror.InternalServerError())
 		return
 	}
 
@@ -77,18 +173,67 @@ func (controller *Controller) HealthCheck(w http.ResponseWriter, r *http.Request
 
 	if errReq != nil {
 		logger.Println(errReq)
-		errStr := fmt.Sprintf("%s", errReq)
-		inverr := apierror.ApiError{
-			Code:    "10000",
-			Message: errStr,
-		}
+		writeError(ctx, w, upstreamError(errReq))
+		return
+	}
+
+	health, errMar := json.Marshal(Health{
+		Status:         "ok",
+		CircuitBreaker: httpClient.upstreamStats().BreakerState,
+	})
 
-		http.Error(w, inverr.Error(), 500)
+	if errMar != nil {
+		logger.Println(errMar)
+		inErr := apierror.InternalServerError()
+		writeError(ctx, w, inErr)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	w.Write(health)
+}
+
+// Metrics - exposes counters of idp calls in prometheus text format
+func (controller *Controller) Metrics(w http.ResponseWriter, r *http.Request) {
+	stats := controller.Config.HTTPClient.upstreamStats()
+	states := []string{breakerClosed, breakerHalfOpen, breakerOpen}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintln(w, "# HELP idp_api_circuit_breaker_state State of circuit breaker guarding idp calls")
+	fmt.Fprintln(w, "# TYPE idp_api_circuit_breaker_state gauge")
+
+	for _, state := range states {
+		value := 0
+
+		if state == stats.BreakerState {
+			value = 1
+		}
+
+		fmt.Fprintf(w, "idp_api_circuit_breaker_state{state=\"%s\"} %d\n", state, value)
+	}
+
+	fmt.Fprintln(w, "# HELP idp_api_upstream_retries_total Number of retried idp calls")
+	fmt.Fprintln(w, "# TYPE idp_api_upstream_retries_total counter")
+	fmt.Fprintf(w, "idp_api_upstream_retries_total %d\n", stats.Retries)
+	fmt.Fprintln(w, "# HELP idp_api_upstream_failures_total Number of failed idp calls (transport errors and 5xx)")
+	fmt.Fprintln(w, "# TYPE idp_api_upstream_failures_total counter")
+	fmt.Fprintf(w, "idp_api_upstream_failures_total %d\n", stats.Failures)
+	fmt.Fprintln(w, "# HELP idp_api_circuit_breaker_rejections_total Number of idp calls rejected by open circuit breaker")
+	fmt.Fprintln(w, "# TYPE idp_api_circuit_breaker_rejections_total counter")
+	fmt.Fprintf(w, "idp_api_circuit_breaker_rejections_total %d\n", stats.Rejections)
+
+	if limiter := controller.Config.RateLimiter; limiter != nil {
+		limits := limiter.Stats()
+		fmt.Fprintln(w, "# HELP idp_api_rate_limit_rejections_total Number of requests rejected by rate limit or lockout")
+		fmt.Fprintln(w, "# TYPE idp_api_rate_limit_rejections_total counter")
+		fmt.Fprintf(w, "idp_api_rate_limit_rejections_total %d\n", limits.Rejections)
+		fmt.Fprintln(w, "# HELP idp_api_auth_lockouts_total Number of lockouts after repeated failed authentications")
+		fmt.Fprintln(w, "# TYPE idp_api_auth_lockouts_total counter")
+		fmt.Fprintf(w, "idp_api_auth_lockouts_total %d\n", limits.Lockouts)
+	}
 }
 
 func (controller *Controller) ReadSwagger(w http.ResponseWriter, r *http.Request) {
@@ -107,7 +252,7 @@ func (controller *Controller) ReadSwagger(w http.ResponseWriter, r *http.Request
 	if errRead != nil {
 		logger.Printf("Error while reading swagger file %s", errRead)
 		inErr := apierror.InternalServerError()
-		http.Error(w, inErr.Error(), 500)
+		writeError(r.Context(), w, inErr)
 		return
 	}
 
@@ -124,36 +269,67 @@ func (controller *Controller) CreateResource(w http.ResponseWriter, r *http.Requ
 
 	logger.Println("Authenticating external user")
 
-	_, authEntity, err := httpClient.authenticate(w, r, controller, bodyFunc)
+	callerToken, authEntity, err := httpClient.authenticate(w, r, controller, bodyFunc)
 
 	if err != nil {
 		return
 	}
 
-	var client Client
-	decoder := json.NewDecoder(r.Body)
+	markAuthenticated(r.Context())
+	caller := newCaller(authEntity, callerToken)
 	defer r.Body.Close()
 
-	if errDec := decoder.Decode(&client); errDec != nil {
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" && controller.Config.Idempotency != nil {
+		controller.createIdempotent(w, r, caller, key)
+		return
+	}
+
+	controller.createResource(w, r, caller, &createAttempt{})
+}
+
+// createResource - validates client definition and creates client owned by caller
+func (controller *Controller) createResource(w http.ResponseWriter, r *http.Request, caller Caller, attempt *createAttempt) {
+	logger := logging.GetLogger()
+	httpClient := controller.Config.HTTPClient
+
+	client, errDec := controller.Config.Templates.decode(r.Body)
+
+	if errDec != nil {
 		logger.Println(errDec)
-		inverr := apierror.InvalidRequestPayload()
-		http.Error(w, inverr.Error(), 400)
+		writeError(r.Context(), w, errDec)
 		return
 	}
 
-	if err := validator.Validate(client); err != nil {
-		logger.Println(err)
-		inverr := apierror.MissingRequiredFieldsPayload()
-		http.Error(w, inverr.Error(), 400)
+	if inverr := validationError(validateClient(client)); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
 		return
 	}
 
-	if ok := client.StandardFlowEnabled; ok {
-		if len(client.RedirectUris) > 0 {
-			client.RootUrl = client.RedirectUris[0]
-			client.AdminUrl = client.RedirectUris[0]
-			client.WebOrigins = client.RedirectUris
-		}
+	if inverr := controller.Config.NamingPolicy.check(caller, client); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	deriveClientUrls(&client)
+
+	if inverr := controller.Config.RedirectPolicy.check(caller, client); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	if inverr := controller.Config.TokenPolicy.check(client, nil); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	if inverr := controller.Config.FlagPolicy.check(caller, client, nil); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
 	}
 
 	adminBodyFunc := getAdminAuthBody
@@ -166,36 +342,59 @@ func (controller *Controller) CreateResource(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	client.PublicClient = false
-	client.Description = fmt.Sprintf("Client created by %s", authEntity)
-	err = httpClient.createClient(w, controller, token, client)
+	scopes, err := controller.realmScopes(r.Context(), w, token, client, nil)
 
 	if err != nil {
 		return
 	}
 
-	clientInf, errClient := httpClient.getClient(w, controller, token, client)
-
-	if errClient != nil {
+	if err := controller.checkProtocolMappers(r.Context(), w, client, nil); err != nil {
 		return
 	}
 
-	clientSec, errSec := httpClient.getClientSecret(w, controller, token, clientInf.ID)
+	var reservation *quotaReservation
+
+	if quotas := controller.Config.Quotas; quotas != nil {
+		if reservation, err = quotas.reserve(r.Context(), w, controller, token, caller); err != nil {
+			return
+		}
+	}
+
+	client.Attributes = ownerAttributes(caller)
+	enforcePKCE(&client)
+	configureClientJWT(&client)
+	configureSaml(&client)
+	configureTokenSettings(&client)
+	configureClientFlags(&client)
+	client.Description = fmt.Sprintf("Client created by %s", caller.Name)
+	saga := &createSaga{controller: controller, caller: caller, token: token, client: client, scopes: scopes}
+	err = controller.createAttempted(r.Context(), token, caller, client, attempt)
 
-	if errSec != nil {
+	if err != nil {
+		reservation.release()
+		audit(r.Context(), AuditEvent{Actor: caller.Name, Action: "client.create", ClientID: client.ClientID, Outcome: auditFailed})
+
+		// idp may have stored client before request failed
+		if ambiguousError(err) {
+			err = saga.rollback(r.Context(), err)
+		}
+
+		writeError(r.Context(), w, err)
 		return
 	}
 
-	secOut, marSecErr := json.Marshal(ClientSecret{Value: clientSec})
+	secOut, err := saga.response(r.Context())
 
-	if marSecErr != nil {
-		msg := fmt.Sprintf("Unmarshalling failed %s", marSecErr)
-		log.Println(msg)
-		inErr := apierror.InternalServerError()
-		http.Error(w, inErr.Error(), 500)
+	if err != nil {
+		reservation.release()
+		writeError(r.Context(), w, saga.rollback(r.Context(), err))
 		return
 	}
 
+	reservation.commit()
+
+	audit(r.Context(), AuditEvent{Actor: caller.Name, Action: "client.create", ClientID: client.ClientID, Outcome: auditSucceeded})
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	w.Write(secOut)
@@ -206,57 +405,44 @@ func (controller *Controller) UpdateResource(w http.ResponseWriter, r *http.Requ
 	logger := logging.GetLogger()
 	httpClient := controller.Config.HTTPClient
 	bodyFunc := getAuthBodyFromBasicAuth
-	_, _, err := httpClient.authenticate(w, r, controller, bodyFunc)
+	callerToken, authEntity, err := httpClient.authenticate(w, r, controller, bodyFunc)
 
 	if err != nil {
 		return
 	}
 
-	var clientWithSecret ClientWithSecret
-	var client Client
-	bodyBytes, errBio := ioutil.ReadAll(r.Body)
-
-	if errBio != nil {
-		logger.Println(errBio)
-		http.Error(w, errBio.Error(), 500)
-		return
-	}
-
-	bodyReaderClient := bytes.NewBuffer(bodyBytes)
-	bodyReaderClientSec := bytes.NewBuffer(bodyBytes)
+	markAuthenticated(r.Context())
+	caller := newCaller(authEntity, callerToken)
 
+	var clientWithSecret ClientWithSecret
 	defer r.Body.Close()
-	decoder := json.NewDecoder(bodyReaderClient)
 
-	if errDec := decoder.Decode(&client); errDec != nil {
+	if errDec := decodeStrict(r.Body, &clientWithSecret); errDec != nil {
 		logger.Println(errDec)
-		inverr := apierror.InvalidRequestPayload()
-		http.Error(w, inverr.Error(), 400)
+		writeError(r.Context(), w, errDec)
 		return
 	}
 
-	decoderSec := json.NewDecoder(bodyReaderClientSec)
-
-	if errDecc := decoderSec.Decode(&clientWithSecret); errDecc != nil {
-		logger.Println(errDecc)
-		inverr := apierror.InvalidRequestPayload()
-		http.Error(w, inverr.Error(), 400)
+	if inverr := validationError(validateClientWithSecret(clientWithSecret)); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
 		return
 	}
 
-	if err := validator.Validate(clientWithSecret); err != nil {
-		logger.Println(err)
-		inverr := apierror.MissingRequiredFieldsPayload()
-		http.Error(w, inverr.Error(), 400)
+	if inverr := controller.Config.NamingPolicy.checkReserved(clientWithSecret.ClientID); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
 		return
 	}
 
-	if ok := client.StandardFlowEnabled; ok {
-		if len(client.RedirectUris) > 0 {
-			client.RootUrl = client.RedirectUris[0]
-			client.AdminUrl = client.RedirectUris[0]
-			client.WebOrigins = client.RedirectUris
-		}
+	client := clientWithSecret.Client
+
+	deriveClientUrls(&client)
+
+	if inverr := controller.Config.RedirectPolicy.check(caller, client); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
 	}
 
 	adminBodyFunc := getAdminAuthBody
@@ -266,32 +452,68 @@ func (controller *Controller) UpdateResource(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	client.PublicClient = false
-	clientInfo, err := httpClient.getClient(w, controller, token, client)
+	clientInfo, err := httpClient.getClient(r.Context(), w, controller, token, client)
 
 	if err != nil {
 		return
 	}
 
-	clientSecret, err := httpClient.getClientSecret(w, controller, token, clientInfo.ID)
+	if err := controller.verifyOwnership(r.Context(), w, token, caller, clientInfo, bodyProof(clientWithSecret)); err != nil {
+		return
+	}
+
+	if err := checkIfMatch(r.Context(), w, r, clientInfo); err != nil {
+		return
+	}
+
+	if inverr := clientTypeError(clientInfo, client); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	if inverr := controller.Config.FlagPolicy.check(caller, client, clientInfo); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	if inverr := controller.Config.TokenPolicy.check(client, clientInfo); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	scopes, err := controller.realmScopes(r.Context(), w, token, client, clientInfo)
 
 	if err != nil {
 		return
 	}
 
-	if clientWithSecret.Secret != clientSecret {
-		logger.Println(err)
-		inverr := apierror.BadClientSecret()
-		http.Error(w, inverr.Error(), 401)
+	if err := controller.checkProtocolMappers(r.Context(), w, client, clientInfo); err != nil {
 		return
 	}
 
-	err = httpClient.updateClient(w, controller, token, client, clientInfo.ID)
+	enforcePKCE(&client)
+	configureClientJWT(&client)
+	configureSaml(&client)
+	configureTokenSettings(&client)
+	configureClientFlags(&client)
+	err = httpClient.updateClient(r.Context(), w, controller, token, withoutProtocolMappers(client), clientInfo.ID)
 
 	if err != nil {
 		return
 	}
 
+	if err := controller.reconcileClientScopes(r.Context(), w, token, clientInfo, client, scopes); err != nil {
+		return
+	}
+
+	if err := controller.reconcileProtocolMappers(r.Context(), w, token, clientInfo, client); err != nil {
+		return
+	}
+
+	controller.setETag(r.Context(), w, token, client)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 }
@@ -301,83 +523,483 @@ func (controller *Controller) DeleteResource(w http.ResponseWriter, r *http.Requ
 	logger := logging.GetLogger()
 	httpClient := controller.Config.HTTPClient
 	bodyFunc := getAuthBodyFromBasicAuth
-	_, _, err := httpClient.authenticate(w, r, controller, bodyFunc)
+	callerToken, authEntity, err := httpClient.authenticate(w, r, controller, bodyFunc)
 
 	if err != nil {
 		return
 	}
 
+	markAuthenticated(r.Context())
+	caller := newCaller(authEntity, callerToken)
+
 	var clientWithSecret ClientWithSecret
-	var client Client
-	bodyBytes, errBio := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
 
-	if errBio != nil {
-		logger.Println(errBio)
-		http.Error(w, errBio.Error(), 500)
+	if errDec := decodeStrict(r.Body, &clientWithSecret); errDec != nil {
+		logger.Println(errDec)
+		writeError(r.Context(), w, errDec)
 		return
 	}
 
-	bodyReaderClient := bytes.NewBuffer(bodyBytes)
-	bodyReaderClientSec := bytes.NewBuffer(bodyBytes)
+	if inverr := validationError(requiredFieldErrors(clientWithSecret)); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	if inverr := controller.Config.NamingPolicy.checkReserved(clientWithSecret.ClientID); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	client := clientWithSecret.Client
+
+	adminBodyFunc := getAdminAuthBody
+	token, _, err := httpClient.authenticate(w, r, controller, adminBodyFunc)
+
+	if err != nil {
+		return
+	}
+
+	clientInfo, err := httpClient.getClient(r.Context(), w, controller, token, client)
+
+	if err != nil {
+		return
+	}
+
+	if err := controller.verifyOwnership(r.Context(), w, token, caller, clientInfo, bodyProof(clientWithSecret)); err != nil {
+		return
+	}
+
+	if err := checkIfMatch(r.Context(), w, r, clientInfo); err != nil {
+		return
+	}
+
+	err = httpClient.deleteClient(r.Context(), w, controller, token, clientInfo.ID)
+
+	if err != nil {
+		return
+	}
+
+	if quotas := controller.Config.Quotas; quotas != nil {
+		quotas.recordDelete(clientInfo)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// PatchResource method for partial update of client with json merge patch (RFC 7396),
+// client secret is passed in X-Client-Secret header, assertion of client-jwt client in X-Client-Assertion
+func (controller *Controller) PatchResource(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetLogger()
+	httpClient := controller.Config.HTTPClient
+	callerToken, authEntity, err := httpClient.authenticate(w, r, controller, getAuthBodyFromBasicAuth)
+
+	if err != nil {
+		return
+	}
+
+	markAuthenticated(r.Context())
+	caller := newCaller(authEntity, callerToken)
+	clientID := pathVar(r, "clientId")
+
+	if inverr := controller.Config.NamingPolicy.checkReserved(clientID); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	if mediaType := strings.Split(r.Header.Get("Content-Type"), ";")[0]; mediaType != MergePatchContentType {
+		inverr := apierror.WithDetail(apierror.UnsupportedMediaType(), "Use "+MergePatchContentType)
+		writeError(r.Context(), w, inverr)
+		return
+	}
 
 	defer r.Body.Close()
-	decoder := json.NewDecoder(bodyReaderClient)
+	patch, err := readMergePatch(r.Body)
 
-	if errDec := decoder.Decode(&client); errDec != nil {
-		logger.Println(errDec)
-		inverr := apierror.InvalidRequestPayload()
-		http.Error(w, inverr.Error(), 400)
+	if err != nil {
+		logger.Println(err)
+		writeError(r.Context(), w, err)
 		return
 	}
 
-	decoderSec := json.NewDecoder(bodyReaderClientSec)
+	token, _, err := httpClient.authenticate(w, r, controller, getAdminAuthBody)
 
-	if errDecc := decoderSec.Decode(&clientWithSecret); errDecc != nil {
-		logger.Println(errDecc)
-		inverr := apierror.InvalidRequestPayload()
-		http.Error(w, inverr.Error(), 400)
+	if err != nil {
 		return
 	}
 
-	if err := validator.Validate(client); err != nil {
+	clientInfo, err := httpClient.getClient(r.Context(), w, controller, token, Client{ClientID: clientID})
+
+	if err != nil {
+		return
+	}
+
+	if err := controller.verifyOwnership(r.Context(), w, token, caller, clientInfo, headerProof(r)); err != nil {
+		return
+	}
+
+	if err := checkIfMatch(r.Context(), w, r, clientInfo); err != nil {
+		return
+	}
+
+	client, err := applyMergePatch(clientInfo.client(), patch)
+
+	if err != nil {
 		logger.Println(err)
-		inverr := apierror.MissingRequiredFieldsPayload()
-		http.Error(w, inverr.Error(), 400)
+		writeError(r.Context(), w, err)
 		return
 	}
 
-	adminBodyFunc := getAdminAuthBody
-	token, _, err := httpClient.authenticate(w, r, controller, adminBodyFunc)
+	if inverr := validationError(validateClient(client)); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	if inverr := clientTypeError(clientInfo, client); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	if inverr := controller.Config.FlagPolicy.check(caller, client, clientInfo); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	scopes, err := controller.realmScopes(r.Context(), w, token, client, clientInfo)
 
 	if err != nil {
 		return
 	}
 
-	clientInfo, err := httpClient.getClient(w, controller, token, client)
+	if err := controller.checkProtocolMappers(r.Context(), w, client, clientInfo); err != nil {
+		return
+	}
+
+	deriveClientUrls(&client)
+
+	if inverr := controller.Config.RedirectPolicy.check(caller, client); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	if inverr := controller.Config.TokenPolicy.check(client, clientInfo); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	enforcePKCE(&client)
+	configureClientJWT(&client)
+	configureSaml(&client)
+	configureTokenSettings(&client)
+	configureClientFlags(&client)
+	err = httpClient.updateClient(r.Context(), w, controller, token, withoutProtocolMappers(client), clientInfo.ID)
 
 	if err != nil {
 		return
 	}
 
-	clientSecret, err := httpClient.getClientSecret(w, controller, token, clientInfo.ID)
+	if err := controller.reconcileClientScopes(r.Context(), w, token, clientInfo, client, scopes); err != nil {
+		return
+	}
+
+	if err := controller.reconcileProtocolMappers(r.Context(), w, token, clientInfo, client); err != nil {
+		return
+	}
+
+	clientOut, errMar := json.Marshal(client)
+
+	if errMar != nil {
+		logger.Println(errMar)
+		writeError(r.Context(), w, apierror.InternalServerError())
+		return
+	}
+
+	controller.setETag(r.Context(), w, token, client)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(clientOut)
+}
+
+// GetResource method for reading client definition, client secret is passed in X-Client-Secret header,
+// assertion of client-jwt client in X-Client-Assertion, response carries ETag for conditional updates
+// and roles granted to service account of client
+func (controller *Controller) GetResource(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetLogger()
+	httpClient := controller.Config.HTTPClient
+	callerToken, authEntity, err := httpClient.authenticate(w, r, controller, getAuthBodyFromBasicAuth)
 
 	if err != nil {
 		return
 	}
 
-	if clientWithSecret.Secret != clientSecret {
-		logger.Println(err)
+	markAuthenticated(r.Context())
+	caller := newCaller(authEntity, callerToken)
+	clientID := pathVar(r, "clientId")
+
+	if inverr := controller.Config.NamingPolicy.checkReserved(clientID); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	token, _, err := httpClient.authenticate(w, r, controller, getAdminAuthBody)
+
+	if err != nil {
+		return
+	}
+
+	clientInfo, err := httpClient.getClient(r.Context(), w, controller, token, Client{ClientID: clientID})
+
+	if err != nil {
+		return
+	}
+
+	if err := controller.verifyOwnership(r.Context(), w, token, caller, clientInfo, headerProof(r)); err != nil {
+		return
+	}
+
+	view := ClientView{Client: clientInfo.client()}
+
+	if clientInfo.ServiceAccountsEnabled {
+		userID, err := httpClient.getServiceAccountUser(r.Context(), w, controller, token, clientInfo.ID)
+
+		if err != nil {
+			return
+		}
+
+		if view.ServiceAccountRoles, err = controller.serviceAccountGrants(r.Context(), w, token, userID); err != nil {
+			return
+		}
+	}
+
+	clientOut, errMar := json.Marshal(view)
+
+	if errMar != nil {
+		logger.Println(errMar)
+		writeError(r.Context(), w, apierror.InternalServerError())
+		return
+	}
+
+	w.Header().Set("ETag", clientETag(clientInfo))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(clientOut)
+}
+
+// ownedClient - authenticates caller, reads client named in path and verifies caller owns it,
+// returns admin token, errors are written to caller
+func (controller *Controller) ownedClient(w http.ResponseWriter, r *http.Request) (string, Caller, *ClientOut, error) {
+	logger := logging.GetLogger()
+	httpClient := controller.Config.HTTPClient
+	callerToken, authEntity, err := httpClient.authenticate(w, r, controller, getAuthBodyFromBasicAuth)
+
+	if err != nil {
+		return "", Caller{}, nil, err
+	}
+
+	markAuthenticated(r.Context())
+	caller := newCaller(authEntity, callerToken)
+	clientID := pathVar(r, "clientId")
+
+	if inverr := controller.Config.NamingPolicy.checkReserved(clientID); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return "", caller, nil, inverr
+	}
+
+	token, _, err := httpClient.authenticate(w, r, controller, getAdminAuthBody)
+
+	if err != nil {
+		return "", caller, nil, err
+	}
+
+	clientInfo, err := httpClient.getClient(r.Context(), w, controller, token, Client{ClientID: clientID})
+
+	if err != nil {
+		return "", caller, nil, err
+	}
+
+	if err := controller.verifyOwnership(r.Context(), w, token, caller, clientInfo, headerProof(r)); err != nil {
+		return "", caller, nil, err
+	}
+
+	return token, caller, clientInfo, nil
+}
+
+// ownershipProof - credentials of client presented by caller, fields name their origin in errors
+type ownershipProof struct {
+	secret         string
+	secretField    string
+	assertion      string
+	assertionField string
+}
+
+// bodyProof - credentials passed in payload of PUT and DELETE
+func bodyProof(clientWithSecret ClientWithSecret) ownershipProof {
+	return ownershipProof{
+		secret:         clientWithSecret.Secret,
+		secretField:    "clientSecret",
+		assertion:      clientWithSecret.Assertion,
+		assertionField: "clientAssertion",
+	}
+}
+
+// headerProof - credentials passed in headers of GET and PATCH
+func headerProof(r *http.Request) ownershipProof {
+	return ownershipProof{
+		secret:         r.Header.Get(ClientSecretHeader),
+		secretField:    ClientSecretHeader,
+		assertion:      r.Header.Get(ClientAssertionHeader),
+		assertionField: ClientAssertionHeader,
+	}
+}
+
+// verifyOwnership - checks that caller owns client, confidential client is verified by its
+// secret, client-jwt client by signed client assertion, public and saml client by owner
+// attributes set on creation, error is written to caller
+func (controller *Controller) verifyOwnership(
+	ctx context.Context,
+	w http.ResponseWriter,
+	token string,
+	caller Caller,
+	clientInfo *ClientOut,
+	proof ownershipProof) error {
+	logger := logging.GetLogger()
+	httpClient := controller.Config.HTTPClient
+
+	if clientInfo.PublicClient || clientInfo.Protocol == samlProtocol {
+		if !isOwner(caller, clientInfo) {
+			inverr := apierror.NotClientOwner()
+			logger.Println(inverr)
+			writeError(ctx, w, inverr)
+			return inverr
+		}
+
+		return nil
+	}
+
+	if clientInfo.ClientAuthenticatorType == clientJWTAuthenticator {
+		if proof.assertion == "" {
+			inverr := validationError([]apierror.FieldError{{Field: proof.assertionField, Reason: reasonMissing}})
+			writeError(ctx, w, inverr)
+			return inverr
+		}
+
+		return httpClient.verifyClientAssertion(ctx, w, controller, clientInfo.ClientID, proof.assertion)
+	}
+
+	secret := proof.secret
+
+	if secret == "" {
+		inverr := validationError([]apierror.FieldError{{Field: proof.secretField, Reason: reasonMissing}})
+		writeError(ctx, w, inverr)
+		return inverr
+	}
+
+	clientSecret, err := httpClient.getClientSecret(ctx, w, controller, token, clientInfo.ID)
+
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(clientSecret)) != 1 {
 		inverr := apierror.BadClientSecret()
-		http.Error(w, inverr.Error(), 401)
+		logger.Println(inverr)
+		writeError(ctx, w, inverr)
+		return inverr
+	}
+
+	return nil
+}
+
+// deriveClientUrls - root url, admin url and web origins of standard flow client follow redirect uris
+func deriveClientUrls(client *Client) {
+	if ok := client.StandardFlowEnabled; ok {
+		if len(client.RedirectUris) > 0 {
+			client.RootUrl = client.RedirectUris[0]
+			client.AdminUrl = client.RedirectUris[0]
+			client.WebOrigins = client.RedirectUris
+		}
+	}
+}
+
+// ListErrors - returns catalog of all api errors with their http status
+func (controller *Controller) ListErrors(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetLogger()
+	catalog, err := json.Marshal(apierror.Catalog())
+
+	if err != nil {
+		logger.Println(err)
+		writeError(r.Context(), w, apierror.InternalServerError())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(catalog)
+}
+
+// SamlMetadata - returns saml idp metadata of managed realm for configuration of service providers
+func (controller *Controller) SamlMetadata(w http.ResponseWriter, r *http.Request) {
+	descriptor, err := controller.Config.HTTPClient.getSamlDescriptor(r.Context(), w, controller)
+
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", SamlMetadataContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(descriptor)
+}
+
+// GetQuota - returns quota usage of caller and its groups
+func (controller *Controller) GetQuota(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetLogger()
+	httpClient := controller.Config.HTTPClient
+	callerToken, authEntity, err := httpClient.authenticate(w, r, controller, getAuthBodyFromBasicAuth)
+
+	if err != nil {
 		return
 	}
 
-	err = httpClient.deleteClient(w, controller, token, clientInfo.ID)
+	markAuthenticated(r.Context())
+	caller := newCaller(authEntity, callerToken)
+	token, _, err := httpClient.authenticate(w, r, controller, getAdminAuthBody)
 
 	if err != nil {
 		return
 	}
 
+	quotas := controller.Config.Quotas
+
+	if quotas == nil {
+		quotas = NewQuotas(0, 0, nil, nil)
+	}
+
+	usage, err := quotas.usage(r.Context(), w, controller, token, caller, true)
+
+	if err != nil {
+		return
+	}
+
+	usageOut, errMar := json.Marshal(usage)
+
+	if errMar != nil {
+		logger.Println(errMar)
+		writeError(r.Context(), w, apierror.InternalServerError())
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusOK)
+	w.Write(usageOut)
 }
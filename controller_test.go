@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/mux"
@@ -14,22 +16,32 @@ import (
 
 func getUnitTestConfig() *Config {
 	config := &Config{
-		IdpURL:          "https://fake.com",
-		ClientID:        "fake",
-		ClientSecret:    "fake",
-		ApiClientID:     "test",
-		ApiClientSecret: "test",
-		IdpAdmin:        "fake",
-		IdpPass:         "fake",
-		IdpRealm:        "master",
-		CheckURI:        "%s/auth/admin",
-		ClientsURI:      "%s/auth/admin/realms/%s/clients",
-		ClientURI:       "%s/auth/admin/realms/%s/clients/%s",
-		ClientSecretURI: "%s/auth/admin/realms/%s/clients/%s/client-secret",
-		TokenURI:        "%s/auth/realms/%s/protocol/openid-connect/token",
-		UsersURI:        "%s/auth/admin/realms/%s/users",
-		UserURI:         "%s/auth/admin/realms/%s/users/%s",
-		UserPasswordURI: "%s/auth/admin/realms/%s/users/%s/reset-password",
+		IdpURL:                 "https://fake.com",
+		ClientID:               "fake",
+		ClientSecret:           "fake",
+		ApiClientID:            "test",
+		ApiClientSecret:        "test",
+		IdpAdmin:               "fake",
+		IdpPass:                "fake",
+		IdpRealm:               "master",
+		CheckURI:               "%s/auth/admin",
+		ClientsURI:             "%s/auth/admin/realms/%s/clients",
+		ClientURI:              "%s/auth/admin/realms/%s/clients/%s",
+		ClientSecretURI:        "%s/auth/admin/realms/%s/clients/%s/client-secret",
+		TokenURI:               "%s/auth/realms/%s/protocol/openid-connect/token",
+		IntrospectURI:          "%s/auth/realms/%s/protocol/openid-connect/token/introspect",
+		SamlDescriptorURI:      "%s/auth/realms/%s/protocol/saml/descriptor",
+		ClientRolesURI:         "%s/auth/admin/realms/%s/clients/%s/roles",
+		ClientRoleURI:          "%s/auth/admin/realms/%s/clients/%s/roles/%s",
+		ServiceAccountUserURI:  "%s/auth/admin/realms/%s/clients/%s/service-account-user",
+		RealmRoleURI:           "%s/auth/admin/realms/%s/roles/%s",
+		RoleMappingsURI:        "%s/auth/admin/realms/%s/users/%s/role-mappings",
+		ClientScopesURI:        "%s/auth/admin/realms/%s/client-scopes",
+		ClientScopeMappingsURI: "%s/auth/admin/realms/%s/clients/%s/%s-client-scopes",
+		ProtocolMappersURI:     "%s/auth/admin/realms/%s/clients/%s/protocol-mappers/models",
+		UsersURI:               "%s/auth/admin/realms/%s/users",
+		UserURI:                "%s/auth/admin/realms/%s/users/%s",
+		UserPasswordURI:        "%s/auth/admin/realms/%s/users/%s/reset-password",
 	}
 
 	return config
@@ -37,27 +49,132 @@ func getUnitTestConfig() *Config {
 
 func getFuncTestConfig() *Config {
 	config := &Config{
-		IdpURL:          "http://keycloak-server:8080",
-		ClientID:        "test",
-		ClientSecret:    "fake",
-		ApiClientID:     "admin-cli",
-		ApiClientSecret: "test",
-		IdpAdmin:        "admin",
-		IdpPass:         "admin",
-		IdpRealm:        "master",
-		CheckURI:        "%s/auth/admin",
-		ClientsURI:      "%s/auth/admin/realms/%s/clients",
-		ClientURI:       "%s/auth/admin/realms/%s/clients/%s",
-		ClientSecretURI: "%s/auth/admin/realms/%s/clients/%s/client-secret",
-		TokenURI:        "%s/auth/realms/%s/protocol/openid-connect/token",
-		UsersURI:        "%s/auth/admin/realms/%s/users",
-		UserURI:         "%s/auth/admin/realms/%s/users/%s",
-		UserPasswordURI: "%s/auth/admin/realms/%s/users/%s/reset-password",
+		IdpURL:                 "http://keycloak-server:8080",
+		ClientID:               "test",
+		ClientSecret:           "fake",
+		ApiClientID:            "admin-cli",
+		ApiClientSecret:        "test",
+		IdpAdmin:               "admin",
+		IdpPass:                "admin",
+		IdpRealm:               "master",
+		CheckURI:               "%s/auth/admin",
+		ClientsURI:             "%s/auth/admin/realms/%s/clients",
+		ClientURI:              "%s/auth/admin/realms/%s/clients/%s",
+		ClientSecretURI:        "%s/auth/admin/realms/%s/clients/%s/client-secret",
+		TokenURI:               "%s/auth/realms/%s/protocol/openid-connect/token",
+		IntrospectURI:          "%s/auth/realms/%s/protocol/openid-connect/token/introspect",
+		SamlDescriptorURI:      "%s/auth/realms/%s/protocol/saml/descriptor",
+		ClientRolesURI:         "%s/auth/admin/realms/%s/clients/%s/roles",
+		ClientRoleURI:          "%s/auth/admin/realms/%s/clients/%s/roles/%s",
+		ServiceAccountUserURI:  "%s/auth/admin/realms/%s/clients/%s/service-account-user",
+		RealmRoleURI:           "%s/auth/admin/realms/%s/roles/%s",
+		RoleMappingsURI:        "%s/auth/admin/realms/%s/users/%s/role-mappings",
+		ClientScopesURI:        "%s/auth/admin/realms/%s/client-scopes",
+		ClientScopeMappingsURI: "%s/auth/admin/realms/%s/clients/%s/%s-client-scopes",
+		ProtocolMappersURI:     "%s/auth/admin/realms/%s/clients/%s/protocol-mappers/models",
+		UsersURI:               "%s/auth/admin/realms/%s/users",
+		UserURI:                "%s/auth/admin/realms/%s/users/%s",
+		UserPasswordURI:        "%s/auth/admin/realms/%s/users/%s/reset-password",
 	}
 
 	return config
 }
 
+// APIClientRecordingMock - authenticates caller, returns configured client from getClient
+// and records created and updated clients
+type APIClientRecordingMock struct {
+	APIClientMock
+	// caller - auth entity of authenticated caller
+	caller string
+	// client - client returned by getClient under requested clientId
+	client  ClientOut
+	created *Client
+	updated *Client
+}
+
+func (s *APIClientRecordingMock) authenticate(
+	w http.ResponseWriter,
+	r *http.Request,
+	controller *Controller,
+	f AuthBodyGetter) (tokenVal string, authEntity string, err error) {
+	return "", s.caller, nil
+}
+
+func (s *APIClientRecordingMock) createClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client) (err error) {
+	s.created = &client
+	return nil
+}
+
+func (s *APIClientRecordingMock) getClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client) (clientOut *ClientOut, err error) {
+	clientOut = &ClientOut{}
+	*clientOut = s.client
+	clientOut.ClientID = client.ClientID
+	return clientOut, nil
+}
+
+func (s *APIClientRecordingMock) updateClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client,
+	clientUID string) (err error) {
+	s.updated = &client
+	return nil
+}
+
+// testExistingClient - existing standard flow client
+var testExistingClient = ClientOut{
+	ID:                  "test-uid",
+	StandardFlowEnabled: true,
+	RedirectUris:        []string{"https://old.example.com/cb"},
+	RootUrl:             "https://old.example.com/cb",
+	Description:         "Client created by test",
+}
+
+// getMockedTestConfig - unit test config using apiClient
+func getMockedTestConfig(apiClient APIClientIntf) *Config {
+	config := getUnitTestConfig()
+	config.HTTPClient = apiClient
+	return config
+}
+
+// sendRequest - sends request to api routes of controller, client secret header proves ownership
+// and PATCH is sent as merge patch, header overrides these defaults
+func sendRequest(t *testing.T, testConfig *Config, method string, path string, payload string, header http.Header) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, bytes.NewBuffer([]byte(payload)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set(ClientSecretHeader, "testsecret")
+
+	if method == "PATCH" {
+		req.Header.Set("Content-Type", MergePatchContentType)
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	r := mux.NewRouter()
+	rr := httptest.NewRecorder()
+	apiRoutes(r, &Controller{Config: testConfig})
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
 func TestSwagger(t *testing.T) {
 	apiClient := &APIClientMock{}
 	testConfig := getUnitTestConfig()
@@ -176,7 +293,7 @@ func TestMissingRequiredFieldsPayloadCreateUser(t *testing.T) {
 	ctrl := &Controller{Config: testConfig}
 	testConfig.HTTPClient = apiClient
 
-	payload := []byte(testBadPayload)
+	payload := []byte(testMissingClientIDPayload)
 
 	req, err := http.NewRequest("POST", "/client", bytes.NewBuffer(payload))
 
@@ -204,6 +321,98 @@ func TestMissingRequiredFieldsPayloadCreateUser(t *testing.T) {
 	if retErr.Code != "1007" {
 		t.Fatal(fmt.Sprintf("Wrong apierror code %s", retErr.Code))
 	}
+
+	if len(retErr.Errors) != 1 || retErr.Errors[0].Field != "clientId" {
+		t.Fatal(fmt.Sprintf("Wrong field errors %v", retErr.Errors))
+	}
+}
+
+func TestUnknownFieldPayloadCreateUser(t *testing.T) {
+	apiClient := &APIClientMock{}
+	testConfig := getUnitTestConfig()
+	ctrl := &Controller{Config: testConfig}
+	testConfig.HTTPClient = apiClient
+
+	payload := []byte(testBadPayload)
+
+	req, err := http.NewRequest("POST", "/client", bytes.NewBuffer(payload))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	rr := httptest.NewRecorder()
+	r.HandleFunc("/client", ctrl.CreateResource).Methods("POST")
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != 400 {
+		content := rr.Body.String()
+		t.Fatal(fmt.Sprintf("Wrong response code %d %s", rr.Code, content))
+	}
+
+	retErr := &apierror.ApiError{}
+	errAPI := json.Unmarshal([]byte(rr.Body.String()), retErr)
+
+	if errAPI != nil {
+		t.Fatal("Problem unmarshalling error")
+	}
+
+	if retErr.Code != "1021" {
+		t.Fatal(fmt.Sprintf("Wrong apierror code %s", retErr.Code))
+	}
+
+	if len(retErr.Errors) != 1 || retErr.Errors[0].Field != "clientIdDDDDDDD" {
+		t.Fatal(fmt.Sprintf("Wrong field errors %v", retErr.Errors))
+	}
+}
+
+func TestValidationFailedCreateUser(t *testing.T) {
+	apiClient := &APIClientMock{}
+	testConfig := getUnitTestConfig()
+	ctrl := &Controller{Config: testConfig}
+	testConfig.HTTPClient = apiClient
+
+	payload := []byte(testInvalidClientPayload)
+
+	req, err := http.NewRequest("POST", "/client", bytes.NewBuffer(payload))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	rr := httptest.NewRecorder()
+	r.HandleFunc("/client", ctrl.CreateResource).Methods("POST")
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != 400 {
+		content := rr.Body.String()
+		t.Fatal(fmt.Sprintf("Wrong response code %d %s", rr.Code, content))
+	}
+
+	retErr := &apierror.ApiError{}
+	errAPI := json.Unmarshal([]byte(rr.Body.String()), retErr)
+
+	if errAPI != nil {
+		t.Fatal("Problem unmarshalling error")
+	}
+
+	if retErr.Code != "1021" {
+		t.Fatal(fmt.Sprintf("Wrong apierror code %s", retErr.Code))
+	}
+
+	fields := []string{}
+
+	for _, fieldErr := range retErr.Errors {
+		fields = append(fields, fieldErr.Field)
+	}
+
+	expected := "redirectUris[1] redirectUris[2] implicitFlowEnabled"
+
+	if strings.Join(fields, " ") != expected {
+		t.Fatal(fmt.Sprintf("Wrong field errors %v", retErr.Errors))
+	}
 }
 
 func TestInvalidRequestPayloadCreateUser(t *testing.T) {
@@ -388,3 +597,324 @@ func TestIdpErrorUpdateUser(t *testing.T) {
 		t.Fatal(fmt.Sprintf("Wrong response code %d %s", rr.Code, content))
 	}
 }
+
+func TestBreakerStateHealth(t *testing.T) {
+	apiClient := &APIClientMock{}
+	testConfig := getUnitTestConfig()
+	ctrl := &Controller{Config: testConfig}
+	testConfig.HTTPClient = apiClient
+
+	req, err := http.NewRequest("GET", "/health", bytes.NewBuffer([]byte("")))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	rr := httptest.NewRecorder()
+	r.HandleFunc("/health", ctrl.HealthCheck).Methods("GET")
+	r.ServeHTTP(rr, req)
+
+	health := &Health{}
+
+	if errUn := json.Unmarshal(rr.Body.Bytes(), health); errUn != nil {
+		t.Fatalf("Problem unmarshalling %s", errUn)
+	}
+
+	if health.CircuitBreaker != breakerClosed {
+		t.Fatalf("Bad circuit breaker state %s", health.CircuitBreaker)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	apiClient := &APIClientInternalServerErrorMock{}
+	testConfig := getUnitTestConfig()
+	ctrl := &Controller{Config: testConfig}
+	testConfig.HTTPClient = apiClient
+
+	req, err := http.NewRequest("GET", "/metrics", bytes.NewBuffer([]byte("")))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	rr := httptest.NewRecorder()
+	r.HandleFunc("/metrics", ctrl.Metrics).Methods("GET")
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != 200 {
+		content := rr.Body.String()
+		t.Fatal(fmt.Sprintf("Wrong response code %d %s", rr.Code, content))
+	}
+
+	for _, line := range []string{
+		`idp_api_circuit_breaker_state{state="open"} 1`,
+		`idp_api_circuit_breaker_state{state="closed"} 0`,
+		`idp_api_upstream_failures_total 1`,
+	} {
+		if !strings.Contains(rr.Body.String(), line) {
+			t.Fatalf("Metrics missing %s in %s", line, rr.Body.String())
+		}
+	}
+}
+
+func TestListErrors(t *testing.T) {
+	testConfig := getUnitTestConfig()
+	ctrl := &Controller{Config: testConfig}
+
+	req, err := http.NewRequest("GET", "/errors", bytes.NewBuffer([]byte("")))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	rr := httptest.NewRecorder()
+	r.HandleFunc("/errors", ctrl.ListErrors).Methods("GET")
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != 200 {
+		content := rr.Body.String()
+		t.Fatal(fmt.Sprintf("Wrong response code %d %s", rr.Code, content))
+	}
+
+	catalog := []apierror.Definition{}
+
+	if errJSON := json.Unmarshal(rr.Body.Bytes(), &catalog); errJSON != nil {
+		t.Fatal("Problem unmarshalling catalog")
+	}
+
+	found := false
+
+	for _, def := range catalog {
+		if def.Code == "1019" && def.Status == 404 {
+			found = true
+		}
+
+		if def.Code == apierror.CodeOf(apierror.TestError()) {
+			t.Fatalf("Test error published in catalog %s", rr.Body.String())
+		}
+	}
+
+	if !found {
+		t.Fatalf("Client not found error missing in catalog %s", rr.Body.String())
+	}
+}
+
+func TestProblemResponseRequestID(t *testing.T) {
+	apiClient := &APIClientMock{}
+	testConfig := getUnitTestConfig()
+	ctrl := &Controller{Config: testConfig}
+	testConfig.HTTPClient = apiClient
+
+	req, err := http.NewRequest("POST", "/client", bytes.NewBuffer([]byte(testBadPayload)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set(RequestIDHeader, "test-request-1")
+
+	r := mux.NewRouter()
+	r.Use(requestIDMiddleware)
+	rr := httptest.NewRecorder()
+	r.HandleFunc("/client", ctrl.CreateResource).Methods("POST")
+	r.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Content-Type") != apierror.ProblemContentType {
+		t.Fatalf("Wrong content type %s", rr.Header().Get("Content-Type"))
+	}
+
+	if rr.Header().Get(RequestIDHeader) != "test-request-1" {
+		t.Fatalf("Wrong request id header %s", rr.Header().Get(RequestIDHeader))
+	}
+
+	retErr := &apierror.ApiError{}
+
+	if errAPI := json.Unmarshal(rr.Body.Bytes(), retErr); errAPI != nil {
+		t.Fatal("Problem unmarshalling error")
+	}
+
+	if retErr.Instance != "test-request-1" || retErr.Status != rr.Code || retErr.Type == "" || retErr.Title == "" {
+		t.Fatalf("Incomplete problem details %s", rr.Body.String())
+	}
+}
+
+func TestNotFoundProblem(t *testing.T) {
+	req, err := http.NewRequest("GET", "/unknown", bytes.NewBuffer([]byte("")))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.NotFoundHandler = requestIDMiddleware(http.HandlerFunc(notFoundHandler))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != 404 {
+		t.Fatalf("Wrong response code %d", rr.Code)
+	}
+
+	if rr.Header().Get("Content-Type") != apierror.ProblemContentType {
+		t.Fatalf("Wrong content type %s", rr.Header().Get("Content-Type"))
+	}
+
+	if rr.Header().Get(RequestIDHeader) == "" {
+		t.Fatal("Missing generated request id")
+	}
+}
+
+func TestRedirectPolicyCreateUser(t *testing.T) {
+	apiClient := &APIClientMock{}
+	testConfig := getUnitTestConfig()
+	testConfig.RedirectPolicy = RedirectPolicy{
+		AllowedSchemes: []string{"https"},
+		DomainSuffixes: map[string][]string{"team-a": {"example.com"}},
+	}
+	ctrl := &Controller{Config: testConfig}
+	testConfig.HTTPClient = apiClient
+
+	payload := []byte(`{"clientId": "test", "standardFlowEnabled": true, "redirectUris": ["https://example.com/cb"]}`)
+
+	req, err := http.NewRequest("POST", "/client", bytes.NewBuffer(payload))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	rr := httptest.NewRecorder()
+	r.HandleFunc("/client", ctrl.CreateResource).Methods("POST")
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != 400 {
+		content := rr.Body.String()
+		t.Fatal(fmt.Sprintf("Wrong response code %d %s", rr.Code, content))
+	}
+
+	retErr := &apierror.ApiError{}
+	errAPI := json.Unmarshal([]byte(rr.Body.String()), retErr)
+
+	if errAPI != nil {
+		t.Fatal("Problem unmarshalling error")
+	}
+
+	if retErr.Code != "1022" {
+		t.Fatal(fmt.Sprintf("Wrong apierror code %s", retErr.Code))
+	}
+
+	// derived root, admin url and web origin are checked as well
+	if len(retErr.Errors) != 4 {
+		t.Fatal(fmt.Sprintf("Wrong field errors %v", retErr.Errors))
+	}
+}
+
+func TestReservedNameUpdateUser(t *testing.T) {
+	apiClient := &APIClientMock{}
+	testConfig := getUnitTestConfig()
+	testConfig.NamingPolicy = NamingPolicy{Reserved: reservedClients(testConfig, nil)}
+	ctrl := &Controller{Config: testConfig}
+	testConfig.HTTPClient = apiClient
+
+	payload := []byte(`{"clientId": "realm-management", "clientSecret": "testsecret"}`)
+
+	req, err := http.NewRequest("PUT", "/client", bytes.NewBuffer(payload))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	rr := httptest.NewRecorder()
+	r.HandleFunc("/client", ctrl.UpdateResource).Methods("PUT")
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != 403 {
+		content := rr.Body.String()
+		t.Fatal(fmt.Sprintf("Wrong response code %d %s", rr.Code, content))
+	}
+
+	retErr := &apierror.ApiError{}
+	errAPI := json.Unmarshal([]byte(rr.Body.String()), retErr)
+
+	if errAPI != nil {
+		t.Fatal("Problem unmarshalling error")
+	}
+
+	if retErr.Code != "1024" {
+		t.Fatal(fmt.Sprintf("Wrong apierror code %s", retErr.Code))
+	}
+}
+
+func TestQuotaExceededCreateUser(t *testing.T) {
+	apiClient := &APIClientMock{}
+	testConfig := getUnitTestConfig()
+	testConfig.Quotas = NewQuotas(0, 1, nil, nil)
+	ctrl := &Controller{Config: testConfig}
+	testConfig.HTTPClient = apiClient
+	reservation, _ := testConfig.Quotas.reserve(context.Background(), httptest.NewRecorder(), ctrl, "token", Caller{})
+	reservation.commit()
+
+	req, err := http.NewRequest("POST", "/client", bytes.NewBuffer([]byte(testPayload)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	rr := httptest.NewRecorder()
+	r.HandleFunc("/client", ctrl.CreateResource).Methods("POST")
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != 403 {
+		content := rr.Body.String()
+		t.Fatal(fmt.Sprintf("Wrong response code %d %s", rr.Code, content))
+	}
+
+	retErr := &apierror.ApiError{}
+	errAPI := json.Unmarshal([]byte(rr.Body.String()), retErr)
+
+	if errAPI != nil {
+		t.Fatal("Problem unmarshalling error")
+	}
+
+	if retErr.Code != "1025" {
+		t.Fatal(fmt.Sprintf("Wrong apierror code %s", retErr.Code))
+	}
+}
+
+func TestGetQuota(t *testing.T) {
+	apiClient := &APIClientMock{}
+	testConfig := getUnitTestConfig()
+	testConfig.Quotas = NewQuotas(10, 0, nil, nil)
+	ctrl := &Controller{Config: testConfig}
+	testConfig.HTTPClient = apiClient
+
+	req, err := http.NewRequest("GET", "/quota", bytes.NewBuffer([]byte("")))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	rr := httptest.NewRecorder()
+	r.HandleFunc("/quota", ctrl.GetQuota).Methods("GET")
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != 200 {
+		content := rr.Body.String()
+		t.Fatal(fmt.Sprintf("Wrong response code %d %s", rr.Code, content))
+	}
+
+	usage := &Usage{}
+
+	if errJSON := json.Unmarshal(rr.Body.Bytes(), usage); errJSON != nil {
+		t.Fatal("Problem unmarshalling usage")
+	}
+
+	if usage.Entity.MaxClients != 10 {
+		t.Fatal(fmt.Sprintf("Wrong usage %s", rr.Body.String()))
+	}
+}
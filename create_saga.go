@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/p53/idp-api/apierror"
+	"github.com/p53/idp-api/logging"
+)
+
+const (
+	compensationDeleted    = "client deleted"
+	compensationNotCreated = "client not found in idp, nothing to delete"
+	compensationFailed     = "client deletion failed, client requires cleanup"
+	compensationNotOwned   = "client found in idp was not created by caller, nothing to delete"
+)
+
+// createSaga - steps following client creation, when any of them fails
+// created client is deleted so caller can safely retry
+type createSaga struct {
+	controller *Controller
+	caller     Caller
+	token      string
+	client     Client
+	clientUID  string
+	// scopes - realm client scopes requested by client, by name
+	scopes map[string]ClientScope
+}
+
+// response - reads created client, assigns requested client scopes and protocol mappers and reads its secret, returns marshalled ClientSecret,
+// public, client-jwt and saml clients don't use secret so their configuration is returned instead,
+// errors are returned to saga instead of being written to caller
+func (saga *createSaga) response(ctx context.Context) ([]byte, error) {
+	httpClient := saga.controller.Config.HTTPClient
+	dw := newDiscardWriter()
+
+	clientInf, err := httpClient.getClient(ctx, dw, saga.controller, saga.token, saga.client)
+
+	if err != nil {
+		return nil, sagaError(err)
+	}
+
+	saga.clientUID = clientInf.ID
+
+	if err := saga.controller.reconcileClientScopes(ctx, dw, saga.token, clientInf, saga.client, saga.scopes); err != nil {
+		return nil, sagaError(err)
+	}
+
+	if err := saga.controller.reconcileProtocolMappers(ctx, dw, saga.token, clientInf, saga.client); err != nil {
+		return nil, sagaError(err)
+	}
+
+	if clientInf.PublicClient || clientInf.ClientAuthenticatorType == clientJWTAuthenticator || clientInf.Protocol == samlProtocol {
+		return saga.marshal(clientInf.client())
+	}
+
+	clientSec, err := httpClient.getClientSecret(ctx, dw, saga.controller, saga.token, clientInf.ID)
+
+	if err != nil {
+		return nil, sagaError(err)
+	}
+
+	return saga.marshal(ClientSecret{Value: clientSec})
+}
+
+// marshal - marshals response of created client
+func (saga *createSaga) marshal(v interface{}) ([]byte, error) {
+	out, err := json.Marshal(v)
+
+	if err != nil {
+		logging.GetLogger().Printf("Marshalling failed %s", err)
+		return nil, apierror.InternalServerError()
+	}
+
+	return out, nil
+}
+
+// rollback - deletes half created client and returns err with outcome of compensation,
+// compensation is not bound to caller request so it finishes even when caller disconnected
+func (saga *createSaga) rollback(ctx context.Context, err error) error {
+	logger := logging.GetLogger()
+	httpClient := saga.controller.Config.HTTPClient
+	ctx = detachedContext(ctx)
+	dw := newDiscardWriter()
+	outcome := compensationDeleted
+
+	if saga.clientUID == "" {
+		clientInf, errGet := httpClient.getClient(ctx, dw, saga.controller, saga.token, saga.client)
+
+		if errGet == nil && clientInf.Attributes[ownerAttribute] != saga.caller.Name {
+			// conflict response may have been lost, existing client of someone else is kept
+			outcome = compensationNotOwned
+		} else if errGet == nil {
+			saga.clientUID = clientInf.ID
+		} else if apierror.CodeOf(errGet) == apierror.CodeOf(apierror.ClientNotFound()) {
+			outcome = compensationNotCreated
+		} else {
+			outcome = compensationFailed
+		}
+	}
+
+	if saga.clientUID != "" {
+		if errDel := httpClient.deleteClient(ctx, dw, saga.controller, saga.token, saga.clientUID); errDel != nil {
+			outcome = compensationFailed
+		}
+	}
+
+	logger.Printf("Create of client %s failed %s, compensation: %s", saga.client.ClientID, err, outcome)
+	audit(ctx, AuditEvent{
+		Actor:    saga.caller.Name,
+		Action:   "client.create.rollback",
+		ClientID: saga.client.ClientID,
+		Outcome:  outcome,
+		Detail:   fmt.Sprintf("idp id %s, cause %s", saga.clientUID, err),
+	})
+
+	return apierror.WithCompensation(err, outcome)
+}
+
+// ambiguousError - error after which client may exist in idp, request timed out, was cancelled
+// or idp failed, requests rejected by idp or by open circuit breaker created nothing
+func ambiguousError(err error) bool {
+	if apierror.CodeOf(err) == apierror.CodeOf(apierror.UpstreamUnavailable()) {
+		return false
+	}
+
+	status := apierror.StatusOf(err)
+	return status >= 500 || status == apierror.StatusOf(apierror.RequestCancelled())
+}
+
+// sagaError - errors not from catalog are hidden behind InternalServerError
+func sagaError(err error) error {
+	if _, ok := err.(*apierror.ApiError); ok {
+		return err
+	}
+
+	logging.GetLogger().Println(err)
+	return apierror.InternalServerError()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+// APIClientSecretFailureMock - creates client but fails to read its secret
+type APIClientSecretFailureMock struct {
+	APIClientMock
+	deleted    []string
+	deleteFail bool
+}
+
+func (s *APIClientSecretFailureMock) getClientSecret(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (clientSecret string, err error) {
+	inverr := apierror.UpstreamTimeout()
+	writeError(ctx, w, inverr)
+	return "", inverr
+}
+
+func (s *APIClientSecretFailureMock) deleteClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (err error) {
+	if s.deleteFail {
+		inverr := apierror.UpstreamUnavailable()
+		writeError(ctx, w, inverr)
+		return inverr
+	}
+
+	s.deleted = append(s.deleted, clientUID)
+	return nil
+}
+
+// APIClientCreateTimeoutMock - create times out after idp stored client
+type APIClientCreateTimeoutMock struct {
+	APIClientSecretFailureMock
+	owner string
+}
+
+func (s *APIClientCreateTimeoutMock) getClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client) (clientOut *ClientOut, err error) {
+	return &ClientOut{ID: "test", ClientID: client.ClientID, Attributes: map[string]string{ownerAttribute: s.owner}}, nil
+}
+
+func (s *APIClientCreateTimeoutMock) createClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client) (err error) {
+	inverr := apierror.UpstreamTimeout()
+	writeError(ctx, w, inverr)
+	return inverr
+}
+
+func createFailing(t *testing.T, apiClient APIClientIntf, testConfig *Config) *apierror.ApiError {
+	testConfig.HTTPClient = apiClient
+	rr := sendRequest(t, testConfig, "POST", "/client", testPayload, nil)
+
+	assert.Equal(t, rr.Code, 504)
+
+	retErr := &apierror.ApiError{}
+
+	if errAPI := json.Unmarshal(rr.Body.Bytes(), retErr); errAPI != nil {
+		t.Fatalf("Single problem expected %s", rr.Body.String())
+	}
+
+	return retErr
+}
+
+func TestRollbackCreateClient(t *testing.T) {
+	apiClient := &APIClientSecretFailureMock{}
+	retErr := createFailing(t, apiClient, getUnitTestConfig())
+
+	assert.Equal(t, retErr.Code, "1011")
+	assert.Equal(t, retErr.Compensation, compensationDeleted)
+	assert.DeepEqual(t, apiClient.deleted, []string{"test"})
+}
+
+func TestFailedRollbackCreateClient(t *testing.T) {
+	apiClient := &APIClientSecretFailureMock{deleteFail: true}
+	retErr := createFailing(t, apiClient, getUnitTestConfig())
+
+	assert.Equal(t, retErr.Code, "1011")
+	assert.Equal(t, retErr.Compensation, compensationFailed)
+}
+
+func TestRollbackCreateClientTimeout(t *testing.T) {
+	apiClient := &APIClientCreateTimeoutMock{}
+	retErr := createFailing(t, apiClient, getUnitTestConfig())
+
+	assert.Equal(t, retErr.Code, "1011")
+	assert.Equal(t, retErr.Compensation, compensationDeleted)
+	assert.DeepEqual(t, apiClient.deleted, []string{"test"})
+}
+
+func TestRollbackCreateClientTimeoutNotOwned(t *testing.T) {
+	apiClient := &APIClientCreateTimeoutMock{owner: "other"}
+	retErr := createFailing(t, apiClient, getUnitTestConfig())
+
+	assert.Equal(t, retErr.Compensation, compensationNotOwned)
+	assert.Assert(t, apiClient.deleted == nil)
+}
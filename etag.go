@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/p53/idp-api/apierror"
+	"github.com/p53/idp-api/logging"
+)
+
+// representationVersion - hash of client representation returned by idp, keys of maps
+// are marshalled sorted so equal representations have equal version
+func representationVersion(representation interface{}) string {
+	raw, err := json.Marshal(representation)
+
+	if err != nil {
+		logging.GetLogger().Printf("Can't compute version of representation %s", err)
+		return ""
+	}
+
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:16])
+}
+
+// clientETag - strong entity tag of client
+func clientETag(clientOut *ClientOut) string {
+	version := clientOut.Version
+
+	if version == "" {
+		version = representationVersion(clientOut)
+	}
+
+	return fmt.Sprintf(`"%s"`, version)
+}
+
+// checkIfMatch - compares If-Match header with current entity tag of client, writes
+// PreconditionFailed when client changed since caller read it, missing header always matches
+func checkIfMatch(ctx context.Context, w http.ResponseWriter, r *http.Request, clientOut *ClientOut) error {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+
+	if ifMatch == "" || ifMatch == "*" {
+		return nil
+	}
+
+	current := clientETag(clientOut)
+
+	for _, tag := range strings.Split(ifMatch, ",") {
+		// weak tags never match with strong comparison required by If-Match
+		if strings.TrimSpace(tag) == current {
+			return nil
+		}
+	}
+
+	inverr := apierror.WithDetail(apierror.PreconditionFailed(), "Current ETag is "+current)
+	logging.GetLogger().Println(inverr)
+	writeError(ctx, w, inverr)
+	return inverr
+}
+
+// setETag - reads client after change and sets its entity tag on response, failure
+// only leaves header out as change itself succeeded
+func (controller *Controller) setETag(ctx context.Context, w http.ResponseWriter, token string, client Client) {
+	clientOut, err := controller.Config.HTTPClient.getClient(ctx, newDiscardWriter(), controller, token, client)
+
+	if err != nil {
+		logging.GetLogger().Printf("Can't read ETag of client %s %s", client.ClientID, err)
+		return
+	}
+
+	w.Header().Set("ETag", clientETag(clientOut))
+}
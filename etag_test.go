@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestRepresentationVersion(t *testing.T) {
+	var first, reordered, changed interface{}
+	json.Unmarshal([]byte(`{"clientId":"test","enabled":true}`), &first)
+	json.Unmarshal([]byte(`{"enabled":true,"clientId":"test"}`), &reordered)
+	json.Unmarshal([]byte(`{"clientId":"test","enabled":false}`), &changed)
+
+	assert.Equal(t, representationVersion(first), representationVersion(reordered))
+	assert.Assert(t, representationVersion(first) != representationVersion(changed))
+}
+
+func TestETagConditionalPatch(t *testing.T) {
+	apiClient := &APIClientRecordingMock{client: testExistingClient}
+	testConfig := getMockedTestConfig(apiClient)
+	rr := sendRequest(t, testConfig, "GET", "/client/test", "", nil)
+
+	assert.Equal(t, rr.Code, 200)
+	etag := rr.Header().Get("ETag")
+	assert.Assert(t, etag != "")
+
+	patch := func(ifMatch string) int {
+		header := http.Header{"If-Match": {ifMatch}}
+		return sendRequest(t, testConfig, "PATCH", "/client/test", `{"serviceAccountsEnabled": true}`, header).Code
+	}
+
+	assert.Equal(t, patch(`"stale"`), 412)
+	assert.Equal(t, patch("W/"+etag), 412)
+	assert.Assert(t, apiClient.updated == nil)
+	assert.Equal(t, patch(`"stale", `+etag), 200)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/p53/idp-api/apierror"
+	"github.com/p53/idp-api/logging"
+)
+
+// IdempotencyKeyHeader - header with caller chosen key identifying retries of same create
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength - longer keys are rejected
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyStore - keeps first result of create per caller and idempotency key for TTL,
+// response bodies are encrypted as they contain client secrets
+type IdempotencyStore struct {
+	TTL     time.Duration
+	aead    cipher.AEAD
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	now     func() time.Time
+}
+
+type idempotencyEntry struct {
+	fingerprint [32]byte
+	inFlight    bool
+	// resumable - failed attempt may have created client, retry takes it over
+	resumable   bool
+	status      int
+	contentType string
+	sealedBody  []byte
+	expires     time.Time
+}
+
+// createAttempt - state of single create shared with idempotent retries of it
+type createAttempt struct {
+	// resume - earlier attempt may have created client, client owned by caller
+	// found under requested clientId is taken over instead of failing with conflict
+	resume bool
+	// created - create was sent to idp, client may exist even when attempt failed
+	created bool
+}
+
+// storedResponse - decrypted response replayed to retry
+type storedResponse struct {
+	status      int
+	contentType string
+	body        []byte
+}
+
+// NewIdempotencyStore - creates store encrypting responses with key derived from secret,
+// random key is used when secret is empty
+func NewIdempotencyStore(ttl time.Duration, secret string) (*IdempotencyStore, error) {
+	key := make([]byte, 32)
+
+	if secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		key = sum[:]
+	} else if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &IdempotencyStore{
+		TTL:     ttl,
+		aead:    aead,
+		entries: map[string]*idempotencyEntry{},
+		now:     time.Now,
+	}, nil
+}
+
+// begin - returns stored response for retry with same payload, error for payload mismatch or
+// unfinished first request, both nil when request is first and should be executed,
+// resume is true when request should continue with client created by failed earlier attempt
+func (store *IdempotencyStore) begin(scope string, fingerprint [32]byte) (stored *storedResponse, resume bool, err error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := store.now()
+
+	for key, entry := range store.entries {
+		if !entry.inFlight && !entry.expires.After(now) {
+			delete(store.entries, key)
+		}
+	}
+
+	entry, ok := store.entries[scope]
+
+	if !ok {
+		store.entries[scope] = &idempotencyEntry{fingerprint: fingerprint, inFlight: true}
+		return nil, false, nil
+	}
+
+	if entry.fingerprint != fingerprint {
+		return nil, false, apierror.IdempotencyKeyMismatch()
+	}
+
+	if entry.inFlight {
+		return nil, false, apierror.IdempotencyKeyInUse()
+	}
+
+	if entry.resumable {
+		entry.inFlight = true
+		return nil, true, nil
+	}
+
+	nonceSize := store.aead.NonceSize()
+	body, err := store.aead.Open(nil, entry.sealedBody[:nonceSize], entry.sealedBody[nonceSize:], []byte(scope))
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &storedResponse{status: entry.status, contentType: entry.contentType, body: body}, false, nil
+}
+
+// finish - stores result of first request, server errors and unfinished requests
+// are not stored so retry is executed again, when client was possibly created retry
+// resumes with it instead of replaying conflict caused by this attempt
+func (store *IdempotencyStore) finish(scope string, status int, contentType string, body []byte, created bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entry, ok := store.entries[scope]
+
+	if !ok {
+		return
+	}
+
+	if status == 0 || status >= 500 {
+		if !created && !entry.resumable {
+			delete(store.entries, scope)
+			return
+		}
+
+		entry.inFlight = false
+		entry.resumable = true
+		entry.expires = store.now().Add(store.TTL)
+		return
+	}
+
+	nonce := make([]byte, store.aead.NonceSize())
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		logging.GetLogger().Printf("Can't store idempotent response %s", err)
+		delete(store.entries, scope)
+		return
+	}
+
+	entry.inFlight = false
+	entry.resumable = false
+	entry.status = status
+	entry.contentType = contentType
+	entry.sealedBody = store.aead.Seal(nonce, nonce, body, []byte(scope))
+	entry.expires = store.now().Add(store.TTL)
+}
+
+// createIdempotent - creates client at most once per caller and idempotency key,
+// retries with identical payload get replay of first response
+func (controller *Controller) createIdempotent(w http.ResponseWriter, r *http.Request, caller Caller, key string) {
+	logger := logging.GetLogger()
+	store := controller.Config.Idempotency
+
+	if len(key) > maxIdempotencyKeyLength {
+		writeError(r.Context(), w, apierror.InvalidIdempotencyKey())
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		logger.Println(err)
+		writeError(r.Context(), w, apierror.InternalServerError())
+		return
+	}
+
+	scope := caller.Name + "\x00" + key
+	fingerprint := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+	stored, resume, err := store.begin(scope, fingerprint)
+
+	if err != nil {
+		logger.Printf("Idempotency key %s of %s rejected %s", key, caller.Name, err)
+		writeError(r.Context(), w, err)
+		return
+	}
+
+	if stored != nil {
+		logger.Printf("Replaying response for idempotency key %s of %s", key, caller.Name)
+		w.Header().Set("Content-Type", stored.contentType)
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.status)
+		w.Write(stored.body)
+		return
+	}
+
+	rec := &recordingWriter{ResponseWriter: w}
+	attempt := &createAttempt{resume: resume}
+
+	defer func() {
+		store.finish(scope, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes(), attempt.created)
+	}()
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	controller.createResource(rec, r, caller, attempt)
+}
+
+// createAttempted - creates client in idp, resumed attempt takes over client owned by caller
+// which earlier attempt created, errors are returned instead of being written to caller
+func (controller *Controller) createAttempted(
+	ctx context.Context,
+	token string,
+	caller Caller,
+	client Client,
+	attempt *createAttempt) error {
+	httpClient := controller.Config.HTTPClient
+	dw := newDiscardWriter()
+	client = withoutProtocolMappers(withoutClientScopes(client))
+	attempt.created = true
+	err := httpClient.createClient(ctx, dw, controller, token, client)
+
+	if !attempt.resume || apierror.CodeOf(err) != apierror.CodeOf(apierror.Conflict()) {
+		return err
+	}
+
+	clientInf, err := httpClient.getClient(ctx, dw, controller, token, client)
+
+	if err != nil {
+		return err
+	}
+
+	if !isOwner(caller, clientInf) {
+		return apierror.Conflict()
+	}
+
+	logging.GetLogger().Printf("Resuming create of client %s by %s with client created by earlier attempt", client.ClientID, caller.Name)
+	return nil
+}
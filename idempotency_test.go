@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+// APIClientCountingMock - counts created clients
+type APIClientCountingMock struct {
+	APIClientMock
+	creates int
+}
+
+func (s *APIClientCountingMock) createClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client) (err error) {
+	s.creates++
+	return nil
+}
+
+// APIClientHalfCreatedMock - keeps created client, times out first read of its secret
+// and fails to delete it
+type APIClientHalfCreatedMock struct {
+	APIClientMock
+	created        *Client
+	creates        int
+	secretTimeouts int
+}
+
+func (s *APIClientHalfCreatedMock) createClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client) (err error) {
+	if s.created != nil {
+		inverr := apierror.Conflict()
+		writeError(ctx, w, inverr)
+		return inverr
+	}
+
+	s.created = &client
+	s.creates++
+	return nil
+}
+
+func (s *APIClientHalfCreatedMock) getClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client) (clientOut *ClientOut, err error) {
+	if s.created == nil {
+		inverr := apierror.ClientNotFound()
+		writeError(ctx, w, inverr)
+		return nil, inverr
+	}
+
+	return &ClientOut{ID: "test", ClientID: s.created.ClientID, Attributes: s.created.Attributes}, nil
+}
+
+func (s *APIClientHalfCreatedMock) getClientSecret(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (clientSecret string, err error) {
+	if s.secretTimeouts > 0 {
+		s.secretTimeouts--
+		inverr := apierror.UpstreamTimeout()
+		writeError(ctx, w, inverr)
+		return "", inverr
+	}
+
+	return "testsecret", nil
+}
+
+func (s *APIClientHalfCreatedMock) deleteClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (err error) {
+	inverr := apierror.UpstreamUnavailable()
+	writeError(ctx, w, inverr)
+	return inverr
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	store, err := NewIdempotencyStore(0, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint := sha256.Sum256([]byte("payload"))
+	stored, resume, err := store.begin("test\x00key", fingerprint)
+	assert.Assert(t, stored == nil && !resume && err == nil)
+
+	_, _, err = store.begin("test\x00key", fingerprint)
+	assert.Equal(t, err.(*apierror.ApiError).Code, "1029")
+
+	// server errors are not remembered
+	store.finish("test\x00key", 500, "application/problem+json", []byte("{}"), false)
+	stored, resume, err = store.begin("test\x00key", fingerprint)
+	assert.Assert(t, stored == nil && !resume && err == nil)
+
+	// server error after client was possibly created resumes
+	store.TTL = time.Hour
+	store.finish("test\x00key", 504, "application/problem+json", []byte("{}"), true)
+	stored, resume, err = store.begin("test\x00key", fingerprint)
+	assert.Assert(t, stored == nil && resume && err == nil)
+
+	_, _, err = store.begin("test\x00key", fingerprint)
+	assert.Equal(t, err.(*apierror.ApiError).Code, "1029")
+
+	store.finish("test\x00key", 201, "application/json", []byte(`{"value":"secret"}`), true)
+	assert.Assert(t, !bytes.Contains(store.entries["test\x00key"].sealedBody, []byte("secret")))
+
+	stored, resume, err = store.begin("test\x00key", fingerprint)
+	assert.NilError(t, err)
+	assert.Assert(t, !resume)
+	assert.Equal(t, stored.status, 201)
+	assert.Equal(t, string(stored.body), `{"value":"secret"}`)
+
+	_, _, err = store.begin("test\x00key", sha256.Sum256([]byte("other")))
+	assert.Equal(t, err.(*apierror.ApiError).Code, "1028")
+}
+
+func TestIdempotentCreateClient(t *testing.T) {
+	apiClient := &APIClientCountingMock{}
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.Idempotency, _ = NewIdempotencyStore(time.Hour, "test")
+
+	send := func(payload string) *httptest.ResponseRecorder {
+		return sendRequest(t, testConfig, "POST", "/client", payload, http.Header{IdempotencyKeyHeader: {"create-test-1"}})
+	}
+
+	first := send(testPayload)
+	assert.Equal(t, first.Code, 201)
+
+	retry := send(testPayload)
+	assert.Equal(t, retry.Code, 201)
+	assert.Equal(t, retry.Body.String(), first.Body.String())
+	assert.Equal(t, retry.Header().Get("Idempotent-Replayed"), "true")
+	assert.Equal(t, apiClient.creates, 1)
+
+	mismatch := send(testNewClient)
+	assert.Equal(t, mismatch.Code, 422)
+	assert.Equal(t, apiClient.creates, 1)
+}
+
+func TestIdempotentCreateResumedAfterTimeout(t *testing.T) {
+	apiClient := &APIClientHalfCreatedMock{secretTimeouts: 1}
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.Idempotency, _ = NewIdempotencyStore(time.Hour, "test")
+
+	send := func() *httptest.ResponseRecorder {
+		return sendRequest(t, testConfig, "POST", "/client", testPayload, http.Header{IdempotencyKeyHeader: {"create-test-2"}})
+	}
+
+	// client is created but its secret read times out and rollback fails
+	first := send()
+	assert.Equal(t, first.Code, 504, first.Body.String())
+
+	// retry continues with client created by first attempt instead of conflict
+	retry := send()
+	assert.Equal(t, retry.Code, 201, retry.Body.String())
+	assert.Equal(t, retry.Body.String(), `{"value":"testsecret"}`)
+	assert.Equal(t, apiClient.creates, 1)
+
+	replay := send()
+	assert.Equal(t, replay.Code, 201)
+	assert.Equal(t, replay.Header().Get("Idempotent-Replayed"), "true")
+}
@@ -2,36 +2,127 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/mitchellh/mapstructure"
-	"github.com/thedevsaddam/gojsonq"
 	"github.com/p53/idp-api/apierror"
 	"github.com/p53/idp-api/logging"
+	"github.com/thedevsaddam/gojsonq"
 )
 
 // APIClientIntf - interface for idp api client
 type APIClientIntf interface {
 	doRequest(req *http.Request) ([]byte, error)
 	authenticate(w http.ResponseWriter, r *http.Request, controller *Controller, f AuthBodyGetter) (tokenVal string, authEntity string, err error)
-	createClient(w http.ResponseWriter, controller *Controller, token string, client Client) (err error)
-	getClientID(w http.ResponseWriter, controller *Controller, token string, client ClientWithSecret) (clientID string, err error)
-	getClient(w http.ResponseWriter, controller *Controller, token string, client Client) (clientOut *ClientOut, err error)
-	getClientSecret(w http.ResponseWriter, controller *Controller, token string, clientUID string) (clientSecret string, err error)
-	updateClient(w http.ResponseWriter, controller *Controller, token string, client Client, clientUID string) (err error)
-	deleteClient(w http.ResponseWriter, controller *Controller, token string, clientUID string) (err error)
+	createClient(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, client Client) (err error)
+	getClientID(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, client ClientWithSecret) (clientID string, err error)
+	getClient(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, client Client) (clientOut *ClientOut, err error)
+	listClients(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, first int, max int) (clients []ClientOut, err error)
+	getClientSecret(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string) (clientSecret string, err error)
+	updateClient(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, client Client, clientUID string) (err error)
+	deleteClient(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string) (err error)
+	verifyClientAssertion(ctx context.Context, w http.ResponseWriter, controller *Controller, clientID string, assertion string) (err error)
+	getSamlDescriptor(ctx context.Context, w http.ResponseWriter, controller *Controller) (descriptor []byte, err error)
+	listClientRoles(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string) (roles []Role, err error)
+	createClientRole(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string, role Role) (err error)
+	updateClientRole(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string, roleName string, role Role) (err error)
+	deleteClientRole(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string, roleName string) (err error)
+	getClientRoleComposites(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string, roleName string) (roles []Role, err error)
+	addClientRoleComposites(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string, roleName string, roles []Role) (err error)
+	removeClientRoleComposites(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string, roleName string, roles []Role) (err error)
+	getServiceAccountUser(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string) (userID string, err error)
+	getRealmRole(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, roleName string) (role *Role, err error)
+	getRoleMappings(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, userID string) (mappings *RoleMappings, err error)
+	addRoleMappings(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, userID string, clientUID string, roles []Role) (err error)
+	removeRoleMappings(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, userID string, clientUID string, roles []Role) (err error)
+	listClientScopes(ctx context.Context, w http.ResponseWriter, controller *Controller, token string) (scopes []ClientScope, err error)
+	listAssignedClientScopes(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string, kind string) (scopes []ClientScope, err error)
+	addClientScope(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string, kind string, scopeID string) (err error)
+	removeClientScope(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string, kind string, scopeID string) (err error)
+	createProtocolMapper(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string, mapper ProtocolMapper) (err error)
+	updateProtocolMapper(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string, mapper ProtocolMapper) (err error)
+	deleteProtocolMapper(ctx context.Context, w http.ResponseWriter, controller *Controller, token string, clientUID string, mapperID string) (err error)
+	upstreamStats() UpstreamStats
 }
 
 // APIClient - type for defining idp api client
 type APIClient struct {
 	BaseClient *http.Client
+	Retry      RetryPolicy
+	Breaker    *CircuitBreaker
+	retries    uint64
+	failures   uint64
+	rejections uint64
+}
+
+// UpstreamError - type for defining non-2xx response of idp
+type UpstreamError struct {
+	StatusCode int
+	Message    string
+}
+
+// KeycloakError - type for defining error body returned by keycloak admin and token endpoints
+type KeycloakError struct {
+	ErrorMessage     string `json:"errorMessage"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("Identity provider responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// newUpstreamError - creates upstream error, message is parsed from keycloak error body
+// falling back to raw body
+func newUpstreamError(statusCode int, body []byte) *UpstreamError {
+	upErr := &UpstreamError{
+		StatusCode: statusCode,
+		Message:    strings.TrimSpace(string(body)),
+	}
+
+	kcErr := &KeycloakError{}
+
+	if err := json.Unmarshal(body, kcErr); err != nil {
+		return upErr
+	}
+
+	switch {
+	case kcErr.ErrorMessage != "":
+		upErr.Message = kcErr.ErrorMessage
+	case kcErr.ErrorDescription != "":
+		upErr.Message = kcErr.ErrorDescription
+	case kcErr.Error != "":
+		upErr.Message = kcErr.Error
+	}
+
+	return upErr
+}
+
+// upstreamStatusErrors - api errors for idp response codes which are passed to caller
+var upstreamStatusErrors = map[int]func() error{
+	400: apierror.UpstreamBadRequest,
+	403: apierror.Forbidden,
+	404: apierror.ResourceNotFound,
+	409: apierror.Conflict,
+}
+
+// UpstreamStats - type for defining counters of idp calls
+type UpstreamStats struct {
+	BreakerState string
+	Retries      uint64
+	Failures     uint64
+	Rejections   uint64
 }
 
 // Token - type for defining token outpu
@@ -87,6 +178,7 @@ func (s *APIClientMock) authenticate(
 }
 
 func (s *APIClientMock) createClient(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
@@ -95,6 +187,7 @@ func (s *APIClientMock) createClient(
 }
 
 func (s *APIClientMock) getClientID(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
@@ -103,6 +196,7 @@ func (s *APIClientMock) getClientID(
 }
 
 func (s *APIClientMock) getClient(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
@@ -110,7 +204,22 @@ func (s *APIClientMock) getClient(
 	return &ClientOut{ID: "test"}, nil
 }
 
+func (s *APIClientMock) listClients(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	first int,
+	max int) (clients []ClientOut, err error) {
+	if first > 0 {
+		return []ClientOut{}, nil
+	}
+
+	return []ClientOut{{ID: "test", ClientID: "test"}}, nil
+}
+
 func (s *APIClientMock) getClientSecret(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
@@ -119,6 +228,7 @@ func (s *APIClientMock) getClientSecret(
 }
 
 func (s *APIClientMock) updateClient(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
@@ -128,6 +238,7 @@ func (s *APIClientMock) updateClient(
 }
 
 func (s *APIClientMock) deleteClient(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
@@ -135,6 +246,217 @@ func (s *APIClientMock) deleteClient(
 	return
 }
 
+func (s *APIClientMock) verifyClientAssertion(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	clientID string,
+	assertion string) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) getSamlDescriptor(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller) (descriptor []byte, err error) {
+	return []byte(testSamlDescriptor), nil
+}
+
+func (s *APIClientMock) listClientRoles(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (roles []Role, err error) {
+	return []Role{}, nil
+}
+
+func (s *APIClientMock) createClientRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	role Role) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) updateClientRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string,
+	role Role) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) deleteClientRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) getClientRoleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string) (roles []Role, err error) {
+	return []Role{}, nil
+}
+
+func (s *APIClientMock) addClientRoleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string,
+	roles []Role) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) removeClientRoleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string,
+	roles []Role) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) getServiceAccountUser(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (userID string, err error) {
+	return "test-sa", nil
+}
+
+func (s *APIClientMock) getRealmRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	roleName string) (role *Role, err error) {
+	return &Role{ID: roleName + "-id", Name: roleName}, nil
+}
+
+func (s *APIClientMock) getRoleMappings(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	userID string) (mappings *RoleMappings, err error) {
+	return &RoleMappings{}, nil
+}
+
+func (s *APIClientMock) addRoleMappings(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	userID string,
+	clientUID string,
+	roles []Role) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) removeRoleMappings(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	userID string,
+	clientUID string,
+	roles []Role) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) listClientScopes(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string) (scopes []ClientScope, err error) {
+	return testClientScopes, nil
+}
+
+func (s *APIClientMock) listAssignedClientScopes(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	kind string) (scopes []ClientScope, err error) {
+	return []ClientScope{}, nil
+}
+
+func (s *APIClientMock) addClientScope(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	kind string,
+	scopeID string) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) removeClientScope(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	kind string,
+	scopeID string) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) createProtocolMapper(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	mapper ProtocolMapper) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) updateProtocolMapper(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	mapper ProtocolMapper) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) deleteProtocolMapper(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	mapperID string) (err error) {
+	return nil
+}
+
+func (s *APIClientMock) upstreamStats() UpstreamStats {
+	return UpstreamStats{BreakerState: breakerClosed}
+}
+
 // APIClientInternalServerErrorMock - api client mock to simulate error situations
 type APIClientInternalServerErrorMock struct{}
 
@@ -152,15 +474,17 @@ func (s *APIClientInternalServerErrorMock) authenticate(
 }
 
 func (s *APIClientInternalServerErrorMock) createClient(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
 	client Client) (err error) {
-	http.Error(w, "Test Idp API Failure", 500)
+	writeError(ctx, w, errors.New("Test Idp API Failure"))
 	return errors.New("Test Idp API Failure")
 }
 
 func (s *APIClientInternalServerErrorMock) getClientID(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
@@ -169,6 +493,7 @@ func (s *APIClientInternalServerErrorMock) getClientID(
 }
 
 func (s *APIClientInternalServerErrorMock) getClient(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
@@ -176,7 +501,19 @@ func (s *APIClientInternalServerErrorMock) getClient(
 	return &ClientOut{ID: "test"}, nil
 }
 
+func (s *APIClientInternalServerErrorMock) listClients(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	first int,
+	max int) (clients []ClientOut, err error) {
+	writeError(ctx, w, errors.New("Test Idp API Failure"))
+	return nil, errors.New("Test Idp API Failure")
+}
+
 func (s *APIClientInternalServerErrorMock) getClientSecret(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
@@ -185,16 +522,18 @@ func (s *APIClientInternalServerErrorMock) getClientSecret(
 }
 
 func (s *APIClientInternalServerErrorMock) updateClient(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
 	client Client,
 	clientUID string) (err error) {
-	http.Error(w, "Test Idp API Failure", 500)
+	writeError(ctx, w, errors.New("Test Idp API Failure"))
 	return errors.New("Test Idp API Failure")
 }
 
 func (s *APIClientInternalServerErrorMock) deleteClient(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
@@ -202,91 +541,442 @@ func (s *APIClientInternalServerErrorMock) deleteClient(
 	return
 }
 
-func (s *APIClient) doRequest(req *http.Request) ([]byte, error) {
-	logger := logging.GetLogger()
-	resp, err := s.BaseClient.Do(req)
-
-	if err != nil {
-		return nil, err
-	}
-
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-
-	if err != nil {
-		return nil, err
-	}
-
-	if 200 != resp.StatusCode && 201 != resp.StatusCode && 204 != resp.StatusCode {
-		logger.Printf("Response code from URL: %s is %d", req.URL, resp.StatusCode)
-		logger.Printf(string(body))
-		msg := fmt.Sprintf("%s", body)
-		return nil, errors.New(msg)
-	}
-
-	return body, nil
+func (s *APIClientInternalServerErrorMock) verifyClientAssertion(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	clientID string,
+	assertion string) (err error) {
+	inverr := apierror.UpstreamError()
+	writeError(ctx, w, inverr)
+	return inverr
 }
 
-func getAdminAuthBody(
+func (s *APIClientInternalServerErrorMock) getSamlDescriptor(
+	ctx context.Context,
 	w http.ResponseWriter,
-	r *http.Request,
-	controller *Controller) (authBody []url.Values, authUrl string, err error) {
-
-	authClientCredentialAdminBody := url.Values{
-		"username":      {controller.Config.IdpAdmin},
-		"password":      {controller.Config.IdpPass},
-		"grant_type":    {"password"},
-		"client_id":     {controller.Config.ApiClientID},
-		"client_secret": {controller.Config.ApiClientSecret},
-	}
+	controller *Controller) (descriptor []byte, err error) {
+	return
+}
 
-	authBody = []url.Values{authClientCredentialAdminBody}
-	authUrl = fmt.Sprintf(controller.Config.TokenURI, controller.Config.IdpURL, "master")
+func (s *APIClientInternalServerErrorMock) listClientRoles(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (roles []Role, err error) {
+	return
+}
 
-	return authBody, authUrl, nil
+func (s *APIClientInternalServerErrorMock) createClientRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	role Role) (err error) {
+	return
 }
 
-func getAuthBodyFromBasicAuth(
+func (s *APIClientInternalServerErrorMock) updateClientRole(
+	ctx context.Context,
 	w http.ResponseWriter,
-	r *http.Request,
-	controller *Controller) (authBody []url.Values, authUrl string, err error) {
-	logger := logging.GetLogger()
-	username, password, ok := r.BasicAuth()
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string,
+	role Role) (err error) {
+	return
+}
 
-	if !ok {
-		authHedErr := apierror.InvalidBasicAuthHeaders()
-		logger.Println(authHedErr.Error())
-		http.Error(w, authHedErr.Error(), 401)
-		return nil, "", authHedErr
-	}
+func (s *APIClientInternalServerErrorMock) deleteClientRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string) (err error) {
+	return
+}
 
-	authResourceOwnerBody := url.Values{
-		"username":      {username},
-		"password":      {password},
-		"grant_type":    {"password"},
-		"client_id":     {controller.Config.ClientID},
-		"client_secret": {controller.Config.ClientSecret},
-	}
+func (s *APIClientInternalServerErrorMock) getClientRoleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string) (roles []Role, err error) {
+	return
+}
 
-	authClientBody := url.Values{
-		"grant_type":    {"client_credentials"},
-		"client_id":     {username},
-		"client_secret": {password},
-	}
+func (s *APIClientInternalServerErrorMock) addClientRoleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string,
+	roles []Role) (err error) {
+	return
+}
 
-	authBody = []url.Values{authResourceOwnerBody, authClientBody}
-	authUrl = fmt.Sprintf(controller.Config.TokenURI, controller.Config.IdpURL, controller.Config.IdpRealm)
+func (s *APIClientInternalServerErrorMock) removeClientRoleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string,
+	roles []Role) (err error) {
+	return
+}
 
-	return authBody, authUrl, nil
+func (s *APIClientInternalServerErrorMock) getServiceAccountUser(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (userID string, err error) {
+	return
 }
 
-func (s *APIClient) authenticate(
+func (s *APIClientInternalServerErrorMock) getRealmRole(
+	ctx context.Context,
 	w http.ResponseWriter,
-	r *http.Request,
 	controller *Controller,
-	f AuthBodyGetter) (tokenVal string, authEntity string, err error) {
-	logger := logging.GetLogger()
+	token string,
+	roleName string) (role *Role, err error) {
+	return
+}
+
+func (s *APIClientInternalServerErrorMock) getRoleMappings(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	userID string) (mappings *RoleMappings, err error) {
+	return
+}
+
+func (s *APIClientInternalServerErrorMock) addRoleMappings(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	userID string,
+	clientUID string,
+	roles []Role) (err error) {
+	return
+}
+
+func (s *APIClientInternalServerErrorMock) removeRoleMappings(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	userID string,
+	clientUID string,
+	roles []Role) (err error) {
+	return
+}
+
+func (s *APIClientInternalServerErrorMock) listClientScopes(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string) (scopes []ClientScope, err error) {
+	return
+}
+
+func (s *APIClientInternalServerErrorMock) listAssignedClientScopes(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	kind string) (scopes []ClientScope, err error) {
+	return
+}
+
+func (s *APIClientInternalServerErrorMock) addClientScope(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	kind string,
+	scopeID string) (err error) {
+	return
+}
+
+func (s *APIClientInternalServerErrorMock) removeClientScope(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	kind string,
+	scopeID string) (err error) {
+	return
+}
+
+func (s *APIClientInternalServerErrorMock) createProtocolMapper(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	mapper ProtocolMapper) (err error) {
+	return
+}
+
+func (s *APIClientInternalServerErrorMock) updateProtocolMapper(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	mapper ProtocolMapper) (err error) {
+	return
+}
+
+func (s *APIClientInternalServerErrorMock) deleteProtocolMapper(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	mapperID string) (err error) {
+	return
+}
+
+func (s *APIClientInternalServerErrorMock) upstreamStats() UpstreamStats {
+	return UpstreamStats{BreakerState: breakerOpen, Failures: 1}
+}
+
+func (s *APIClient) doRequest(req *http.Request) ([]byte, error) {
+	logger := logging.GetLogger()
+
+	for attempt := 0; ; attempt++ {
+		if s.Breaker != nil && !s.Breaker.allow() {
+			atomic.AddUint64(&s.rejections, 1)
+			logger.Printf("Circuit breaker open, rejecting request to URL: %s", req.URL)
+			return nil, apierror.UpstreamUnavailable()
+		}
+
+		body, status, err := s.send(req)
+		s.recordAttempt(status, err)
+
+		if err == nil {
+			return body, nil
+		}
+
+		if attempt >= s.Retry.MaxRetries || !retryable(req, status, err) {
+			return nil, err
+		}
+
+		delay := s.Retry.backoff(attempt)
+		logger.Printf("Retrying request to URL: %s in %s, attempt %d", req.URL, delay, attempt+1)
+
+		if errSleep := sleepContext(req.Context(), delay); errSleep != nil {
+			return nil, err
+		}
+
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		atomic.AddUint64(&s.retries, 1)
+	}
+}
+
+// send - performs single attempt of request, returns status 0 on transport error
+func (s *APIClient) send(req *http.Request) ([]byte, int, error) {
+	logger := logging.GetLogger()
+	resp, err := s.BaseClient.Do(req)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if 200 != resp.StatusCode && 201 != resp.StatusCode && 204 != resp.StatusCode {
+		logger.Printf("Response code from URL: %s is %d", req.URL, resp.StatusCode)
+		logger.Println(string(body))
+		return nil, resp.StatusCode, newUpstreamError(resp.StatusCode, body)
+	}
+
+	return body, resp.StatusCode, nil
+}
+
+// recordAttempt - feeds result of attempt to circuit breaker, transport errors and 5xx
+// responses are failures of idp, cancellation by caller is not, but it frees trial of half-open breaker
+func (s *APIClient) recordAttempt(status int, err error) {
+	if errors.Is(err, context.Canceled) {
+		if s.Breaker != nil {
+			s.Breaker.cancelled()
+		}
+
+		return
+	}
+
+	if (status == 0 && err != nil) || status >= 500 {
+		atomic.AddUint64(&s.failures, 1)
+
+		if s.Breaker != nil {
+			s.Breaker.failure()
+		}
+
+		return
+	}
+
+	if s.Breaker != nil {
+		s.Breaker.success()
+	}
+}
+
+// upstreamStats - returns counters of idp calls and circuit breaker state
+func (s *APIClient) upstreamStats() UpstreamStats {
+	stats := UpstreamStats{
+		BreakerState: breakerClosed,
+		Retries:      atomic.LoadUint64(&s.retries),
+		Failures:     atomic.LoadUint64(&s.failures),
+		Rejections:   atomic.LoadUint64(&s.rejections),
+	}
+
+	if s.Breaker != nil {
+		stats.BreakerState = s.Breaker.State()
+	}
+
+	return stats
+}
+
+// operationContext - derives context for idp operation from request context,
+// bounded by timeout configured for the operation
+func operationContext(ctx context.Context, config *Config, operation string) (context.Context, context.CancelFunc) {
+	timeout := config.RequestTimeout
+
+	if opTimeout, ok := config.OperationTimeouts[operation]; ok {
+		timeout = opTimeout
+	}
+
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// upstreamError - translates error returned by idp request to api error, underlying error
+// and idp message are only logged as they expose internals of idp
+func upstreamError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return apierror.UpstreamTimeout()
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return apierror.RequestCancelled()
+	}
+
+	if _, ok := err.(*apierror.ApiError); ok {
+		return err
+	}
+
+	logging.GetLogger().Printf("Idp request failed %s", err)
+	upErr, ok := err.(*UpstreamError)
+
+	if !ok {
+		return apierror.UpstreamError()
+	}
+
+	constructor, ok := upstreamStatusErrors[upErr.StatusCode]
+
+	if !ok {
+		constructor = apierror.UpstreamError
+	}
+
+	return constructor()
+}
+
+// credentialsRejected - token endpoint rejected credentials, keycloak answers invalid_grant
+// with 400 and invalid_client with 401, other failures say nothing about credentials
+func credentialsRejected(err error) bool {
+	upErr, ok := err.(*UpstreamError)
+	return ok && (upErr.StatusCode == http.StatusBadRequest || upErr.StatusCode == http.StatusUnauthorized)
+}
+
+func getAdminAuthBody(
+	w http.ResponseWriter,
+	r *http.Request,
+	controller *Controller) (authBody []url.Values, authUrl string, err error) {
+
+	authClientCredentialAdminBody := url.Values{
+		"username":      {controller.Config.IdpAdmin},
+		"password":      {controller.Config.IdpPass},
+		"grant_type":    {"password"},
+		"client_id":     {controller.Config.ApiClientID},
+		"client_secret": {controller.Config.ApiClientSecret},
+	}
+
+	authBody = []url.Values{authClientCredentialAdminBody}
+	authUrl = fmt.Sprintf(controller.Config.TokenURI, controller.Config.IdpURL, "master")
+
+	return authBody, authUrl, nil
+}
+
+func getAuthBodyFromBasicAuth(
+	w http.ResponseWriter,
+	r *http.Request,
+	controller *Controller) (authBody []url.Values, authUrl string, err error) {
+	logger := logging.GetLogger()
+	username, password, ok := r.BasicAuth()
+
+	if !ok {
+		authHedErr := apierror.InvalidBasicAuthHeaders()
+		logger.Println(authHedErr.Error())
+		writeError(r.Context(), w, authHedErr)
+		return nil, "", authHedErr
+	}
+
+	authResourceOwnerBody := url.Values{
+		"username":      {username},
+		"password":      {password},
+		"grant_type":    {"password"},
+		"client_id":     {controller.Config.ClientID},
+		"client_secret": {controller.Config.ClientSecret},
+	}
+
+	authClientBody := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {username},
+		"client_secret": {password},
+	}
+
+	authBody = []url.Values{authResourceOwnerBody, authClientBody}
+	authUrl = fmt.Sprintf(controller.Config.TokenURI, controller.Config.IdpURL, controller.Config.IdpRealm)
+
+	return authBody, authUrl, nil
+}
+
+func (s *APIClient) authenticate(
+	w http.ResponseWriter,
+	r *http.Request,
+	controller *Controller,
+	f AuthBodyGetter) (tokenVal string, authEntity string, err error) {
+	logger := logging.GetLogger()
+	ctx, cancel := operationContext(r.Context(), controller.Config, "authenticate")
+	defer cancel()
 
 	authBody, url, err := f(w, r, controller)
 
@@ -296,15 +986,17 @@ func (s *APIClient) authenticate(
 
 	var authErr error
 	var tokenBody []byte
+	// rejected - every failed attempt was rejection of credentials by token endpoint
+	rejected := true
 
 	for _, authBodyItem := range authBody {
 		form := strings.NewReader(authBodyItem.Encode())
 		logger.Println(url)
-		req, err := http.NewRequest("POST", url, form)
+		req, err := http.NewRequestWithContext(ctx, "POST", url, form)
 
 		if err != nil {
 			logger.Println(err)
-			http.Error(w, err.Error(), 401)
+			writeError(ctx, w, err)
 			return "", "", err
 		}
 
@@ -322,6 +1014,11 @@ func (s *APIClient) authenticate(
 
 		if authErr != nil {
 			logger.Printf("Failed auth attempt %s", authBodyItem)
+			rejected = rejected && credentialsRejected(authErr)
+
+			if ctx.Err() != nil {
+				break
+			}
 		}
 
 		if authErr == nil {
@@ -339,13 +1036,15 @@ func (s *APIClient) authenticate(
 
 	if authErr != nil {
 		logger.Printf("Failed all auth attempts %s", authErr)
-		errStr := fmt.Sprintf("%s", authErr)
-		inverr := apierror.ApiError{
-			Code:    "10000",
-			Message: errStr,
+		inverr := upstreamError(authErr)
+
+		// idp failures and timeouts are reported as such, only rejected credentials are auth failure
+		if rejected {
+			inverr = apierror.AuthenticationFailed()
+			markRejected(ctx)
 		}
 
-		http.Error(w, inverr.Error(), 401)
+		writeError(ctx, w, inverr)
 		return "", "", authErr
 	}
 
@@ -354,286 +1053,871 @@ func (s *APIClient) authenticate(
 
 	if uerr != nil {
 		logger.Println(uerr)
-		http.Error(w, uerr.Error(), 500)
+		writeError(ctx, w, uerr)
 		return "", "", uerr
 	}
 
-	return token.Value, authEntity, nil
+	return token.Value, authEntity, nil
+}
+
+func (s *APIClient) createClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client) (err error) {
+	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, controller.Config, "createClient")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.ClientsURI, controller.Config.IdpURL, controller.Config.IdpRealm)
+	byteArr, err := json.Marshal(client)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(byteArr))
+
+	if err != nil {
+		logger.Println(err)
+		writeError(ctx, w, err)
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	_, err = s.doRequest(req)
+
+	if err != nil {
+		logger.Println(err)
+		inverr := upstreamError(err)
+		writeError(ctx, w, inverr)
+		return inverr
+	}
+
+	return nil
+}
+
+// getClientId - method for getting idp client id info
+func (s *APIClient) getClientID(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client ClientWithSecret) (clientID string, err error) {
+	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, controller.Config, "getClientID")
+	defer cancel()
+
+	// keycloak matches clientId query exactly, so only searched client is returned
+	query := url.Values{"clientId": {client.ClientID}}
+	url := fmt.Sprintf(controller.Config.ClientsURI, controller.Config.IdpURL, controller.Config.IdpRealm)
+	req, err := http.NewRequestWithContext(ctx, "GET", url+"?"+query.Encode(), nil)
+
+	if err != nil {
+		logger.Println(err)
+		writeError(ctx, w, err)
+		return "", err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := s.doRequest(req)
+
+	if err != nil {
+		logger.Println(err)
+		inverr := upstreamError(err)
+		writeError(ctx, w, inverr)
+		return "", inverr
+	}
+
+	clientStruct := &ClientID{}
+
+	addRoot := fmt.Sprintf(`{"root": %s}`, string(resp))
+	jq := gojsonq.New().FromString(addRoot)
+
+	if jq.Error() != nil {
+		msg := fmt.Sprintf("Parsing response to jq failed, %s", jq.Errors())
+		log.Println(msg)
+		writeError(ctx, w, apierror.InternalServerError())
+		return "", jq.Error()
+	}
+
+	data := jq.From("root").Where("clientId", "=", client.ClientID).First()
+
+	if data == nil {
+		logger.Printf("Client %s not found", client.ClientID)
+		inverr := apierror.ClientNotFound()
+		writeError(ctx, w, inverr)
+		return "", inverr
+	}
+
+	mapstructure.Decode(data, clientStruct)
+
+	logger.Printf("Client %s id is %s", client.ClientID, clientStruct.ID)
+
+	return clientStruct.ID, nil
+}
+
+// getClient - method for getting idp client info
+func (s *APIClient) getClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client) (clientOut *ClientOut, err error) {
+	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, controller.Config, "getClient")
+	defer cancel()
+
+	// keycloak matches clientId query exactly, so only searched client is returned
+	query := url.Values{"clientId": {client.ClientID}}
+	url := fmt.Sprintf(controller.Config.ClientsURI, controller.Config.IdpURL, controller.Config.IdpRealm)
+	req, err := http.NewRequestWithContext(ctx, "GET", url+"?"+query.Encode(), nil)
+
+	if err != nil {
+		logger.Println(err)
+		writeError(ctx, w, err)
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := s.doRequest(req)
+
+	if err != nil {
+		logger.Println(err)
+		inverr := upstreamError(err)
+		writeError(ctx, w, inverr)
+		return nil, inverr
+	}
+
+	clientStruct := &ClientOut{}
+
+	addRoot := fmt.Sprintf(`{"root": %s}`, string(resp))
+	jq := gojsonq.New().FromString(addRoot)
+
+	if jq.Error() != nil {
+		msg := fmt.Sprintf("Parsing response to jq failed, %s", jq.Errors())
+		log.Println(msg)
+		writeError(ctx, w, apierror.InternalServerError())
+		return nil, jq.Error()
+	}
+
+	data := jq.From("root").Where("clientId", "=", client.ClientID).First()
+
+	if data == nil {
+		logger.Printf("Client %s not found", client.ClientID)
+		inverr := apierror.ClientNotFound()
+		writeError(ctx, w, inverr)
+		return nil, inverr
+	}
+
+	mapstructure.Decode(data, clientStruct)
+	clientStruct.Version = representationVersion(data)
+
+	logger.Printf("Client %s id is %s", client.ClientID, clientStruct.ID)
+
+	return clientStruct, nil
+}
+
+// listClients - method for getting single page of idp clients
+func (s *APIClient) listClients(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	first int,
+	max int) (clients []ClientOut, err error) {
+	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, controller.Config, "listClients")
+	defer cancel()
+
+	query := url.Values{
+		"first": {strconv.Itoa(first)},
+		"max":   {strconv.Itoa(max)},
+	}
+	url := fmt.Sprintf(controller.Config.ClientsURI, controller.Config.IdpURL, controller.Config.IdpRealm)
+	req, err := http.NewRequestWithContext(ctx, "GET", url+"?"+query.Encode(), nil)
+
+	if err != nil {
+		logger.Println(err)
+		writeError(ctx, w, err)
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := s.doRequest(req)
+
+	if err != nil {
+		logger.Println(err)
+		inverr := upstreamError(err)
+		writeError(ctx, w, inverr)
+		return nil, inverr
+	}
+
+	err = json.Unmarshal(resp, &clients)
+
+	if err != nil {
+		logger.Println(err)
+		inverr := apierror.InternalServerError()
+		writeError(ctx, w, inverr)
+		return nil, inverr
+	}
+
+	return clients, nil
+}
+
+func (s *APIClient) getClientSecret(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (clientSecret string, err error) {
+	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, controller.Config, "getClientSecret")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.ClientSecretURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID)
+	byteArr := []byte("")
+	req, err := http.NewRequestWithContext(ctx, "GET", url, bytes.NewBuffer(byteArr))
+
+	if err != nil {
+		logger.Println(err)
+		writeError(ctx, w, err)
+		return "", err
+	}
+
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := s.doRequest(req)
+
+	if err != nil {
+		logger.Println(err)
+		inverr := upstreamError(err)
+		writeError(ctx, w, inverr)
+		return "", inverr
+	}
+
+	clientSecretStruct := &ClientSecret{}
+
+	err = json.Unmarshal(resp, clientSecretStruct)
+
+	if err != nil {
+		logger.Println(err)
+		writeError(ctx, w, err)
+		return "", err
+	}
+
+	return clientSecretStruct.Value, nil
 }
 
-func (s *APIClient) createClient(
+func (s *APIClient) updateClient(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
-	client Client) (err error) {
+	client Client, clientUID string) (err error) {
 	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, controller.Config, "updateClient")
+	defer cancel()
 
-	url := fmt.Sprintf(controller.Config.ClientsURI, controller.Config.IdpURL, controller.Config.IdpRealm)
+	url := fmt.Sprintf(controller.Config.ClientURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID)
 	byteArr, err := json.Marshal(client)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(byteArr))
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewBuffer(byteArr))
 
 	if err != nil {
 		logger.Println(err)
-		http.Error(w, err.Error(), 500)
+		writeError(ctx, w, err)
 		return err
 	}
 
-	req.Header.Add("Content-Type", "application/json")
+	logger.Println(url)
+
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Add("Content-Type", "application/json")
 
 	_, err = s.doRequest(req)
 
 	if err != nil {
 		logger.Println(err)
-		errStr := fmt.Sprintf("%s", err)
-		inverr := apierror.ApiError{
-			Code:    "10000",
-			Message: errStr,
-		}
-
-		http.Error(w, inverr.Error(), 500)
-		return &inverr
+		inverr := upstreamError(err)
+		writeError(ctx, w, inverr)
+		return inverr
 	}
 
 	return nil
 }
 
-// getClientId - method for getting idp client id info
-func (s *APIClient) getClientID(
+func (s *APIClient) deleteClient(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
-	client ClientWithSecret) (clientID string, err error) {
+	clientUID string) (err error) {
 	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, controller.Config, "deleteClient")
+	defer cancel()
 
-	url := fmt.Sprintf(controller.Config.ClientsURI, controller.Config.IdpURL, controller.Config.IdpRealm)
-	byteArr, err := json.Marshal(client)
-	req, err := http.NewRequest("GET", url, bytes.NewBuffer(byteArr))
+	url := fmt.Sprintf(controller.Config.ClientURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID)
+	byteArr := []byte("")
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, bytes.NewBuffer(byteArr))
 
 	if err != nil {
 		logger.Println(err)
-		http.Error(w, err.Error(), 500)
-		return "", err
+		writeError(ctx, w, err)
+		return err
 	}
 
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 	req.Header.Add("Content-Type", "application/json")
 
-	resp, err := s.doRequest(req)
+	_, err = s.doRequest(req)
+
+	if err != nil {
+		logger.Println(err)
+		inverr := upstreamError(err)
+		writeError(ctx, w, inverr)
+		return inverr
+	}
+
+	return nil
+}
+
+// verifyClientAssertion - lets idp verify signed client assertion (private_key_jwt) by authenticating
+// client to introspection endpoint, idp checks signature against client keys, audience, expiry and jti reuse
+func (s *APIClient) verifyClientAssertion(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	clientID string,
+	assertion string) (err error) {
+	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, controller.Config, "verifyClientAssertion")
+	defer cancel()
+
+	form := url.Values{
+		"client_id":             {clientID},
+		"client_assertion_type": {clientAssertionType},
+		"client_assertion":      {assertion},
+		"token":                 {assertion},
+	}
+	url := fmt.Sprintf(controller.Config.IntrospectURI, controller.Config.IdpURL, controller.Config.IdpRealm)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(form.Encode()))
 
 	if err != nil {
 		logger.Println(err)
-		errStr := fmt.Sprintf("%s", err)
-		inverr := apierror.ApiError{
-			Code:    "10000",
-			Message: errStr,
+		writeError(ctx, w, err)
+		return err
+	}
+
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	_, err = s.doRequest(req)
+
+	if err != nil {
+		logger.Printf("Client assertion of %s rejected %s", clientID, err)
+		inverr := upstreamError(err)
+
+		// timeouts and open breaker are reported as such, rest is rejected assertion
+		if ctx.Err() == nil && apierror.StatusOf(inverr) != 503 {
+			inverr = apierror.BadClientAssertion()
 		}
 
-		http.Error(w, inverr.Error(), 500)
-		return "", &inverr
+		writeError(ctx, w, inverr)
+		return inverr
 	}
 
-	clientStruct := &ClientID{}
+	return nil
+}
 
-	addRoot := fmt.Sprintf(`{"root": %s}`, string(resp))
-	jq := gojsonq.New().FromString(addRoot)
+// getSamlDescriptor - reads saml idp metadata of realm, it is public so no token is needed
+func (s *APIClient) getSamlDescriptor(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller) (descriptor []byte, err error) {
+	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, controller.Config, "getSamlDescriptor")
+	defer cancel()
 
-	if jq.Error() != nil {
-		msg := fmt.Sprintf("Parsing response to jq failed, %s", jq.Errors())
-		log.Println(msg)
-		http.Error(w, msg, 500)
-		return "", jq.Error()
+	url := fmt.Sprintf(controller.Config.SamlDescriptorURI, controller.Config.IdpURL, controller.Config.IdpRealm)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+
+	if err != nil {
+		logger.Println(err)
+		writeError(ctx, w, err)
+		return nil, err
 	}
 
-	jq.From("root").Where("clientId", "=", client.ClientID).Only("id")
+	descriptor, err = s.doRequest(req)
 
-	logger.Printf("Client %s id is %s", client.ClientID, clientStruct.ID)
+	if err != nil {
+		logger.Println(err)
+		inverr := upstreamError(err)
+		writeError(ctx, w, inverr)
+		return nil, inverr
+	}
 
-	return clientStruct.ID, nil
+	return descriptor, nil
 }
 
-// getClient - method for getting idp client info
-func (s *APIClient) getClient(
+// adminRequest - sends request with json body to idp admin api, errors are written to caller
+func (s *APIClient) adminRequest(
+	ctx context.Context,
 	w http.ResponseWriter,
-	controller *Controller,
+	method string,
+	url string,
 	token string,
-	client Client) (clientOut *ClientOut, err error) {
+	body interface{}) ([]byte, error) {
 	logger := logging.GetLogger()
+	var reqBody io.Reader
 
-	url := fmt.Sprintf(controller.Config.ClientsURI, controller.Config.IdpURL, controller.Config.IdpRealm)
-	byteArr, err := json.Marshal(client)
-	req, err := http.NewRequest("GET", url, bytes.NewBuffer(byteArr))
+	if body != nil {
+		byteArr, err := json.Marshal(body)
+
+		if err != nil {
+			logger.Println(err)
+			writeError(ctx, w, apierror.InternalServerError())
+			return nil, err
+		}
+
+		reqBody = bytes.NewBuffer(byteArr)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 
 	if err != nil {
 		logger.Println(err)
-		http.Error(w, err.Error(), 500)
+		writeError(ctx, w, err)
 		return nil, err
 	}
 
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Add("Content-Type", "application/json")
+
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
 
 	resp, err := s.doRequest(req)
 
 	if err != nil {
 		logger.Println(err)
-		errStr := fmt.Sprintf("%s", err)
-		inverr := apierror.ApiError{
-			Code:    "10000",
-			Message: errStr,
-		}
+		inverr := upstreamError(err)
+		writeError(ctx, w, inverr)
+		return nil, inverr
+	}
+
+	return resp, nil
+}
 
-		http.Error(w, inverr.Error(), 500)
-		return nil, &inverr
+// readRoles - reads list of roles from idp response
+func readRoles(ctx context.Context, w http.ResponseWriter, resp []byte) ([]Role, error) {
+	roles := []Role{}
+
+	if err := json.Unmarshal(resp, &roles); err != nil {
+		logging.GetLogger().Println(err)
+		writeError(ctx, w, apierror.InternalServerError())
+		return nil, err
 	}
 
-	clientStruct := &ClientOut{}
+	return roles, nil
+}
 
-	addRoot := fmt.Sprintf(`{"root": %s}`, string(resp))
-	jq := gojsonq.New().FromString(addRoot)
+// clientRoleURL - url of client role, role names may contain characters reserved in path
+func clientRoleURL(config *Config, clientUID string, roleName string) string {
+	return fmt.Sprintf(config.ClientRoleURI, config.IdpURL, config.IdpRealm, clientUID, url.PathEscape(roleName))
+}
 
-	if jq.Error() != nil {
-		msg := fmt.Sprintf("Parsing response to jq failed, %s", jq.Errors())
-		log.Println(msg)
-		http.Error(w, msg, 500)
-		return nil, jq.Error()
+// listClientRoles - method for getting roles defined by client
+func (s *APIClient) listClientRoles(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (roles []Role, err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "listClientRoles")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.ClientRolesURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID)
+	resp, err := s.adminRequest(ctx, w, "GET", url, token, nil)
+
+	if err != nil {
+		return nil, err
 	}
 
-	data := jq.From("root").Where("clientId", "=", client.ClientID).First()
-	mapstructure.Decode(data, clientStruct)
+	return readRoles(ctx, w, resp)
+}
 
-	logger.Printf("Client %s id is %s", client.ClientID, clientStruct.ID)
+// createClientRole - method for creating role of client
+func (s *APIClient) createClientRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	role Role) (err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "createClientRole")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.ClientRolesURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID)
+	_, err = s.adminRequest(ctx, w, "POST", url, token, role)
+	return err
+}
 
-	return clientStruct, nil
+// updateClientRole - method for updating role of client
+func (s *APIClient) updateClientRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string,
+	role Role) (err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "updateClientRole")
+	defer cancel()
+
+	_, err = s.adminRequest(ctx, w, "PUT", clientRoleURL(controller.Config, clientUID, roleName), token, role)
+	return err
 }
 
-func (s *APIClient) getClientSecret(
+// deleteClientRole - method for deleting role of client
+func (s *APIClient) deleteClientRole(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
-	clientUID string) (clientSecret string, err error) {
-	logger := logging.GetLogger()
+	clientUID string,
+	roleName string) (err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "deleteClientRole")
+	defer cancel()
 
-	url := fmt.Sprintf(controller.Config.ClientSecretURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID)
-	byteArr := []byte("")
-	req, err := http.NewRequest("GET", url, bytes.NewBuffer(byteArr))
+	_, err = s.adminRequest(ctx, w, "DELETE", clientRoleURL(controller.Config, clientUID, roleName), token, nil)
+	return err
+}
+
+// getClientRoleComposites - method for getting roles contained in composite role of client
+func (s *APIClient) getClientRoleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string) (roles []Role, err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "getClientRoleComposites")
+	defer cancel()
+
+	url := clientRoleURL(controller.Config, clientUID, roleName) + "/composites"
+	resp, err := s.adminRequest(ctx, w, "GET", url, token, nil)
 
 	if err != nil {
-		logger.Println(err)
-		http.Error(w, err.Error(), 500)
-		return "", err
+		return nil, err
 	}
 
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	return readRoles(ctx, w, resp)
+}
 
-	resp, err := s.doRequest(req)
+// addClientRoleComposites - method for adding roles to composite role of client
+func (s *APIClient) addClientRoleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string,
+	roles []Role) (err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "addClientRoleComposites")
+	defer cancel()
+
+	url := clientRoleURL(controller.Config, clientUID, roleName) + "/composites"
+	_, err = s.adminRequest(ctx, w, "POST", url, token, roles)
+	return err
+}
+
+// removeClientRoleComposites - method for removing roles from composite role of client
+func (s *APIClient) removeClientRoleComposites(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	roleName string,
+	roles []Role) (err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "removeClientRoleComposites")
+	defer cancel()
+
+	url := clientRoleURL(controller.Config, clientUID, roleName) + "/composites"
+	_, err = s.adminRequest(ctx, w, "DELETE", url, token, roles)
+	return err
+}
+
+// getServiceAccountUser - method for getting id of service account user of client
+func (s *APIClient) getServiceAccountUser(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (userID string, err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "getServiceAccountUser")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.ServiceAccountUserURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID)
+	resp, err := s.adminRequest(ctx, w, "GET", url, token, nil)
 
 	if err != nil {
-		logger.Println(err)
-		errStr := fmt.Sprintf("%s", err)
-		inverr := apierror.ApiError{
-			Code:    "10000",
-			Message: errStr,
-		}
+		return "", err
+	}
+
+	user := &ServiceAccountUser{}
 
-		http.Error(w, inverr.Error(), 500)
-		return "", &inverr
+	if err := json.Unmarshal(resp, user); err != nil {
+		logging.GetLogger().Println(err)
+		writeError(ctx, w, apierror.InternalServerError())
+		return "", err
 	}
 
-	clientSecretStruct := &ClientSecret{}
+	return user.ID, nil
+}
 
-	err = json.Unmarshal(resp, clientSecretStruct)
+// getRealmRole - method for getting realm role by name
+func (s *APIClient) getRealmRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	roleName string) (role *Role, err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "getRealmRole")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.RealmRoleURI, controller.Config.IdpURL, controller.Config.IdpRealm, url.PathEscape(roleName))
+	resp, err := s.adminRequest(ctx, w, "GET", url, token, nil)
 
 	if err != nil {
-		logger.Println(err)
-		http.Error(w, err.Error(), 500)
-		return "", err
+		return nil, err
 	}
 
-	return clientSecretStruct.Value, nil
+	role = &Role{}
+
+	if err := json.Unmarshal(resp, role); err != nil {
+		logging.GetLogger().Println(err)
+		writeError(ctx, w, apierror.InternalServerError())
+		return nil, err
+	}
+
+	return role, nil
 }
 
-func (s *APIClient) updateClient(
+// getRoleMappings - method for getting realm and client roles mapped to user
+func (s *APIClient) getRoleMappings(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
-	client Client, clientUID string) (err error) {
-	logger := logging.GetLogger()
+	userID string) (mappings *RoleMappings, err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "getRoleMappings")
+	defer cancel()
 
-	url := fmt.Sprintf(controller.Config.ClientURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID)
-	byteArr, err := json.Marshal(client)
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(byteArr))
+	url := fmt.Sprintf(controller.Config.RoleMappingsURI, controller.Config.IdpURL, controller.Config.IdpRealm, userID)
+	resp, err := s.adminRequest(ctx, w, "GET", url, token, nil)
 
 	if err != nil {
-		logger.Println(err)
-		http.Error(w, err.Error(), 500)
-		return err
+		return nil, err
 	}
 
-	logger.Println(url)
+	mappings = &RoleMappings{}
 
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Add("Content-Type", "application/json")
+	if err := json.Unmarshal(resp, mappings); err != nil {
+		logging.GetLogger().Println(err)
+		writeError(ctx, w, apierror.InternalServerError())
+		return nil, err
+	}
 
-	_, err = s.doRequest(req)
+	return mappings, nil
+}
 
-	if err != nil {
-		logger.Println(err)
-		errStr := fmt.Sprintf("%s", err)
-		inverr := apierror.ApiError{
-			Code:    "10000",
-			Message: errStr,
-		}
+// roleMappingsURL - url of realm role mappings of user, or client role mappings when clientUID is set
+func roleMappingsURL(config *Config, userID string, clientUID string) string {
+	base := fmt.Sprintf(config.RoleMappingsURI, config.IdpURL, config.IdpRealm, userID)
 
-		http.Error(w, inverr.Error(), 500)
-		return &inverr
+	if clientUID == "" {
+		return base + "/realm"
 	}
 
-	return nil
+	return base + "/clients/" + clientUID
 }
 
-func (s *APIClient) deleteClient(
+// addRoleMappings - method for mapping realm roles, or client roles when clientUID is set, to user
+func (s *APIClient) addRoleMappings(
+	ctx context.Context,
 	w http.ResponseWriter,
 	controller *Controller,
 	token string,
-	clientUID string) (err error) {
-	logger := logging.GetLogger()
+	userID string,
+	clientUID string,
+	roles []Role) (err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "addRoleMappings")
+	defer cancel()
+
+	_, err = s.adminRequest(ctx, w, "POST", roleMappingsURL(controller.Config, userID, clientUID), token, roles)
+	return err
+}
 
-	url := fmt.Sprintf(controller.Config.ClientURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID)
-	byteArr := []byte("")
-	req, err := http.NewRequest("DELETE", url, bytes.NewBuffer(byteArr))
+// removeRoleMappings - method for removing realm roles, or client roles when clientUID is set, from user
+func (s *APIClient) removeRoleMappings(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	userID string,
+	clientUID string,
+	roles []Role) (err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "removeRoleMappings")
+	defer cancel()
+
+	_, err = s.adminRequest(ctx, w, "DELETE", roleMappingsURL(controller.Config, userID, clientUID), token, roles)
+	return err
+}
+
+// listClientScopes - method for getting client scopes of realm
+func (s *APIClient) listClientScopes(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string) (scopes []ClientScope, err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "listClientScopes")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.ClientScopesURI, controller.Config.IdpURL, controller.Config.IdpRealm)
+	resp, err := s.adminRequest(ctx, w, "GET", url, token, nil)
 
 	if err != nil {
-		logger.Println(err)
-		http.Error(w, err.Error(), 500)
-		return err
+		return nil, err
 	}
 
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Add("Content-Type", "application/json")
+	return readClientScopes(ctx, w, resp)
+}
 
-	_, err = s.doRequest(req)
+// listAssignedClientScopes - method for getting default or optional client scopes assigned to client
+func (s *APIClient) listAssignedClientScopes(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	kind string) (scopes []ClientScope, err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "listAssignedClientScopes")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.ClientScopeMappingsURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID, kind)
+	resp, err := s.adminRequest(ctx, w, "GET", url, token, nil)
 
 	if err != nil {
-		logger.Println(err)
-		errStr := fmt.Sprintf("%s", err)
-		inverr := apierror.ApiError{
-			Code:    "10000",
-			Message: errStr,
-		}
+		return nil, err
+	}
+
+	return readClientScopes(ctx, w, resp)
+}
+
+// addClientScope - method for adding default or optional client scope to client
+func (s *APIClient) addClientScope(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	kind string,
+	scopeID string) (err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "addClientScope")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.ClientScopeMappingsURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID, kind)
+	_, err = s.adminRequest(ctx, w, "PUT", url+"/"+scopeID, token, nil)
+	return err
+}
+
+// removeClientScope - method for removing default or optional client scope from client
+func (s *APIClient) removeClientScope(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	kind string,
+	scopeID string) (err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "removeClientScope")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.ClientScopeMappingsURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID, kind)
+	_, err = s.adminRequest(ctx, w, "DELETE", url+"/"+scopeID, token, nil)
+	return err
+}
 
-		http.Error(w, inverr.Error(), 500)
-		return &inverr
+// readClientScopes - reads list of client scopes from idp response
+func readClientScopes(ctx context.Context, w http.ResponseWriter, resp []byte) ([]ClientScope, error) {
+	scopes := []ClientScope{}
+
+	if err := json.Unmarshal(resp, &scopes); err != nil {
+		logging.GetLogger().Println(err)
+		writeError(ctx, w, apierror.InternalServerError())
+		return nil, err
 	}
 
-	return nil
+	return scopes, nil
+}
+
+// createProtocolMapper - method for creating protocol mapper of client
+func (s *APIClient) createProtocolMapper(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	mapper ProtocolMapper) (err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "createProtocolMapper")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.ProtocolMappersURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID)
+	_, err = s.adminRequest(ctx, w, "POST", url, token, mapper)
+	return err
+}
+
+// updateProtocolMapper - method for updating protocol mapper of client, mapper is identified by its id
+func (s *APIClient) updateProtocolMapper(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	mapper ProtocolMapper) (err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "updateProtocolMapper")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.ProtocolMappersURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID)
+	_, err = s.adminRequest(ctx, w, "PUT", url+"/"+mapper.ID, token, mapper)
+	return err
+}
+
+// deleteProtocolMapper - method for deleting protocol mapper of client
+func (s *APIClient) deleteProtocolMapper(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	mapperID string) (err error) {
+	ctx, cancel := operationContext(ctx, controller.Config, "deleteProtocolMapper")
+	defer cancel()
+
+	url := fmt.Sprintf(controller.Config.ProtocolMappersURI, controller.Config.IdpURL, controller.Config.IdpRealm, clientUID)
+	_, err = s.adminRequest(ctx, w, "DELETE", url+"/"+mapperID, token, nil)
+	return err
 }
 
 func (s *APIClient) createUser(
+	ctx context.Context,
 	config *Config,
 	token string,
 	user *User) (err error) {
 	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, config, "createUser")
+	defer cancel()
 
 	url := fmt.Sprintf(config.UsersURI, config.IdpURL, config.IdpRealm)
 	byteArr, err := json.Marshal(user)
@@ -643,7 +1927,7 @@ func (s *APIClient) createUser(
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(byteArr))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(byteArr))
 
 	if err != nil {
 		logger.Println(err)
@@ -659,13 +1943,8 @@ func (s *APIClient) createUser(
 
 	if err != nil {
 		logger.Println(err)
-		errStr := fmt.Sprintf("%s", err)
-		inverr := apierror.ApiError{
-			Code:    "10000",
-			Message: errStr,
-		}
-
-		return &inverr
+		inverr := upstreamError(err)
+		return inverr
 	}
 
 	return nil
@@ -673,14 +1952,17 @@ func (s *APIClient) createUser(
 
 // getUserID - method for getting idp user id (really it has uid form)
 func (s *APIClient) getUserID(
+	ctx context.Context,
 	config *Config,
 	token string,
 	user *User) (userID string, err error) {
 	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, config, "getUserID")
+	defer cancel()
 
 	url := fmt.Sprintf(config.UsersURI, config.IdpURL, config.IdpRealm)
 	byteArr, err := json.Marshal(user)
-	req, err := http.NewRequest("GET", url, bytes.NewBuffer(byteArr))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, bytes.NewBuffer(byteArr))
 
 	if err != nil {
 		logger.Println(err)
@@ -694,13 +1976,8 @@ func (s *APIClient) getUserID(
 
 	if err != nil {
 		logger.Println(err)
-		errStr := fmt.Sprintf("%s", err)
-		inverr := apierror.ApiError{
-			Code:    "10000",
-			Message: errStr,
-		}
-
-		return "", &inverr
+		inverr := upstreamError(err)
+		return "", inverr
 	}
 
 	userIDStruct := &UserID{}
@@ -754,14 +2031,17 @@ func (s *APIClient) getUserID(
 }
 
 func (s *APIClient) deleteUser(
+	ctx context.Context,
 	config *Config,
 	token string,
 	userUID string) (err error) {
 	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, config, "deleteUser")
+	defer cancel()
 
 	url := fmt.Sprintf(config.UserURI, config.IdpURL, config.IdpRealm, userUID)
 	byteArr := []byte("")
-	req, err := http.NewRequest("DELETE", url, bytes.NewBuffer(byteArr))
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, bytes.NewBuffer(byteArr))
 
 	if err != nil {
 		logger.Println(err)
@@ -775,28 +2055,26 @@ func (s *APIClient) deleteUser(
 
 	if err != nil {
 		logger.Println(err)
-		errStr := fmt.Sprintf("%s", err)
-		inverr := apierror.ApiError{
-			Code:    "10000",
-			Message: errStr,
-		}
-
-		return &inverr
+		inverr := upstreamError(err)
+		return inverr
 	}
 
 	return nil
 }
 
 func (s *APIClient) setUserPassword(
+	ctx context.Context,
 	config *Config,
 	token string,
 	userCredential *UserSecret,
 	userUID string) (err error) {
 	logger := logging.GetLogger()
+	ctx, cancel := operationContext(ctx, config, "setUserPassword")
+	defer cancel()
 
 	url := fmt.Sprintf(config.UserPasswordURI, config.IdpURL, config.IdpRealm, userUID)
 	byteArr, err := json.Marshal(userCredential)
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(byteArr))
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewBuffer(byteArr))
 
 	if err != nil {
 		logger.Println(err)
@@ -810,13 +2088,8 @@ func (s *APIClient) setUserPassword(
 
 	if err != nil {
 		logger.Println(err)
-		errStr := fmt.Sprintf("%s", err)
-		inverr := apierror.ApiError{
-			Code:    "10000",
-			Message: errStr,
-		}
-
-		return &inverr
+		inverr := upstreamError(err)
+		return inverr
 	}
 
 	return nil
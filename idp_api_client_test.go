@@ -2,12 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/p53/idp-api/apierror"
 	"gotest.tools/assert"
@@ -21,6 +24,14 @@ func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
 	return f(req), nil
 }
 
+// RoundTripErrFunc .
+type RoundTripErrFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip .
+func (f RoundTripErrFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 //NewTestClient returns *http.Client with Transport replaced to avoid making real calls
 func NewTestClient(fn RoundTripFunc) *http.Client {
 	return &http.Client{
@@ -30,7 +41,7 @@ func NewTestClient(fn RoundTripFunc) *http.Client {
 
 func TestFailHttpClientDoRequest(t *testing.T) {
 	baseClient := &http.Client{}
-	apiClient := &APIClient{baseClient}
+	apiClient := &APIClient{BaseClient: baseClient}
 	byteArr := []byte("")
 	testConfig := getUnitTestConfig()
 	req, _ := http.NewRequest("POST", testConfig.IdpURL, bytes.NewBuffer(byteArr))
@@ -175,8 +186,16 @@ func TestInvalidRequestAuthenticate(t *testing.T) {
 	controller := &Controller{Config: testConfig}
 	rr := httptest.NewRecorder()
 
+	testClient := NewTestClient(func(req *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: 401,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`{"error": "invalid_grant", "error_description": "Invalid user credentials"}`)),
+			Header:     make(http.Header),
+		}
+	})
+
 	authAdminFunc := getAuthBodyFromBasicAuth
-	apiClient := &APIClient{BaseClient: &http.Client{}}
+	apiClient := &APIClient{BaseClient: testClient}
 	_, _, err := apiClient.authenticate(rr, req, controller, authAdminFunc)
 
 	if err == nil {
@@ -279,7 +298,7 @@ func TestFailureCreateClient(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	err := apiClient.createClient(rr, controller, "test_token", Client{})
+	err := apiClient.createClient(context.Background(), rr, controller, "test_token", Client{})
 
 	if _, ok := err.(*apierror.ApiError); !ok {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -309,7 +328,7 @@ func TestSuccessCreateClient(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	err := apiClient.createClient(rr, controller, "test_token", Client{})
+	err := apiClient.createClient(context.Background(), rr, controller, "test_token", Client{})
 
 	if err != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", err)
@@ -325,7 +344,7 @@ func TestFailureGetClientId(t *testing.T) {
 	controller := &Controller{Config: testConfig}
 	rr := httptest.NewRecorder()
 
-	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm)
+	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm) + "?clientId="
 	testClient := NewTestClient(func(req *http.Request) *http.Response {
 		// Test request parameters
 		assert.Equal(t, req.URL.String(), testClientURL)
@@ -339,7 +358,7 @@ func TestFailureGetClientId(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	_, err := apiClient.getClientID(rr, controller, "test_token", ClientWithSecret{})
+	_, err := apiClient.getClientID(context.Background(), rr, controller, "test_token", ClientWithSecret{})
 
 	if _, ok := err.(*apierror.ApiError); !ok {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -355,7 +374,7 @@ func TestJqFailureGetClientId(t *testing.T) {
 	controller := &Controller{Config: testConfig}
 	rr := httptest.NewRecorder()
 
-	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm)
+	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm) + "?clientId="
 	testClient := NewTestClient(func(req *http.Request) *http.Response {
 		// Test request parameters
 		assert.Equal(t, req.URL.String(), testClientURL)
@@ -369,7 +388,7 @@ func TestJqFailureGetClientId(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	_, err := apiClient.getClientID(rr, controller, "test_token", ClientWithSecret{})
+	_, err := apiClient.getClientID(context.Background(), rr, controller, "test_token", ClientWithSecret{})
 
 	if err == nil {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -385,7 +404,7 @@ func TestJqSuccessGetClientId(t *testing.T) {
 	controller := &Controller{Config: testConfig}
 	rr := httptest.NewRecorder()
 
-	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm)
+	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm) + "?clientId=test"
 	testClient := NewTestClient(func(req *http.Request) *http.Response {
 		// Test request parameters
 		assert.Equal(t, req.URL.String(), testClientURL)
@@ -400,10 +419,10 @@ func TestJqSuccessGetClientId(t *testing.T) {
 
 	apiClient := &APIClient{BaseClient: testClient}
 	inputClient := ClientWithSecret{
-		ClientID: "test",
+		Client: Client{ClientID: "test"},
 	}
 
-	_, err := apiClient.getClientID(rr, controller, "test_token", inputClient)
+	_, err := apiClient.getClientID(context.Background(), rr, controller, "test_token", inputClient)
 
 	if err != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", err)
@@ -419,7 +438,7 @@ func TestFailureGetClient(t *testing.T) {
 	controller := &Controller{Config: testConfig}
 	rr := httptest.NewRecorder()
 
-	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm)
+	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm) + "?clientId="
 	testClient := NewTestClient(func(req *http.Request) *http.Response {
 		// Test request parameters
 		assert.Equal(t, req.URL.String(), testClientURL)
@@ -433,7 +452,7 @@ func TestFailureGetClient(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	_, err := apiClient.getClient(rr, controller, "test_token", Client{})
+	_, err := apiClient.getClient(context.Background(), rr, controller, "test_token", Client{})
 
 	if _, ok := err.(*apierror.ApiError); !ok {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -445,7 +464,7 @@ func TestJqFailureGetClient(t *testing.T) {
 	controller := &Controller{Config: testConfig}
 	rr := httptest.NewRecorder()
 
-	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm)
+	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm) + "?clientId="
 	testClient := NewTestClient(func(req *http.Request) *http.Response {
 		// Test request parameters
 		assert.Equal(t, req.URL.String(), testClientURL)
@@ -459,7 +478,7 @@ func TestJqFailureGetClient(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	_, err := apiClient.getClient(rr, controller, "test_token", Client{})
+	_, err := apiClient.getClient(context.Background(), rr, controller, "test_token", Client{})
 
 	if err == nil {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -471,7 +490,7 @@ func TestJqSuccessGetClient(t *testing.T) {
 	controller := &Controller{Config: testConfig}
 	rr := httptest.NewRecorder()
 
-	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm)
+	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm) + "?clientId=test"
 	testClient := NewTestClient(func(req *http.Request) *http.Response {
 		// Test request parameters
 		assert.Equal(t, req.URL.String(), testClientURL)
@@ -486,11 +505,46 @@ func TestJqSuccessGetClient(t *testing.T) {
 
 	apiClient := &APIClient{BaseClient: testClient}
 
-	_, err := apiClient.getClient(rr, controller, "test_token", Client{})
+	clientOut, err := apiClient.getClient(context.Background(), rr, controller, "test_token", Client{ClientID: "test"})
 
 	if err != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", err)
 	}
+
+	if clientOut.ID != "40b5444c-5990-496d-bb67-64c535df8dc4" {
+		t.Fatalf("Bad client id %s", clientOut.ID)
+	}
+}
+
+func TestNotFoundGetClient(t *testing.T) {
+	testConfig := getUnitTestConfig()
+	controller := &Controller{Config: testConfig}
+	rr := httptest.NewRecorder()
+
+	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm) + "?clientId=missing"
+	testClient := NewTestClient(func(req *http.Request) *http.Response {
+		// Test request parameters
+		assert.Equal(t, req.URL.String(), testClientURL)
+		return &http.Response{
+			StatusCode: 200,
+			// Send response to be tested
+			Body: ioutil.NopCloser(bytes.NewBufferString(testClientsData)),
+			// Must be set to non-nil value or it panics
+			Header: make(http.Header),
+		}
+	})
+
+	apiClient := &APIClient{BaseClient: testClient}
+
+	_, err := apiClient.getClient(context.Background(), rr, controller, "test_token", Client{ClientID: "missing"})
+
+	if apiErr, ok := err.(*apierror.ApiError); !ok || apiErr.Code != "1019" {
+		t.Fatalf("Method doesn't fail with client not found! %s", err)
+	}
+
+	if rr.Result().StatusCode != 404 {
+		t.Fatalf("Bad return code %d", rr.Result().StatusCode)
+	}
 }
 
 func TestFailureGetClientSecret(t *testing.T) {
@@ -513,7 +567,7 @@ func TestFailureGetClientSecret(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	_, err := apiClient.getClientSecret(rr, controller, "test_token", clientUID)
+	_, err := apiClient.getClientSecret(context.Background(), rr, controller, "test_token", clientUID)
 
 	if _, ok := err.(*apierror.ApiError); !ok {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -544,7 +598,7 @@ func TestSuccessGetClientSecret(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	secret, err := apiClient.getClientSecret(rr, controller, "test_token", clientUID)
+	secret, err := apiClient.getClientSecret(context.Background(), rr, controller, "test_token", clientUID)
 
 	if err != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", err)
@@ -579,7 +633,7 @@ func TestFailureUpdateClient(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	err := apiClient.updateClient(rr, controller, "test_token", Client{}, clientUID)
+	err := apiClient.updateClient(context.Background(), rr, controller, "test_token", Client{}, clientUID)
 
 	if _, ok := err.(*apierror.ApiError); !ok {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -610,7 +664,7 @@ func TestSuccessUpdateClient(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	err := apiClient.updateClient(rr, controller, "test_token", Client{}, clientUID)
+	err := apiClient.updateClient(context.Background(), rr, controller, "test_token", Client{}, clientUID)
 
 	if err != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", err)
@@ -641,7 +695,7 @@ func TestFailureDeleteClient(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	err := apiClient.deleteClient(rr, controller, "test_token", clientUID)
+	err := apiClient.deleteClient(context.Background(), rr, controller, "test_token", clientUID)
 
 	if _, ok := err.(*apierror.ApiError); !ok {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -672,7 +726,7 @@ func TestSuccessDeleteClient(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	err := apiClient.deleteClient(rr, controller, "test_token", clientUID)
+	err := apiClient.deleteClient(context.Background(), rr, controller, "test_token", clientUID)
 
 	if err != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", err)
@@ -700,7 +754,7 @@ func TestFailureCreateUser(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	err := apiClient.createUser(testConfig, "test_token", &User{})
+	err := apiClient.createUser(context.Background(), testConfig, "test_token", &User{})
 
 	if _, ok := err.(*apierror.ApiError); !ok {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -724,7 +778,7 @@ func TestSuccessCreateUser(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	err := apiClient.createUser(testConfig, "test_token", &User{})
+	err := apiClient.createUser(context.Background(), testConfig, "test_token", &User{})
 
 	if err != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", err)
@@ -748,7 +802,7 @@ func TestFailureGetUserId(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	_, err := apiClient.getUserID(testConfig, "test_token", &User{})
+	_, err := apiClient.getUserID(context.Background(), testConfig, "test_token", &User{})
 
 	if _, ok := err.(*apierror.ApiError); !ok {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -772,7 +826,7 @@ func TestJqFailureGetUserId(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	_, err := apiClient.getUserID(testConfig, "test_token", &User{})
+	_, err := apiClient.getUserID(context.Background(), testConfig, "test_token", &User{})
 
 	if err == nil {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -800,7 +854,7 @@ func TestJqSuccessGetUserId(t *testing.T) {
 		Username: "test",
 	}
 
-	_, err := apiClient.getUserID(testConfig, "test_token", &inputUser)
+	_, err := apiClient.getUserID(context.Background(), testConfig, "test_token", &inputUser)
 
 	if err != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", err)
@@ -825,7 +879,7 @@ func TestFailureDeleteUser(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	err := apiClient.deleteUser(testConfig, "test_token", userUID)
+	err := apiClient.deleteUser(context.Background(), testConfig, "test_token", userUID)
 
 	if _, ok := err.(*apierror.ApiError); !ok {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -851,7 +905,7 @@ func TestSuccessDeleteUser(t *testing.T) {
 	})
 
 	apiClient := &APIClient{BaseClient: testClient}
-	err := apiClient.deleteUser(testConfig, "test_token", userUID)
+	err := apiClient.deleteUser(context.Background(), testConfig, "test_token", userUID)
 
 	if err != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", err)
@@ -881,7 +935,7 @@ func TestFailureSetUserPassword(t *testing.T) {
 
 	apiClient := &APIClient{BaseClient: testClient}
 	credential := &UserSecret{Type: "password", Value: "test"}
-	err := apiClient.setUserPassword(testConfig, "test_token", credential, userUID)
+	err := apiClient.setUserPassword(context.Background(), testConfig, "test_token", credential, userUID)
 
 	if _, ok := err.(*apierror.ApiError); !ok {
 		t.Fatalf("Method doesn't fail when it should! %s", err)
@@ -906,9 +960,364 @@ func TestSuccessSetUserPassword(t *testing.T) {
 
 	apiClient := &APIClient{BaseClient: testClient}
 	credential := &UserSecret{Type: "password", Value: "test"}
-	err := apiClient.setUserPassword(testConfig, "test_token", credential, userUID)
+	err := apiClient.setUserPassword(context.Background(), testConfig, "test_token", credential, userUID)
 
 	if err != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", err)
 	}
 }
+
+func TestTimeoutGetClientSecret(t *testing.T) {
+	testConfig := getUnitTestConfig()
+	testConfig.OperationTimeouts = map[string]time.Duration{"getClientSecret": 10 * time.Millisecond}
+	controller := &Controller{Config: testConfig}
+	rr := httptest.NewRecorder()
+
+	clientUID := "40b5444c-5990-496d-bb67-64c535df8dc4"
+	testClient := &http.Client{
+		Transport: RoundTripErrFunc(func(req *http.Request) (*http.Response, error) {
+			// Simulate hung idp, request is released only by context
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}),
+	}
+
+	apiClient := &APIClient{BaseClient: testClient}
+	_, err := apiClient.getClientSecret(context.Background(), rr, controller, "test_token", clientUID)
+
+	if err == nil {
+		t.Fatalf("Method doesn't fail when it should! %s", err)
+	}
+
+	if rr.Result().StatusCode != 504 {
+		t.Fatalf("Bad return code %d", rr.Result().StatusCode)
+	}
+}
+
+func TestCancelledContextCreateClient(t *testing.T) {
+	testConfig := getUnitTestConfig()
+	controller := &Controller{Config: testConfig}
+	rr := httptest.NewRecorder()
+
+	testClient := &http.Client{
+		Transport: RoundTripErrFunc(func(req *http.Request) (*http.Response, error) {
+			assert.Assert(t, req.Context().Err() != nil)
+			return nil, req.Context().Err()
+		}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	apiClient := &APIClient{BaseClient: testClient}
+	err := apiClient.createClient(ctx, rr, controller, "test_token", Client{})
+
+	if err == nil {
+		t.Fatalf("Method doesn't fail when it should! %s", err)
+	}
+}
+
+func TestOperationContext(t *testing.T) {
+	testConfig := getUnitTestConfig()
+	testConfig.RequestTimeout = time.Minute
+	testConfig.OperationTimeouts = map[string]time.Duration{"createClient": time.Second}
+
+	ctx, cancel := operationContext(context.Background(), testConfig, "createClient")
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+
+	if !ok || time.Until(deadline) > time.Second {
+		t.Fatalf("Operation timeout not applied %s", deadline)
+	}
+
+	ctxDef, cancelDef := operationContext(context.Background(), testConfig, "getClient")
+	defer cancelDef()
+	deadlineDef, ok := ctxDef.Deadline()
+
+	if !ok || time.Until(deadlineDef) < 59*time.Second {
+		t.Fatalf("Default timeout not applied %s", deadlineDef)
+	}
+}
+
+func TestRetryServiceUnavailableDoRequest(t *testing.T) {
+	calls := 0
+	req, _ := http.NewRequest("GET", "/test", bytes.NewBuffer([]byte("")))
+
+	testClient := NewTestClient(func(req *http.Request) *http.Response {
+		calls++
+		status := 503
+
+		if calls == 3 {
+			status = 200
+		}
+
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`OK`)),
+			Header:     make(http.Header),
+		}
+	})
+
+	apiClient := &APIClient{BaseClient: testClient, Retry: RetryPolicy{MaxRetries: 2}}
+	_, err := apiClient.doRequest(req)
+
+	if err != nil {
+		t.Fatalf("Method should return success, error is: %s!", err)
+	}
+
+	if calls != 3 {
+		t.Fatalf("Request should be attempted 3 times, was %d", calls)
+	}
+
+	if stats := apiClient.upstreamStats(); stats.Retries != 2 || stats.Failures != 2 {
+		t.Fatalf("Bad stats %+v", stats)
+	}
+}
+
+func TestNoRetryNonIdempotentDoRequest(t *testing.T) {
+	calls := 0
+	req, _ := http.NewRequest("POST", "/test", bytes.NewBuffer([]byte("{}")))
+
+	testClient := NewTestClient(func(req *http.Request) *http.Response {
+		calls++
+		return &http.Response{
+			StatusCode: 503,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`FAIL`)),
+			Header:     make(http.Header),
+		}
+	})
+
+	apiClient := &APIClient{BaseClient: testClient, Retry: RetryPolicy{MaxRetries: 2}}
+	_, err := apiClient.doRequest(req)
+
+	if err == nil {
+		t.Fatalf("Method doesn't fail when it should! %s", err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("POST should not be retried on 503, attempts %d", calls)
+	}
+}
+
+func TestRetryConnectionRefusedDoRequest(t *testing.T) {
+	calls := 0
+	req, _ := http.NewRequest("POST", "/test", bytes.NewBuffer([]byte("{}")))
+
+	testClient := &http.Client{
+		Transport: RoundTripErrFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+
+			if calls == 1 {
+				return nil, syscall.ECONNREFUSED
+			}
+
+			body, _ := ioutil.ReadAll(req.Body)
+			assert.Equal(t, string(body), "{}")
+
+			return &http.Response{
+				StatusCode: 201,
+				Body:       ioutil.NopCloser(bytes.NewBufferString("")),
+				Header:     make(http.Header),
+			}, nil
+		}),
+	}
+
+	apiClient := &APIClient{BaseClient: testClient, Retry: RetryPolicy{MaxRetries: 1}}
+	_, err := apiClient.doRequest(req)
+
+	if err != nil {
+		t.Fatalf("Method should return success, error is: %s!", err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("Request should be attempted 2 times, was %d", calls)
+	}
+}
+
+func TestCircuitBreakerOpenGetClientSecret(t *testing.T) {
+	testConfig := getUnitTestConfig()
+	controller := &Controller{Config: testConfig}
+	calls := 0
+
+	clientUID := "40b5444c-5990-496d-bb67-64c535df8dc4"
+	testClient := NewTestClient(func(req *http.Request) *http.Response {
+		calls++
+		return &http.Response{
+			StatusCode: 502,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`FAIL`)),
+			Header:     make(http.Header),
+		}
+	})
+
+	apiClient := &APIClient{BaseClient: testClient, Breaker: NewCircuitBreaker(1, time.Minute)}
+	_, err := apiClient.getClientSecret(context.Background(), httptest.NewRecorder(), controller, "test_token", clientUID)
+
+	if err == nil {
+		t.Fatalf("Method doesn't fail when it should! %s", err)
+	}
+
+	rr := httptest.NewRecorder()
+	_, err = apiClient.getClientSecret(context.Background(), rr, controller, "test_token", clientUID)
+
+	if apiErr, ok := err.(*apierror.ApiError); !ok || apiErr.Code != "1013" {
+		t.Fatalf("Method should fail fast with open breaker! %s", err)
+	}
+
+	if rr.Result().StatusCode != 503 {
+		t.Fatalf("Bad return code %d", rr.Result().StatusCode)
+	}
+
+	if calls != 1 {
+		t.Fatalf("Open breaker should not call idp, calls %d", calls)
+	}
+
+	if stats := apiClient.upstreamStats(); stats.BreakerState != breakerOpen || stats.Rejections != 1 {
+		t.Fatalf("Bad stats %+v", stats)
+	}
+}
+
+func TestUpstreamErrorDoRequest(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/test", bytes.NewBuffer([]byte("")))
+
+	testClient := NewTestClient(func(req *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: 404,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`{"error":"Could not find client"}`)),
+			Header:     make(http.Header),
+		}
+	})
+
+	apiClient := &APIClient{BaseClient: testClient}
+	_, err := apiClient.doRequest(req)
+
+	upErr, ok := err.(*UpstreamError)
+
+	if !ok {
+		t.Fatalf("Method should return upstream error! %s", err)
+	}
+
+	assert.Equal(t, upErr.StatusCode, 404)
+	assert.Equal(t, upErr.Message, "Could not find client")
+}
+
+func TestConflictCreateClient(t *testing.T) {
+	testConfig := getUnitTestConfig()
+	controller := &Controller{Config: testConfig}
+	rr := httptest.NewRecorder()
+
+	testClient := NewTestClient(func(req *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: 409,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`{"errorMessage":"Client test already exists"}`)),
+			Header:     make(http.Header),
+		}
+	})
+
+	apiClient := &APIClient{BaseClient: testClient}
+	err := apiClient.createClient(context.Background(), rr, controller, "test_token", Client{ClientID: "test"})
+
+	apiErr, ok := err.(*apierror.ApiError)
+
+	if !ok || apiErr.Code != "1017" {
+		t.Fatalf("Method should fail with conflict! %s", err)
+	}
+
+	if rr.Result().StatusCode != 409 {
+		t.Fatalf("Bad return code %d", rr.Result().StatusCode)
+	}
+
+	if apiErr.Detail != "" {
+		t.Fatalf("Keycloak message exposed in %s", apiErr.Detail)
+	}
+
+	if rr.Result().Header.Get("Content-Type") != apierror.ProblemContentType {
+		t.Fatalf("Bad content type %s", rr.Result().Header.Get("Content-Type"))
+	}
+}
+
+func TestUpstreamStatusMapping(t *testing.T) {
+	cases := map[int]string{
+		400: "1014",
+		403: "1015",
+		404: "1016",
+		409: "1017",
+		500: "10000",
+	}
+
+	for upstreamStatus, code := range cases {
+		err := upstreamError(&UpstreamError{StatusCode: upstreamStatus, Message: "test"})
+		apiErr := err.(*apierror.ApiError)
+		status := apierror.StatusOf(err)
+
+		if apiErr.Code != code {
+			t.Fatalf("Bad code %s for idp status %d", apiErr.Code, upstreamStatus)
+		}
+
+		if upstreamStatus < 500 && status != upstreamStatus {
+			t.Fatalf("Bad status %d for idp status %d", status, upstreamStatus)
+		}
+	}
+}
+
+func TestSuccessListClients(t *testing.T) {
+	testConfig := getUnitTestConfig()
+	controller := &Controller{Config: testConfig}
+	rr := httptest.NewRecorder()
+
+	testClientURL := fmt.Sprintf(testConfig.ClientsURI, testConfig.IdpURL, testConfig.IdpRealm) + "?first=10&max=2"
+	testClient := NewTestClient(func(req *http.Request) *http.Response {
+		// Test request parameters
+		assert.Equal(t, req.URL.String(), testClientURL)
+		return &http.Response{
+			StatusCode: 200,
+			// Send response to be tested
+			Body: ioutil.NopCloser(bytes.NewBufferString(testClientsData)),
+			// Must be set to non-nil value or it panics
+			Header: make(http.Header),
+		}
+	})
+
+	apiClient := &APIClient{BaseClient: testClient}
+	clients, err := apiClient.listClients(context.Background(), rr, controller, "test_token", 10, 2)
+
+	if err != nil {
+		t.Fatalf("Method fail when it shouldn't! %s", err)
+	}
+
+	if len(clients) != 2 || clients[1].ClientID != "test" {
+		t.Fatalf("Bad clients %+v", clients)
+	}
+}
+
+func TestPagesClientIterator(t *testing.T) {
+	testConfig := getUnitTestConfig()
+	testConfig.ClientsPageSize = 2
+	controller := &Controller{Config: testConfig}
+	pages := map[string]string{
+		"0": `[{"id": "1", "clientId": "a"}, {"id": "2", "clientId": "b"}]`,
+		"2": `[{"id": "3", "clientId": "c"}]`,
+	}
+
+	testClient := NewTestClient(func(req *http.Request) *http.Response {
+		assert.Equal(t, req.URL.Query().Get("max"), "2")
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(pages[req.URL.Query().Get("first")])),
+			Header:     make(http.Header),
+		}
+	})
+
+	testConfig.HTTPClient = &APIClient{BaseClient: testClient}
+	var ids []string
+
+	err := forEachClient(context.Background(), httptest.NewRecorder(), controller, "test_token", func(client ClientOut) bool {
+		ids = append(ids, client.ID)
+		return true
+	})
+
+	if err != nil {
+		t.Fatalf("Method fail when it shouldn't! %s", err)
+	}
+
+	assert.DeepEqual(t, ids, []string{"1", "2", "3"})
+}
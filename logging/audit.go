@@ -0,0 +1,12 @@
+package logging
+
+import (
+	"log"
+	"os"
+)
+
+// GetAuditLogger - logger for audit trail, one json record per line
+func GetAuditLogger() *log.Logger {
+	logger := log.New(os.Stdout, "audit: ", 0)
+	return logger
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -32,7 +33,7 @@ func TestMain(m *testing.M) {
 func getClientSecret(clientJson string) (clientSecret string) {
 	logger := logging.GetLogger()
 
-	apiClient := &APIClient{&http.Client{}}
+	apiClient := &APIClient{BaseClient: &http.Client{}}
 	testConfig := getFuncTestConfig()
 	testConfig.HTTPClient = apiClient
 	controller := &Controller{Config: testConfig}
@@ -54,13 +55,13 @@ func getClientSecret(clientJson string) (clientSecret string) {
 		logger.Fatalf("Problem unmarshalling %s", errNc)
 	}
 
-	clientInfo, errGet := apiClient.getClient(rrSec, controller, token, *testNewClientStruct)
+	clientInfo, errGet := apiClient.getClient(context.Background(), rrSec, controller, token, *testNewClientStruct)
 
 	if errGet != nil {
 		logger.Fatalf("Method fail when it shouldn't! %s", errGet)
 	}
 
-	clientSecret, errSec := apiClient.getClientSecret(rrSec, controller, token, clientInfo.ID)
+	clientSecret, errSec := apiClient.getClientSecret(context.Background(), rrSec, controller, token, clientInfo.ID)
 
 	if errSec != nil {
 		logger.Fatalf("Method fail when it shouldn't! %s", errSec)
@@ -73,7 +74,7 @@ func setupEndpointTest(t *testing.T) func(t *testing.T) {
 	logger := logging.GetLogger()
 	logger.Println("########### Setup test ############")
 
-	apiClient := &APIClient{&http.Client{}}
+	apiClient := &APIClient{BaseClient: &http.Client{}}
 	testConfig := getFuncTestConfig()
 	testConfig.HTTPClient = apiClient
 	controller := &Controller{Config: testConfig}
@@ -96,7 +97,7 @@ func setupEndpointTest(t *testing.T) func(t *testing.T) {
 	}
 
 	logger.Printf("Creating test client: %s", newClient.ClientID)
-	errCreate := apiClient.createClient(rr, controller, token, *newClient)
+	errCreate := apiClient.createClient(context.Background(), rr, controller, token, *newClient)
 
 	if errCreate != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", errCreate)
@@ -110,14 +111,14 @@ func setupEndpointTest(t *testing.T) func(t *testing.T) {
 	}
 
 	logger.Printf("Creating test user: %s", newUser.Username)
-	errCreateU := apiClient.createUser(testConfig, token, newUser)
+	errCreateU := apiClient.createUser(context.Background(), testConfig, token, newUser)
 
 	if errCreateU != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", errCreateU)
 	}
 
 	logger.Printf("Getting test user id: %s", newUser.Username)
-	userID, errGetU := apiClient.getUserID(testConfig, token, newUser)
+	userID, errGetU := apiClient.getUserID(context.Background(), testConfig, token, newUser)
 
 	if errGetU != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", errGetU)
@@ -131,7 +132,7 @@ func setupEndpointTest(t *testing.T) func(t *testing.T) {
 	}
 
 	logger.Printf("Setting up test user %s credentianls", newUser.Username)
-	errReset := apiClient.setUserPassword(testConfig, token, newCredential, userID)
+	errReset := apiClient.setUserPassword(context.Background(), testConfig, token, newCredential, userID)
 
 	if errReset != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", errReset)
@@ -150,21 +151,21 @@ func setupEndpointTest(t *testing.T) func(t *testing.T) {
 		}
 
 		logger.Printf("Getting client id for client %s", newClient.ClientID)
-		clientInfo, errGet := apiClient.getClient(rr, controller, token, *newClientWithSecret)
+		clientInfo, errGet := apiClient.getClient(context.Background(), rr, controller, token, *newClientWithSecret)
 
 		if errGet != nil {
 			t.Fatalf("Method fail when it shouldn't! %s", errGet)
 		}
 
 		logger.Printf("Delete client: %s", newClient.ClientID)
-		errDelete := apiClient.deleteClient(rr, controller, token, clientInfo.ID)
+		errDelete := apiClient.deleteClient(context.Background(), rr, controller, token, clientInfo.ID)
 
 		if errDelete != nil {
 			t.Fatalf("Method fail when it shouldn't! %s", errDelete)
 		}
 
 		logger.Printf("Delete user: %s with id %s", newClient.ClientID, userID)
-		errDeleteU := apiClient.deleteUser(testConfig, token, userID)
+		errDeleteU := apiClient.deleteUser(context.Background(), testConfig, token, userID)
 
 		if errDeleteU != nil {
 			t.Fatalf("Method fail when it shouldn't! %s", errDeleteU)
@@ -178,7 +179,7 @@ func setupClientAuthEndpointTest(t *testing.T) func(t *testing.T) {
 	logger := logging.GetLogger()
 	logger.Println("########### Setup test ############")
 
-	apiClient := &APIClient{&http.Client{}}
+	apiClient := &APIClient{BaseClient: &http.Client{}}
 	testConfig := getFuncTestConfig()
 	testConfig.HTTPClient = apiClient
 	controller := &Controller{Config: testConfig}
@@ -211,7 +212,7 @@ func setupClientAuthEndpointTest(t *testing.T) func(t *testing.T) {
 
 	for _, item := range clientsSlice {
 		logger.Printf("Creating test client: %s", item.ClientID)
-		errCreate := apiClient.createClient(rr, controller, token, *item)
+		errCreate := apiClient.createClient(context.Background(), rr, controller, token, *item)
 
 		if errCreate != nil {
 			t.Fatalf("Method fail when it shouldn't! %s", errCreate)
@@ -225,14 +226,14 @@ func setupClientAuthEndpointTest(t *testing.T) func(t *testing.T) {
 
 		for _, item := range clientsSlice {
 			logger.Printf("Getting client id for client %s", item.ClientID)
-			clientInfo, errGet := apiClient.getClient(rr, controller, token, *item)
+			clientInfo, errGet := apiClient.getClient(context.Background(), rr, controller, token, *item)
 
 			if errGet != nil {
 				t.Fatalf("Method fail when it shouldn't! %s", errGet)
 			}
 
 			logger.Printf("Delete client: %s", item.ClientID)
-			errDelete := apiClient.deleteClient(rr, controller, token, clientInfo.ID)
+			errDelete := apiClient.deleteClient(context.Background(), rr, controller, token, clientInfo.ID)
 
 			if errDelete != nil {
 				t.Fatalf("Method fail when it shouldn't! %s", errDelete)
@@ -244,7 +245,7 @@ func setupClientAuthEndpointTest(t *testing.T) func(t *testing.T) {
 }
 
 func TestIntegrationSwagger(t *testing.T) {
-	apiClient := &APIClient{&http.Client{}}
+	apiClient := &APIClient{BaseClient: &http.Client{}}
 	testConfig := getFuncTestConfig()
 	testConfig.HTTPClient = apiClient
 	byteArr := []byte("")
@@ -265,7 +266,7 @@ func TestIntegrationSwagger(t *testing.T) {
 }
 
 func TestIntegrationHealth(t *testing.T) {
-	apiClient := &APIClient{&http.Client{}}
+	apiClient := &APIClient{BaseClient: &http.Client{}}
 	testConfig := getFuncTestConfig()
 	testConfig.HTTPClient = apiClient
 	byteArr := []byte("")
@@ -286,7 +287,7 @@ func TestIntegrationHealth(t *testing.T) {
 }
 
 func TestIntegrationAdminAuthenticate(t *testing.T) {
-	apiClient := &APIClient{&http.Client{}}
+	apiClient := &APIClient{BaseClient: &http.Client{}}
 	testConfig := getFuncTestConfig()
 	testConfig.HTTPClient = apiClient
 	controller := &Controller{Config: testConfig}
@@ -309,7 +310,7 @@ func TestIntegrationAdminAuthenticate(t *testing.T) {
 }
 
 func TestIntegrationCreateDeleteClient(t *testing.T) {
-	apiClient := &APIClient{&http.Client{}}
+	apiClient := &APIClient{BaseClient: &http.Client{}}
 	testConfig := getFuncTestConfig()
 	testConfig.HTTPClient = apiClient
 	controller := &Controller{Config: testConfig}
@@ -338,19 +339,19 @@ func TestIntegrationCreateDeleteClient(t *testing.T) {
 		t.Fatalf("Problem unmarshalling %s", errUnm)
 	}
 
-	errCreate := apiClient.createClient(rr, controller, token, *newClient)
+	errCreate := apiClient.createClient(context.Background(), rr, controller, token, *newClient)
 
 	if errCreate != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", errCreate)
 	}
 
-	clientOut, errGet := apiClient.getClient(rr, controller, token, *newClientWithSecret)
+	clientOut, errGet := apiClient.getClient(context.Background(), rr, controller, token, *newClientWithSecret)
 
 	if errGet != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", errGet)
 	}
 
-	errDelete := apiClient.deleteClient(rr, controller, token, clientOut.ID)
+	errDelete := apiClient.deleteClient(context.Background(), rr, controller, token, clientOut.ID)
 
 	if errDelete != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", errDelete)
@@ -358,7 +359,7 @@ func TestIntegrationCreateDeleteClient(t *testing.T) {
 }
 
 func TestIntegrationCreateDeleteUser(t *testing.T) {
-	apiClient := &APIClient{&http.Client{}}
+	apiClient := &APIClient{BaseClient: &http.Client{}}
 	testConfig := getFuncTestConfig()
 	testConfig.HTTPClient = apiClient
 	controller := &Controller{Config: testConfig}
@@ -380,13 +381,13 @@ func TestIntegrationCreateDeleteUser(t *testing.T) {
 		t.Fatalf("Problem unmarshalling %s", errUn)
 	}
 
-	errCreate := apiClient.createUser(testConfig, token, newUser)
+	errCreate := apiClient.createUser(context.Background(), testConfig, token, newUser)
 
 	if errCreate != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", errCreate)
 	}
 
-	userID, errGet := apiClient.getUserID(testConfig, token, newUser)
+	userID, errGet := apiClient.getUserID(context.Background(), testConfig, token, newUser)
 
 	if errGet != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", errGet)
@@ -399,13 +400,13 @@ func TestIntegrationCreateDeleteUser(t *testing.T) {
 		t.Fatalf("Problem unmarshalling %s", errUnC)
 	}
 
-	errReset := apiClient.setUserPassword(testConfig, token, newCredential, userID)
+	errReset := apiClient.setUserPassword(context.Background(), testConfig, token, newCredential, userID)
 
 	if errReset != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", errReset)
 	}
 
-	errDelete := apiClient.deleteUser(testConfig, token, userID)
+	errDelete := apiClient.deleteUser(context.Background(), testConfig, token, userID)
 
 	if errDelete != nil {
 		t.Fatalf("Method fail when it shouldn't! %s", errDelete)
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/p53/idp-api/apierror"
+)
+
+// MergePatchContentType - media type of json merge patch (RFC 7396)
+const MergePatchContentType = "application/merge-patch+json"
+
+// ClientSecretHeader - header carrying client secret for requests without client in body
+const ClientSecretHeader = "X-Client-Secret"
+
+// immutableFields - client fields which can't be changed by patch
+var immutableFields = []apierror.FieldError{
+	{Field: "clientId", Reason: "client id can't be changed"},
+	{Field: "attributes", Reason: reasonManagedByAPI},
+}
+
+// readMergePatch - reads patch document, patch must be json object
+func readMergePatch(body io.Reader) (map[string]interface{}, error) {
+	patch := map[string]interface{}{}
+
+	if err := json.NewDecoder(body).Decode(&patch); err != nil {
+		return nil, apierror.WithDetail(apierror.InvalidRequestPayload(), "Merge patch must be json object")
+	}
+
+	fieldErrors := []apierror.FieldError{}
+
+	for _, immutable := range immutableFields {
+		if _, ok := patch[immutable.Field]; ok {
+			fieldErrors = append(fieldErrors, immutable)
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		return nil, validationError(fieldErrors)
+	}
+
+	return patch, nil
+}
+
+// mergePatch - applies merge patch to target document as defined by RFC 7396
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+		} else {
+			targetObj[key] = mergePatch(targetObj[key], value)
+		}
+	}
+
+	return targetObj
+}
+
+// applyMergePatch - applies patch to client, removed fields get zero value
+// and fields unknown to client are rejected
+func applyMergePatch(client Client, patch map[string]interface{}) (Client, error) {
+	current, err := json.Marshal(client)
+
+	if err != nil {
+		return client, apierror.InternalServerError()
+	}
+
+	var doc interface{}
+
+	if err := json.Unmarshal(current, &doc); err != nil {
+		return client, apierror.InternalServerError()
+	}
+
+	patched, err := json.Marshal(mergePatch(doc, patch))
+
+	if err != nil {
+		return client, apierror.InternalServerError()
+	}
+
+	var result Client
+
+	if err := decodeStrict(bytes.NewReader(patched), &result); err != nil {
+		return client, err
+	}
+
+	return result, nil
+}
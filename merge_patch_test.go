@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+func TestMergePatch(t *testing.T) {
+	// examples from RFC 7396 appendix A
+	cases := [][3]string{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, c := range cases {
+		var target, patch interface{}
+		json.Unmarshal([]byte(c[0]), &target)
+		json.Unmarshal([]byte(c[1]), &patch)
+		result, _ := json.Marshal(mergePatch(target, patch))
+		assert.Equal(t, string(result), c[2])
+	}
+}
+
+func TestPatchClient(t *testing.T) {
+	apiClient := &APIClientRecordingMock{client: testExistingClient}
+	patch := `{"redirectUris": ["https://new.example.com/cb"], "serviceAccountsEnabled": true, "description": null}`
+	rr := sendRequest(t, getMockedTestConfig(apiClient), "PATCH", "/client/test", patch, nil)
+
+	if rr.Code != 200 {
+		t.Fatalf("Wrong response code %d %s", rr.Code, rr.Body.String())
+	}
+
+	updated := apiClient.updated
+	assert.Equal(t, updated.ClientID, "test")
+	assert.Assert(t, updated.StandardFlowEnabled)
+	assert.Assert(t, updated.ServiceAccountsEnabled)
+	assert.Equal(t, updated.Description, "")
+	assert.Equal(t, updated.RootUrl, "https://new.example.com/cb")
+	assert.DeepEqual(t, updated.WebOrigins, []string{"https://new.example.com/cb"})
+}
+
+func TestPatchClientRejected(t *testing.T) {
+	apiClient := &APIClientRecordingMock{client: testExistingClient}
+	testConfig := getMockedTestConfig(apiClient)
+
+	cases := map[string][2]string{
+		"1031": {`{"standardFlowEnabled": false}`, "application/json"},
+		"1003": {`["standardFlowEnabled"]`, MergePatchContentType},
+		"1021": {`{"clientId": "other", "unknown": 1}`, MergePatchContentType},
+	}
+
+	for code, c := range cases {
+		rr := sendRequest(t, testConfig, "PATCH", "/client/test", c[0], http.Header{"Content-Type": {c[1]}})
+		retErr := &apierror.ApiError{}
+		json.Unmarshal(rr.Body.Bytes(), retErr)
+
+		if retErr.Code != code {
+			t.Fatalf("Wrong apierror code %s for %s", retErr.Code, c[0])
+		}
+	}
+
+	assert.Assert(t, apiClient.updated == nil)
+
+	rr := sendRequest(t, testConfig, "PATCH", "/client/test", `{"bogus": true}`, http.Header{"Content-Type": {MergePatchContentType + "; charset=utf-8"}})
+	assert.Assert(t, strings.Contains(rr.Body.String(), "bogus"))
+}
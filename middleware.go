@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"github.com/p53/idp-api/apierror"
+	"github.com/p53/idp-api/logging"
+)
+
+type contextKey string
+
+const requestIDKey contextKey = "requestID"
+
+// RequestIDHeader - header carrying id of request, accepted from caller or generated
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[a-zA-Z0-9._:-]{1,128}$`)
+
+// requestIDMiddleware - assigns id to every request, id is echoed in response header
+// and used as instance of problem+json errors
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+
+	if _, err := rand.Read(id); err != nil {
+		logger := logging.GetLogger()
+		logger.Println(err)
+		return ""
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// requestID - returns id of request stored in context
+func requestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey).(string); ok {
+		return id
+	}
+
+	return ""
+}
+
+// writeError - writes error as problem+json response with request id as instance
+func writeError(ctx context.Context, w http.ResponseWriter, err error) {
+	if _, ok := err.(*apierror.ApiError); !ok {
+		logger := logging.GetLogger()
+		logger.Printf("Internal error %s", err)
+	}
+
+	apierror.Write(w, err, requestID(ctx))
+}
+
+// notFoundHandler - problem+json response for unknown endpoints
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(r.Context(), w, apierror.NotFoundError())
+}
+
+// methodNotAllowedHandler - problem+json response for unsupported methods
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeError(r.Context(), w, apierror.MethodNotAllowed())
+}
+
+// recordingWriter - response writer keeping copy of status and body of response
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// detachedContext - context carrying request id of ctx but not its cancellation,
+// for work which must finish even when caller disconnects
+func detachedContext(ctx context.Context) context.Context {
+	return context.WithValue(context.Background(), requestIDKey, requestID(ctx))
+}
+
+// discardWriter - response writer dropping response, used when error of idp call
+// is handled by caller instead of being sent as response
+type discardWriter struct {
+	header http.Header
+}
+
+func newDiscardWriter() *discardWriter {
+	return &discardWriter{header: http.Header{}}
+}
+
+func (dw *discardWriter) Header() http.Header {
+	return dw.header
+}
+
+func (dw *discardWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (dw *discardWriter) WriteHeader(status int) {}
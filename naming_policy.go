@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/p53/idp-api/apierror"
+)
+
+// builtinClients - clients created by keycloak in every realm
+var builtinClients = []string{
+	"account",
+	"account-console",
+	"admin-cli",
+	"broker",
+	"realm-management",
+	"security-admin-console",
+}
+
+const reasonReservedName = "reserved client name"
+
+const (
+	// defaultClientIDPattern - default CLIENT_ID_PATTERN
+	defaultClientIDPattern = `^[a-zA-Z0-9][a-zA-Z0-9._-]*$`
+	// defaultEntityIDPattern - default SAML_ENTITY_ID_PATTERN, http(s) url or urn
+	defaultEntityIDPattern = `^(https?://[^/\s?#]+\S*|urn:[a-zA-Z0-9][a-zA-Z0-9-]*:\S+)$`
+)
+
+// NamingPolicy - rules for clientId of managed clients
+type NamingPolicy struct {
+	// Pattern - clientId must match it, nil allows any clientId
+	Pattern *regexp.Regexp
+	// EntityIDPattern - clientId of SAML client (SP entity ID) must match it instead of Pattern, nil allows any
+	EntityIDPattern *regexp.Regexp
+	// MaxLength - maximal length of clientId, 0 means no limit
+	MaxLength int
+	// RequireTeamPrefix - clientId must start with one of caller groups followed by PrefixSeparator
+	RequireTeamPrefix bool
+	PrefixSeparator   string
+	// Reserved - clientIds which can't be created, updated or deleted through api (case insensitive)
+	Reserved []string
+}
+
+// reservedClients - builtin clients, clients used by api itself and extra reserved names
+func reservedClients(config *Config, extra []string) []string {
+	reserved := append([]string{}, builtinClients...)
+	// each realm has client in master realm named after it
+	reserved = append(reserved, config.IdpRealm+"-realm", "master-realm")
+
+	for _, clientID := range append([]string{config.ClientID, config.ApiClientID}, extra...) {
+		if clientID != "" {
+			reserved = append(reserved, clientID)
+		}
+	}
+
+	return reserved
+}
+
+// checkReserved - reports reserved clientId as ReservedClientName, only check applied to existing clients
+// so clients created before policy or its change stay manageable
+func (policy NamingPolicy) checkReserved(clientID string) error {
+	for _, reserved := range policy.Reserved {
+		if strings.EqualFold(reserved, clientID) {
+			fieldErrors := []apierror.FieldError{{Field: "clientId", Reason: reasonReservedName}}
+			return apierror.WithFieldErrors(apierror.ReservedClientName(), fieldErrors)
+		}
+	}
+
+	return nil
+}
+
+// check - evaluates policy on clientId of created client, reserved names are reported as ReservedClientName,
+// other violations as NamingPolicyViolation listing every broken rule
+func (policy NamingPolicy) check(caller Caller, client Client) error {
+	clientID := client.ClientID
+
+	if inverr := policy.checkReserved(clientID); inverr != nil {
+		return inverr
+	}
+
+	reasons := []string{}
+
+	if policy.MaxLength > 0 && len(clientID) > policy.MaxLength {
+		reasons = append(reasons, fmt.Sprintf("exceeds max length %d", policy.MaxLength))
+	}
+
+	pattern := policy.Pattern
+
+	if client.Protocol == samlProtocol {
+		pattern = policy.EntityIDPattern
+	}
+
+	if pattern != nil && !pattern.MatchString(clientID) {
+		reasons = append(reasons, fmt.Sprintf("does not match pattern %s", pattern))
+	}
+
+	if policy.RequireTeamPrefix {
+		if reason := policy.prefixReason(caller, clientID); reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	fieldErrors := []apierror.FieldError{}
+
+	for _, reason := range reasons {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "clientId", Reason: reason})
+	}
+
+	return apierror.WithFieldErrors(apierror.NamingPolicyViolation(), fieldErrors)
+}
+
+// prefixReason - returns why clientId lacks team prefix, empty string if prefix is present
+func (policy NamingPolicy) prefixReason(caller Caller, clientID string) string {
+	if len(caller.Groups) == 0 {
+		return "team prefix required but caller has no group"
+	}
+
+	prefixes := []string{}
+
+	for _, group := range caller.Groups {
+		// nested groups are identified by last path element
+		team := group[strings.LastIndex(group, "/")+1:]
+		prefix := team + policy.PrefixSeparator
+
+		if strings.HasPrefix(clientID, prefix) {
+			return ""
+		}
+
+		prefixes = append(prefixes, prefix)
+	}
+
+	return fmt.Sprintf("must start with team prefix, one of %s", strings.Join(prefixes, ", "))
+}
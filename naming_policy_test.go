@@ -0,0 +1,81 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+func TestNamingPolicy(t *testing.T) {
+	config := getUnitTestConfig()
+	config.ApiClientID = "idp-api-admin"
+	policy := NamingPolicy{
+		Pattern:           regexp.MustCompile(`^[a-z0-9-]+$`),
+		MaxLength:         20,
+		RequireTeamPrefix: true,
+		PrefixSeparator:   "-",
+		Reserved:          reservedClients(config, []string{"legacy"}),
+	}
+	caller := Caller{Name: "test", Groups: []string{"teams/team-a"}}
+
+	cases := map[string]string{
+		"team-a-service":              "",
+		"team-b-service":              "1023",
+		"team-a-Service":              "1023",
+		"team-a-very-long-service-id": "1023",
+		"admin-cli":                   "1024",
+		"Realm-Management":            "1024",
+		"idp-api-admin":               "1024",
+		"fake":                        "1024",
+		"legacy":                      "1024",
+	}
+
+	for clientID, code := range cases {
+		err := policy.check(caller, Client{ClientID: clientID})
+
+		if code == "" {
+			if err != nil {
+				t.Fatalf("Client id %s should be allowed %s", clientID, err)
+			}
+
+			continue
+		}
+
+		apiErr, ok := err.(*apierror.ApiError)
+
+		if !ok || apiErr.Code != code {
+			t.Fatalf("Client id %s should fail with %s, got %s", clientID, code, err)
+		}
+	}
+}
+
+func TestNamingPolicyCallerWithoutGroup(t *testing.T) {
+	policy := NamingPolicy{RequireTeamPrefix: true, PrefixSeparator: "-"}
+	err := policy.check(Caller{Name: "test"}, Client{ClientID: "team-a-service"})
+	apiErr, ok := err.(*apierror.ApiError)
+
+	if !ok || apiErr.Code != "1023" || len(apiErr.Errors) != 1 {
+		t.Fatalf("Prefix should be required %s", err)
+	}
+}
+
+func TestDeleteNonConformingClient(t *testing.T) {
+	apiClient := &APIClientRecordingMock{caller: "test", client: testExistingClient}
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.NamingPolicy = NamingPolicy{
+		Pattern:           regexp.MustCompile(`^[a-z0-9-]+$`),
+		MaxLength:         10,
+		RequireTeamPrefix: true,
+		PrefixSeparator:   "-",
+		Reserved:          reservedClients(testConfig, nil),
+	}
+
+	// client created before policy stays manageable, reserved clients stay protected
+	rr := sendRequest(t, testConfig, "DELETE", "/client", `{"clientId": "Legacy_Client.example", "clientSecret": "testsecret"}`, nil)
+	assert.Equal(t, rr.Code, 201, rr.Body.String())
+
+	rr = sendRequest(t, testConfig, "DELETE", "/client", `{"clientId": "admin-cli", "clientSecret": "testsecret"}`, nil)
+	assert.Equal(t, rr.Code, 403, rr.Body.String())
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/p53/idp-api/apierror"
+	"github.com/p53/idp-api/logging"
+)
+
+const (
+	reasonMapperDuplicate  = "protocol mapper with same name already listed"
+	reasonMapperExists     = "protocol mapper with same name already exists"
+	reasonMapperProtocol   = "protocol mapper of other protocol"
+	reasonMapperNotAllowed = "protocol mapper type not allowed"
+	reasonMapperRename     = "protocol mapper can't be renamed, must equal mapper in path"
+	reasonReservedClaim    = "claim is reserved"
+	// claimNameConfig - config of mapper naming claim it adds to tokens
+	claimNameConfig = "claim.name"
+)
+
+// defaultMapperTypes - mapper types allowed when PROTOCOL_MAPPER_TYPES is not set,
+// audience mapper only adds audience of other clients, claim mappers must be allowed by admin
+var defaultMapperTypes = []string{"oidc-audience-mapper"}
+
+// defaultReservedClaims - claims mappers may not add when PROTOCOL_MAPPER_RESERVED_CLAIMS is not set,
+// standard claims and claims resource servers trust for authorization
+var defaultReservedClaims = []string{
+	"iss", "sub", "aud", "exp", "nbf", "iat", "jti", "azp", "typ", "sid", "nonce", "acr", "amr", "auth_time",
+	"session_state", "scope", "client_id", "preferred_username", "email", "email_verified",
+	"groups", "roles", "realm_access", "resource_access", "allowed-origins",
+}
+
+// ProtocolMapper - keycloak protocol mapper representation, id is used only towards idp
+type ProtocolMapper struct {
+	ID             string            `json:"id,omitempty"`
+	Name           string            `json:"name"`
+	Protocol       string            `json:"protocol,omitempty"`
+	ProtocolMapper string            `json:"protocolMapper"`
+	Config         map[string]string `json:"config"`
+}
+
+// apiMappers - mappers without idp ids, sorted by name
+func apiMappers(mappers []ProtocolMapper) []ProtocolMapper {
+	if mappers == nil {
+		return nil
+	}
+
+	out := []ProtocolMapper{}
+
+	for _, mapper := range mappers {
+		mapper.ID = ""
+		out = append(out, mapper)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+// findMapper - returns mapper with given name or nil
+func findMapper(mappers []ProtocolMapper, name string) *ProtocolMapper {
+	for i := range mappers {
+		if mappers[i].Name == name {
+			return &mappers[i]
+		}
+	}
+
+	return nil
+}
+
+// withoutProtocolMappers - protocol mappers are reconciled through mapper endpoints of idp
+func withoutProtocolMappers(client Client) Client {
+	client.ProtocolMappers = nil
+	return client
+}
+
+// protocolMapperErrors - mappers of client need name and type, names are unique
+func protocolMapperErrors(client Client) []apierror.FieldError {
+	fieldErrors := []apierror.FieldError{}
+	names := map[string]bool{}
+
+	for i, mapper := range client.ProtocolMappers {
+		field := fmt.Sprintf("protocolMappers[%d]", i)
+		fieldErrors = append(fieldErrors, mapperErrors(field+".", mapper, client.Protocol)...)
+
+		if names[mapper.Name] {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: field + ".name", Reason: reasonMapperDuplicate})
+		}
+
+		names[mapper.Name] = true
+	}
+
+	return fieldErrors
+}
+
+// mapperErrors - checks single mapper, prefix is prepended to field names
+func mapperErrors(prefix string, mapper ProtocolMapper, protocol string) []apierror.FieldError {
+	fieldErrors := []apierror.FieldError{}
+
+	if mapper.Name == "" {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: prefix + "name", Reason: reasonMissing})
+	}
+
+	if mapper.ProtocolMapper == "" {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: prefix + "protocolMapper", Reason: reasonMissing})
+	}
+
+	if mapper.Protocol != "" && mapper.Protocol != protocolOf(protocol) {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: prefix + "protocol", Reason: reasonMapperProtocol})
+	}
+
+	return fieldErrors
+}
+
+// mapperChanged - mapper differs from current one in type or config
+func mapperChanged(mapper ProtocolMapper, current *ProtocolMapper) bool {
+	if current == nil || current.ProtocolMapper != mapper.ProtocolMapper {
+		return true
+	}
+
+	if len(current.Config) == 0 && len(mapper.Config) == 0 {
+		return false
+	}
+
+	return !reflect.DeepEqual(current.Config, mapper.Config)
+}
+
+// mapperAllowed - mapper is of type callers may manage, mappers of other types were added by realm admin
+func (controller *Controller) mapperAllowed(mapper ProtocolMapper) bool {
+	return contains(controller.Config.AllowedMapperTypes, mapper.ProtocolMapper)
+}
+
+// mapperTypeErrors - new and changed mappers must be of allowed type and may not add reserved claims,
+// unchanged mappers are kept, mappers added by realm admin can't be changed
+func (controller *Controller) mapperTypeErrors(prefix string, mapper ProtocolMapper, current *ProtocolMapper) []apierror.FieldError {
+	if !mapperChanged(mapper, current) {
+		return nil
+	}
+
+	if !controller.mapperAllowed(mapper) || (current != nil && !controller.mapperAllowed(*current)) {
+		return []apierror.FieldError{{Field: prefix + "protocolMapper", Reason: reasonMapperNotAllowed}}
+	}
+
+	// nested claims are named by path, e.g. realm_access.roles
+	claim := strings.ToLower(strings.Split(mapper.Config[claimNameConfig], ".")[0])
+
+	if claim != "" && contains(controller.Config.ReservedClaims, claim) {
+		return []apierror.FieldError{{Field: prefix + "config." + claimNameConfig, Reason: reasonReservedClaim}}
+	}
+
+	return nil
+}
+
+// checkProtocolMappers - checks types of mappers requested by client against current mappers of clientInfo
+func (controller *Controller) checkProtocolMappers(ctx context.Context, w http.ResponseWriter, client Client, clientInfo *ClientOut) error {
+	fieldErrors := []apierror.FieldError{}
+	current := []ProtocolMapper{}
+
+	if clientInfo != nil {
+		current = clientInfo.ProtocolMappers
+	}
+
+	for i, mapper := range client.ProtocolMappers {
+		prefix := fmt.Sprintf("protocolMappers[%d].", i)
+		fieldErrors = append(fieldErrors, controller.mapperTypeErrors(prefix, mapper, findMapper(current, mapper.Name))...)
+	}
+
+	if inverr := validationError(fieldErrors); inverr != nil {
+		logging.GetLogger().Println(inverr)
+		writeError(ctx, w, inverr)
+		return inverr
+	}
+
+	return nil
+}
+
+// reconcileProtocolMappers - creates, updates and deletes mappers of clientInfo so it has exactly mappers
+// requested by client, mapper changing type is recreated, mappers added by realm admin are kept,
+// clientInfo is updated with requested and kept mappers
+func (controller *Controller) reconcileProtocolMappers(
+	ctx context.Context,
+	w http.ResponseWriter,
+	token string,
+	clientInfo *ClientOut,
+	client Client) error {
+	if client.ProtocolMappers == nil {
+		return nil
+	}
+
+	httpClient := controller.Config.HTTPClient
+	mappers := client.ProtocolMappers
+
+	for _, current := range clientInfo.ProtocolMappers {
+		mapper := findMapper(client.ProtocolMappers, current.Name)
+
+		if mapper != nil && mapper.ProtocolMapper == current.ProtocolMapper {
+			continue
+		}
+
+		if !controller.mapperAllowed(current) {
+			mappers = append(mappers, current)
+			continue
+		}
+
+		if err := httpClient.deleteProtocolMapper(ctx, w, controller, token, clientInfo.ID, current.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, mapper := range client.ProtocolMappers {
+		current := findMapper(clientInfo.ProtocolMappers, mapper.Name)
+		mapper.Protocol = protocolOf(client.Protocol)
+
+		if current != nil && current.ProtocolMapper == mapper.ProtocolMapper {
+			if !mapperChanged(mapper, current) {
+				continue
+			}
+
+			mapper.ID = current.ID
+
+			if err := httpClient.updateProtocolMapper(ctx, w, controller, token, clientInfo.ID, mapper); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		mapper.ID = ""
+
+		if err := httpClient.createProtocolMapper(ctx, w, controller, token, clientInfo.ID, mapper); err != nil {
+			return err
+		}
+	}
+
+	clientInfo.ProtocolMappers = mappers
+	return nil
+}
+
+// ListProtocolMappers - returns protocol mappers of client
+func (controller *Controller) ListProtocolMappers(w http.ResponseWriter, r *http.Request) {
+	_, _, clientInfo, err := controller.ownedClient(w, r)
+
+	if err != nil {
+		return
+	}
+
+	mappers := apiMappers(clientInfo.ProtocolMappers)
+
+	if mappers == nil {
+		mappers = []ProtocolMapper{}
+	}
+
+	writeMapper(r.Context(), w, http.StatusOK, mappers)
+}
+
+// CreateProtocolMapper - creates protocol mapper of client
+func (controller *Controller) CreateProtocolMapper(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetLogger()
+	httpClient := controller.Config.HTTPClient
+	token, caller, clientInfo, err := controller.ownedClient(w, r)
+
+	if err != nil {
+		return
+	}
+
+	mapper, err := controller.readProtocolMapper(w, r, clientInfo, nil)
+
+	if err != nil {
+		return
+	}
+
+	if findMapper(clientInfo.ProtocolMappers, mapper.Name) != nil {
+		inverr := validationError([]apierror.FieldError{{Field: "name", Reason: reasonMapperExists}})
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	err = httpClient.createProtocolMapper(r.Context(), w, controller, token, clientInfo.ID, mapper)
+	auditMapper(r.Context(), caller, "client.mapper.create", clientInfo, mapper.Name, err)
+
+	if err != nil {
+		return
+	}
+
+	writeMapper(r.Context(), w, http.StatusCreated, mapper)
+}
+
+// UpdateProtocolMapper - updates config of protocol mapper of client, mapper changing type is recreated
+func (controller *Controller) UpdateProtocolMapper(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetLogger()
+	token, caller, clientInfo, err := controller.ownedClient(w, r)
+
+	if err != nil {
+		return
+	}
+
+	mapperName := pathVar(r, "mapperName")
+	current := findMapper(clientInfo.ProtocolMappers, mapperName)
+
+	if current == nil {
+		inverr := apierror.ResourceNotFound()
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	mapper, err := controller.readProtocolMapper(w, r, clientInfo, current)
+
+	if err != nil {
+		return
+	}
+
+	if mapper.Name != mapperName {
+		inverr := validationError([]apierror.FieldError{{Field: "name", Reason: reasonMapperRename}})
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	client := Client{Protocol: clientInfo.Protocol, ProtocolMappers: []ProtocolMapper{mapper}}
+	err = controller.reconcileProtocolMappers(r.Context(), w, token, &ClientOut{ID: clientInfo.ID, ProtocolMappers: []ProtocolMapper{*current}}, client)
+	auditMapper(r.Context(), caller, "client.mapper.update", clientInfo, mapper.Name, err)
+
+	if err != nil {
+		return
+	}
+
+	writeMapper(r.Context(), w, http.StatusOK, mapper)
+}
+
+// DeleteProtocolMapper - deletes protocol mapper of client
+func (controller *Controller) DeleteProtocolMapper(w http.ResponseWriter, r *http.Request) {
+	logger := logging.GetLogger()
+	httpClient := controller.Config.HTTPClient
+	token, caller, clientInfo, err := controller.ownedClient(w, r)
+
+	if err != nil {
+		return
+	}
+
+	mapperName := pathVar(r, "mapperName")
+	current := findMapper(clientInfo.ProtocolMappers, mapperName)
+
+	if current == nil {
+		inverr := apierror.ResourceNotFound()
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	if !controller.mapperAllowed(*current) {
+		inverr := validationError([]apierror.FieldError{{Field: "protocolMapper", Reason: reasonMapperNotAllowed}})
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return
+	}
+
+	err = httpClient.deleteProtocolMapper(r.Context(), w, controller, token, clientInfo.ID, current.ID)
+	auditMapper(r.Context(), caller, "client.mapper.delete", clientInfo, mapperName, err)
+
+	if err != nil {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readProtocolMapper - decodes and validates mapper from request, protocol defaults to protocol of client
+func (controller *Controller) readProtocolMapper(
+	w http.ResponseWriter,
+	r *http.Request,
+	clientInfo *ClientOut,
+	current *ProtocolMapper) (ProtocolMapper, error) {
+	logger := logging.GetLogger()
+	var mapper ProtocolMapper
+	defer r.Body.Close()
+
+	if errDec := decodeStrict(r.Body, &mapper); errDec != nil {
+		logger.Println(errDec)
+		writeError(r.Context(), w, errDec)
+		return mapper, errDec
+	}
+
+	mapper.ID = ""
+	fieldErrors := mapperErrors("", mapper, clientInfo.Protocol)
+
+	if len(fieldErrors) == 0 {
+		fieldErrors = controller.mapperTypeErrors("", mapper, current)
+	}
+
+	if inverr := validationError(fieldErrors); inverr != nil {
+		logger.Println(inverr)
+		writeError(r.Context(), w, inverr)
+		return mapper, inverr
+	}
+
+	mapper.Protocol = protocolOf(clientInfo.Protocol)
+	return mapper, nil
+}
+
+// writeMapper - writes mapper or list of mappers as json response
+func writeMapper(ctx context.Context, w http.ResponseWriter, status int, v interface{}) {
+	mapperOut, err := json.Marshal(v)
+
+	if err != nil {
+		logging.GetLogger().Println(err)
+		writeError(ctx, w, apierror.InternalServerError())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(mapperOut)
+}
+
+// auditMapper - writes change of protocol mapper to audit trail
+func auditMapper(ctx context.Context, caller Caller, action string, clientInfo *ClientOut, mapperName string, err error) {
+	outcome := auditSucceeded
+
+	if err != nil {
+		outcome = auditFailed
+	}
+
+	audit(ctx, AuditEvent{
+		Actor:    caller.Name,
+		Action:   action,
+		ClientID: clientInfo.ClientID,
+		Outcome:  outcome,
+		Detail:   fmt.Sprintf("protocol mapper %s", mapperName),
+	})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+// APIClientMappersMock - returns confidential client with protocol mappers, records mapper changes
+type APIClientMappersMock struct {
+	APIClientRecordingMock
+	changes []string
+}
+
+func newAPIClientMappersMock(mappers ...ProtocolMapper) *APIClientMappersMock {
+	return &APIClientMappersMock{APIClientRecordingMock: APIClientRecordingMock{client: ClientOut{
+		ID:                        "test-uid",
+		DirectAccessGrantsEnabled: true,
+		ProtocolMappers:           mappers,
+	}}}
+}
+
+var testProtocolMappers = []ProtocolMapper{
+	{ID: "audience-id", Name: "audience", Protocol: oidcProtocol, ProtocolMapper: "oidc-audience-mapper",
+		Config: map[string]string{"included.client.audience": "orders-api"}},
+	{ID: "groups-id", Name: "groups", Protocol: oidcProtocol, ProtocolMapper: "oidc-group-membership-mapper"},
+}
+
+func (s *APIClientMappersMock) createProtocolMapper(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	mapper ProtocolMapper) (err error) {
+	s.changes = append(s.changes, "create "+mapper.Name+" "+mapper.Protocol)
+	return nil
+}
+
+func (s *APIClientMappersMock) updateProtocolMapper(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	mapper ProtocolMapper) (err error) {
+	s.changes = append(s.changes, "update "+mapper.ID)
+	return nil
+}
+
+func (s *APIClientMappersMock) deleteProtocolMapper(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string,
+	mapperID string) (err error) {
+	s.changes = append(s.changes, "delete "+mapperID)
+	return nil
+}
+
+// mappersTestConfig - unit test config allowing audience and claim mappers, groups mapper is added by realm admin
+func mappersTestConfig(apiClient APIClientIntf) *Config {
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.AllowedMapperTypes = []string{"oidc-audience-mapper", "oidc-hardcoded-claim-mapper", "oidc-usermodel-attribute-mapper"}
+	testConfig.ReservedClaims = defaultReservedClaims
+	return testConfig
+}
+
+func fieldErrorsOf(t *testing.T, rr *httptest.ResponseRecorder) []apierror.FieldError {
+	retErr := &apierror.ApiError{}
+
+	if errAPI := json.Unmarshal(rr.Body.Bytes(), retErr); errAPI != nil {
+		t.Fatalf("Single problem expected %s", rr.Body.String())
+	}
+
+	return retErr.Errors
+}
+
+func TestCreateClientWithMappers(t *testing.T) {
+	apiClient := newAPIClientMappersMock()
+	payload := `{"clientId": "test", "directAccessGrantsEnabled": true, "protocolMappers": [
+		{"name": "audience", "protocolMapper": "oidc-audience-mapper", "config": {"included.client.audience": "orders-api"}}]}`
+	rr := sendRequest(t, mappersTestConfig(apiClient), "POST", "/client", payload, nil)
+
+	assert.Equal(t, rr.Code, 201, rr.Body.String())
+	assert.DeepEqual(t, apiClient.changes, []string{"create audience openid-connect"})
+}
+
+func TestPatchClientMappers(t *testing.T) {
+	apiClient := newAPIClientMappersMock(testProtocolMappers...)
+	payload := `{"protocolMappers": [
+		{"name": "audience", "protocolMapper": "oidc-audience-mapper", "config": {"included.client.audience": "billing-api"}},
+		{"name": "groups", "protocolMapper": "oidc-group-membership-mapper"},
+		{"name": "team", "protocolMapper": "oidc-hardcoded-claim-mapper", "config": {"claim.name": "team", "claim.value": "orders"}}]}`
+	rr := sendRequest(t, mappersTestConfig(apiClient), "PATCH", "/client/test", payload, nil)
+
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+	assert.DeepEqual(t, apiClient.changes, []string{"update audience-id", "create team openid-connect"})
+}
+
+func TestPatchClientMappersRemoved(t *testing.T) {
+	apiClient := newAPIClientMappersMock(testProtocolMappers...)
+	rr := sendRequest(t, mappersTestConfig(apiClient), "PATCH", "/client/test", `{"protocolMappers": []}`, nil)
+
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+	assert.DeepEqual(t, apiClient.changes, []string{"delete audience-id"})
+}
+
+func TestPatchClientMappersClaims(t *testing.T) {
+	apiClient := newAPIClientMappersMock(testProtocolMappers...)
+	payload := `{"protocolMappers": [
+		{"name": "audience", "protocolMapper": "oidc-audience-mapper", "config": {"included.client.audience": "orders-api"}},
+		{"name": "groups", "protocolMapper": "oidc-audience-mapper"},
+		{"name": "roles", "protocolMapper": "oidc-hardcoded-claim-mapper", "config": {"claim.name": "realm_access.roles", "claim.value": "admin"}},
+		{"name": "party", "protocolMapper": "oidc-usermodel-attribute-mapper", "config": {"claim.name": "AZP"}}]}`
+	rr := sendRequest(t, mappersTestConfig(apiClient), "PATCH", "/client/test", payload, nil)
+
+	assert.Equal(t, rr.Code, 400)
+	assert.DeepEqual(t, fieldErrorsOf(t, rr), []apierror.FieldError{
+		{Field: "protocolMappers[1].protocolMapper", Reason: reasonMapperNotAllowed},
+		{Field: "protocolMappers[2].config.claim.name", Reason: reasonReservedClaim},
+		{Field: "protocolMappers[3].config.claim.name", Reason: reasonReservedClaim},
+	})
+
+	// claim mappers are not allowed by default
+	testConfig := mappersTestConfig(apiClient)
+	testConfig.AllowedMapperTypes = defaultMapperTypes
+	payload = `{"name": "team", "protocolMapper": "oidc-hardcoded-claim-mapper", "config": {"claim.name": "team"}}`
+	rr = sendRequest(t, testConfig, "POST", "/client/test/mappers", payload, nil)
+
+	assert.Equal(t, rr.Code, 400)
+	assert.DeepEqual(t, fieldErrorsOf(t, rr), []apierror.FieldError{{Field: "protocolMapper", Reason: reasonMapperNotAllowed}})
+	assert.Equal(t, len(apiClient.changes), 0)
+}
+
+func TestPatchClientMappersRejected(t *testing.T) {
+	apiClient := newAPIClientMappersMock(testProtocolMappers...)
+	payload := `{"protocolMappers": [
+		{"name": "groups", "protocolMapper": "oidc-group-membership-mapper", "config": {"full.path": "true"}},
+		{"name": "roles", "protocolMapper": "oidc-hardcoded-role-mapper"}]}`
+	rr := sendRequest(t, mappersTestConfig(apiClient), "PATCH", "/client/test", payload, nil)
+
+	assert.Equal(t, rr.Code, 400)
+	assert.DeepEqual(t, fieldErrorsOf(t, rr), []apierror.FieldError{
+		{Field: "protocolMappers[0].protocolMapper", Reason: reasonMapperNotAllowed},
+		{Field: "protocolMappers[1].protocolMapper", Reason: reasonMapperNotAllowed},
+	})
+	assert.Equal(t, len(apiClient.changes), 0)
+}
+
+func TestProtocolMapperRules(t *testing.T) {
+	client := mustDecodeClient(t, `{"clientId": "test", "protocolMappers": [
+		{"name": "audience", "protocolMapper": "oidc-audience-mapper"},
+		{"name": "audience", "protocol": "saml"}]}`)
+
+	assert.DeepEqual(t, protocolMapperErrors(client), []apierror.FieldError{
+		{Field: "protocolMappers[1].protocolMapper", Reason: reasonMissing},
+		{Field: "protocolMappers[1].protocol", Reason: reasonMapperProtocol},
+		{Field: "protocolMappers[1].name", Reason: reasonMapperDuplicate},
+	})
+}
+
+func TestListProtocolMappers(t *testing.T) {
+	apiClient := newAPIClientMappersMock(testProtocolMappers...)
+	rr := sendRequest(t, mappersTestConfig(apiClient), "GET", "/client/test/mappers", "", nil)
+
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+	mappers := []ProtocolMapper{}
+
+	if err := json.Unmarshal(rr.Body.Bytes(), &mappers); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(mappers), 2)
+	assert.Equal(t, mappers[0].Name, "audience")
+	assert.Equal(t, mappers[0].ID, "")
+}
+
+func TestCreateProtocolMapper(t *testing.T) {
+	apiClient := newAPIClientMappersMock(testProtocolMappers...)
+	rr := sendRequest(t, mappersTestConfig(apiClient), "POST", "/client/test/mappers", `{"name": "email", "protocolMapper": "oidc-usermodel-attribute-mapper"}`, nil)
+
+	assert.Equal(t, rr.Code, 201, rr.Body.String())
+	assert.DeepEqual(t, apiClient.changes, []string{"create email openid-connect"})
+
+	rr = sendRequest(t, mappersTestConfig(apiClient), "POST", "/client/test/mappers", `{"name": "audience", "protocolMapper": "oidc-audience-mapper"}`, nil)
+	assert.Equal(t, rr.Code, 400)
+	assert.DeepEqual(t, fieldErrorsOf(t, rr), []apierror.FieldError{{Field: "name", Reason: reasonMapperExists}})
+}
+
+func TestUpdateProtocolMapper(t *testing.T) {
+	apiClient := newAPIClientMappersMock(testProtocolMappers...)
+	rr := sendRequest(t, mappersTestConfig(apiClient), "PUT", "/client/test/mappers/audience", `{"name": "audience", "protocolMapper": "oidc-hardcoded-claim-mapper"}`, nil)
+
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+	assert.DeepEqual(t, apiClient.changes, []string{"delete audience-id", "create audience openid-connect"})
+
+	rr = sendRequest(t, mappersTestConfig(apiClient), "PUT", "/client/test/mappers/audience", `{"name": "renamed", "protocolMapper": "oidc-audience-mapper"}`, nil)
+	assert.Equal(t, rr.Code, 400)
+	assert.DeepEqual(t, fieldErrorsOf(t, rr), []apierror.FieldError{{Field: "name", Reason: reasonMapperRename}})
+}
+
+func TestDeleteProtocolMapper(t *testing.T) {
+	apiClient := newAPIClientMappersMock(testProtocolMappers...)
+	rr := sendRequest(t, mappersTestConfig(apiClient), "DELETE", "/client/test/mappers/audience", "", nil)
+
+	assert.Equal(t, rr.Code, 204, rr.Body.String())
+	assert.DeepEqual(t, apiClient.changes, []string{"delete audience-id"})
+
+	// mapper added by realm admin
+	rr = sendRequest(t, mappersTestConfig(apiClient), "DELETE", "/client/test/mappers/groups", "", nil)
+	assert.Equal(t, rr.Code, 400)
+	assert.DeepEqual(t, fieldErrorsOf(t, rr), []apierror.FieldError{{Field: "protocolMapper", Reason: reasonMapperNotAllowed}})
+	assert.DeepEqual(t, apiClient.changes, []string{"delete audience-id"})
+
+	rr = sendRequest(t, mappersTestConfig(apiClient), "DELETE", "/client/test/mappers/unknown", "", nil)
+	assert.Equal(t, rr.Code, 404)
+}
@@ -0,0 +1,24 @@
+package main
+
+const (
+	pkceMethodAttribute = "pkce.code.challenge.method"
+	pkceMethod          = "S256"
+)
+
+// enforcePKCE - public clients can't keep secret, they must use code flow with S256 PKCE
+func enforcePKCE(client *Client) {
+	if !client.PublicClient {
+		return
+	}
+
+	clientAttributes(client)[pkceMethodAttribute] = pkceMethod
+}
+
+// clientAttributes - returns attributes of client, creating them when missing
+func clientAttributes(client *Client) map[string]string {
+	if client.Attributes == nil {
+		client.Attributes = map[string]string{}
+	}
+
+	return client.Attributes
+}
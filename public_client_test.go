@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+// APIClientPublicMock - manages public client owned by alice
+type APIClientPublicMock struct {
+	APIClientRecordingMock
+}
+
+func newAPIClientPublicMock(caller string) *APIClientPublicMock {
+	return &APIClientPublicMock{APIClientRecordingMock{caller: caller, client: ClientOut{
+		ID:                  "test-uid",
+		PublicClient:        true,
+		StandardFlowEnabled: true,
+		RedirectUris:        []string{"https://example.com/callback"},
+		Attributes:          map[string]string{ownerAttribute: "alice", pkceMethodAttribute: pkceMethod},
+	}}}
+}
+
+func (s *APIClientPublicMock) getClientSecret(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (clientSecret string, err error) {
+	panic("public client has no secret")
+}
+
+func TestCreatePublicClient(t *testing.T) {
+	apiClient := newAPIClientPublicMock("alice")
+	rr := sendRequest(t, getMockedTestConfig(apiClient), "POST", "/client", testPublicPayload, nil)
+
+	if rr.Code != 201 {
+		t.Fatalf("Wrong response code %d %s", rr.Code, rr.Body.String())
+	}
+
+	assert.Equal(t, apiClient.created.Attributes[pkceMethodAttribute], pkceMethod)
+	assert.Equal(t, apiClient.created.Attributes[ownerAttribute], "alice")
+
+	client := &Client{}
+
+	if err := json.Unmarshal(rr.Body.Bytes(), client); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, client.ClientID, "test")
+	assert.Assert(t, client.PublicClient)
+}
+
+func TestPublicClientRules(t *testing.T) {
+	payload := `{"clientId": "test", "publicClient": true, "standardFlowEnabled": true, "implicitFlowEnabled": true, "redirectUris": ["https://example.com/*"]}`
+	fieldErrors := validateClient(mustDecodeClient(t, payload))
+
+	assert.DeepEqual(t, fieldErrors, []apierror.FieldError{
+		{Field: "implicitFlowEnabled", Reason: reasonImplicitPublic},
+		{Field: "redirectUris[0]", Reason: reasonPublicWildcard},
+	})
+
+	payload = `{"clientId": "test", "publicClient": true, "standardFlowEnabled": true}`
+	fieldErrors = validateClient(mustDecodeClient(t, payload))
+
+	assert.DeepEqual(t, fieldErrors, []apierror.FieldError{{Field: "redirectUris", Reason: reasonPublicRedirect}})
+}
+
+func TestUpdatePublicClient(t *testing.T) {
+	apiClient := newAPIClientPublicMock("alice")
+	rr := sendRequest(t, getMockedTestConfig(apiClient), "PUT", "/client", testPublicPayload, nil)
+
+	if rr.Code != 201 {
+		t.Fatalf("Wrong response code %d %s", rr.Code, rr.Body.String())
+	}
+
+	assert.Equal(t, apiClient.updated.Attributes[pkceMethodAttribute], pkceMethod)
+}
+
+func TestUpdatePublicClientRejected(t *testing.T) {
+	cases := map[string][2]string{
+		"1033": {"bob", testPublicPayload},
+		"1021": {"alice", testPayload},
+	}
+
+	for code, c := range cases {
+		apiClient := newAPIClientPublicMock(c[0])
+		rr := sendRequest(t, getMockedTestConfig(apiClient), "PUT", "/client", c[1], nil)
+
+		retErr := &apierror.ApiError{}
+
+		if errAPI := json.Unmarshal(rr.Body.Bytes(), retErr); errAPI != nil {
+			t.Fatalf("Single problem expected %s", rr.Body.String())
+		}
+
+		assert.Equal(t, retErr.Code, code)
+		assert.Assert(t, apiClient.updated == nil)
+	}
+}
+
+func mustDecodeClient(t *testing.T, payload string) Client {
+	var client Client
+
+	if err := decodeStrict(bytes.NewBuffer([]byte(payload)), &client); err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/p53/idp-api/apierror"
+	"github.com/p53/idp-api/logging"
+)
+
+const (
+	// ownerAttribute - client attribute holding auth entity which created client
+	ownerAttribute = "idp-api.owner"
+	// ownerGroupsAttribute - client attribute holding groups of creator, comma separated
+	ownerGroupsAttribute = "idp-api.owner-groups"
+	// clientCountTTL - clients are recounted from realm after this long, which picks up clients
+	// changed outside of api, in between creates and deletes keep counts up to date
+	clientCountTTL = 5 * time.Minute
+)
+
+// Quotas - limits of clients per auth entity and per group, 0 means no limit,
+// group limits apply to sum of clients created by all group members
+type Quotas struct {
+	MaxClients             int
+	MaxCreatesPerHour      int
+	GroupMaxClients        map[string]int
+	GroupMaxCreatesPerHour map[string]int
+
+	mu      sync.Mutex
+	creates map[string][]time.Time
+	// clients - clients per owner key from last count, nil until counted
+	clients map[string]int
+	// reserved - creates in progress per owner key
+	reserved  map[string]int
+	countedAt time.Time
+	now       func() time.Time
+}
+
+// QuotaUsage - usage and limits of single owner (auth entity or group)
+type QuotaUsage struct {
+	Owner             string `json:"owner"`
+	Clients           int    `json:"clients"`
+	MaxClients        int    `json:"maxClients"`
+	CreatesLastHour   int    `json:"createsLastHour"`
+	MaxCreatesPerHour int    `json:"maxCreatesPerHour"`
+}
+
+// Usage - quota usage of caller and its groups
+type Usage struct {
+	Entity QuotaUsage   `json:"entity"`
+	Groups []QuotaUsage `json:"groups"`
+}
+
+// NewQuotas - creates quotas with empty create history
+func NewQuotas(maxClients int, maxCreatesPerHour int, groupMaxClients map[string]int, groupMaxCreatesPerHour map[string]int) *Quotas {
+	return &Quotas{
+		MaxClients:             maxClients,
+		MaxCreatesPerHour:      maxCreatesPerHour,
+		GroupMaxClients:        groupMaxClients,
+		GroupMaxCreatesPerHour: groupMaxCreatesPerHour,
+		creates:                map[string][]time.Time{},
+		reserved:               map[string]int{},
+		now:                    time.Now,
+	}
+}
+
+func entityKey(entity string) string {
+	return "entity:" + entity
+}
+
+func groupKey(group string) string {
+	return "group:" + group
+}
+
+// ownerAttributes - client attributes identifying owner of client
+func ownerAttributes(caller Caller) map[string]string {
+	return map[string]string{
+		ownerAttribute:       caller.Name,
+		ownerGroupsAttribute: strings.Join(caller.Groups, ","),
+	}
+}
+
+// isOwner - checks if caller or one of its groups created client
+func isOwner(caller Caller, clientOut *ClientOut) bool {
+	if owner, ok := clientOut.Attributes[ownerAttribute]; ok && owner == caller.Name {
+		return true
+	}
+
+	for _, group := range strings.Split(clientOut.Attributes[ownerGroupsAttribute], ",") {
+		if group != "" && contains(caller.Groups, group) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// limitsClients - checks if any client count limit applies to caller, so clients must be counted
+func (quotas *Quotas) limitsClients(caller Caller) bool {
+	if quotas.MaxClients > 0 {
+		return true
+	}
+
+	for _, group := range caller.Groups {
+		if quotas.GroupMaxClients[group] > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recentCreates - number of creates of owner in last hour including creates in progress,
+// older records are dropped, must be called with lock held
+func (quotas *Quotas) recentCreates(key string) int {
+	since := quotas.now().Add(-time.Hour)
+	recent := []time.Time{}
+
+	for _, created := range quotas.creates[key] {
+		if created.After(since) {
+			recent = append(recent, created)
+		}
+	}
+
+	if len(recent) == 0 {
+		delete(quotas.creates, key)
+	} else {
+		quotas.creates[key] = recent
+	}
+
+	return len(recent)
+}
+
+// clientOwnerKeys - quota keys of owner and owner groups stored in client attributes
+func clientOwnerKeys(attributes map[string]string) []string {
+	keys := []string{}
+
+	if owner := attributes[ownerAttribute]; owner != "" {
+		keys = append(keys, entityKey(owner))
+	}
+
+	for _, group := range strings.Split(attributes[ownerGroupsAttribute], ",") {
+		if group != "" {
+			keys = append(keys, groupKey(group))
+		}
+	}
+
+	return keys
+}
+
+// count - counts clients of every owner in realm when counts are older than clientCountTTL
+func (quotas *Quotas) count(ctx context.Context, w http.ResponseWriter, controller *Controller, token string) error {
+	quotas.mu.Lock()
+	fresh := quotas.clients != nil && quotas.now().Sub(quotas.countedAt) < clientCountTTL
+	quotas.mu.Unlock()
+
+	if fresh {
+		return nil
+	}
+
+	clients := map[string]int{}
+	err := forEachClient(ctx, w, controller, token, func(client ClientOut) bool {
+		for _, key := range clientOwnerKeys(client.Attributes) {
+			clients[key]++
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return err
+	}
+
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+
+	quotas.clients = clients
+	quotas.countedAt = quotas.now()
+	return nil
+}
+
+// usage - returns usage of caller and its groups, realm clients are counted only
+// when client count limit applies to caller or countAlways is set
+func (quotas *Quotas) usage(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	caller Caller,
+	countAlways bool) (*Usage, error) {
+	if countAlways || quotas.limitsClients(caller) {
+		if err := quotas.count(ctx, w, controller, token); err != nil {
+			return nil, err
+		}
+	}
+
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+
+	return quotas.usageOf(caller), nil
+}
+
+// usageOf - usage of caller including creates in progress, must be called with lock held
+func (quotas *Quotas) usageOf(caller Caller) *Usage {
+	quotaUsage := func(owner string, key string, maxClients int, maxCreatesPerHour int) QuotaUsage {
+		return QuotaUsage{
+			Owner:             owner,
+			Clients:           quotas.clients[key] + quotas.reserved[key],
+			MaxClients:        maxClients,
+			CreatesLastHour:   quotas.recentCreates(key),
+			MaxCreatesPerHour: maxCreatesPerHour,
+		}
+	}
+
+	usage := &Usage{
+		Entity: quotaUsage(caller.Name, entityKey(caller.Name), quotas.MaxClients, quotas.MaxCreatesPerHour),
+		Groups: []QuotaUsage{},
+	}
+
+	for _, group := range caller.Groups {
+		usage.Groups = append(usage.Groups, quotaUsage(group, groupKey(group), quotas.GroupMaxClients[group], quotas.GroupMaxCreatesPerHour[group]))
+	}
+
+	return usage
+}
+
+// reserve - checks quotas of caller and reserves slot for one client in same step, so concurrent
+// creates can't exceed quota together, error is written to w
+func (quotas *Quotas) reserve(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	caller Caller) (*quotaReservation, error) {
+	if quotas.limitsClients(caller) {
+		if err := quotas.count(ctx, w, controller, token); err != nil {
+			return nil, err
+		}
+	}
+
+	quotas.mu.Lock()
+	inverr := quotas.usageOf(caller).exceeded()
+	reservation := &quotaReservation{quotas: quotas, keys: []string{entityKey(caller.Name)}, at: quotas.now()}
+
+	for _, group := range caller.Groups {
+		reservation.keys = append(reservation.keys, groupKey(group))
+	}
+
+	if inverr == nil {
+		for _, key := range reservation.keys {
+			quotas.reserved[key]++
+			quotas.creates[key] = append(quotas.creates[key], reservation.at)
+		}
+	}
+
+	quotas.mu.Unlock()
+
+	if inverr != nil {
+		logging.GetLogger().Println(inverr)
+		writeError(ctx, w, inverr)
+		return nil, inverr
+	}
+
+	return reservation, nil
+}
+
+// recordDelete - deleted client no longer counts to quotas of its owners
+func (quotas *Quotas) recordDelete(clientOut *ClientOut) {
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+
+	if quotas.clients == nil {
+		return
+	}
+
+	for _, key := range clientOwnerKeys(clientOut.Attributes) {
+		if quotas.clients[key] > 0 {
+			quotas.clients[key]--
+		}
+	}
+}
+
+// quotaReservation - slot of client being created, held from quota check until create finishes
+type quotaReservation struct {
+	quotas *Quotas
+	keys   []string
+	at     time.Time
+	done   bool
+}
+
+// commit - client was created, reserved slot becomes owned client
+func (reservation *quotaReservation) commit() {
+	reservation.finish(true)
+}
+
+// release - client was not created or was rolled back, slot and create are given back,
+// client left behind by failed rollback is picked up by next count
+func (reservation *quotaReservation) release() {
+	reservation.finish(false)
+}
+
+func (reservation *quotaReservation) finish(created bool) {
+	if reservation == nil {
+		return
+	}
+
+	quotas := reservation.quotas
+	quotas.mu.Lock()
+	defer quotas.mu.Unlock()
+
+	if reservation.done {
+		return
+	}
+
+	reservation.done = true
+
+	for _, key := range reservation.keys {
+		if quotas.reserved[key]--; quotas.reserved[key] <= 0 {
+			delete(quotas.reserved, key)
+		}
+
+		if created {
+			if quotas.clients != nil {
+				quotas.clients[key]++
+			}
+
+			continue
+		}
+
+		for i, at := range quotas.creates[key] {
+			if at.Equal(reservation.at) {
+				quotas.creates[key] = append(quotas.creates[key][:i:i], quotas.creates[key][i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// exceeded - returns QuotaExceeded error describing first exhausted quota, nil if another
+// client may be created
+func (usage *Usage) exceeded() error {
+	for _, quota := range append([]QuotaUsage{usage.Entity}, usage.Groups...) {
+		if quota.MaxClients > 0 && quota.Clients >= quota.MaxClients {
+			detail := fmt.Sprintf("%s owns %d of %d allowed clients", quota.Owner, quota.Clients, quota.MaxClients)
+			return apierror.WithDetail(apierror.QuotaExceeded(), detail)
+		}
+
+		if quota.MaxCreatesPerHour > 0 && quota.CreatesLastHour >= quota.MaxCreatesPerHour {
+			detail := fmt.Sprintf("%s created %d of %d allowed clients in last hour", quota.Owner, quota.CreatesLastHour, quota.MaxCreatesPerHour)
+			return apierror.WithDetail(apierror.QuotaExceeded(), detail)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+// APIClientOwnedClientsMock - returns clients owned by test caller
+type APIClientOwnedClientsMock struct {
+	APIClientMock
+	lists int
+}
+
+func (s *APIClientOwnedClientsMock) listClients(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	first int,
+	max int) (clients []ClientOut, err error) {
+	s.lists++
+
+	if first > 0 {
+		return []ClientOut{}, nil
+	}
+
+	return []ClientOut{
+		{ID: "1", ClientID: "a", Attributes: map[string]string{ownerAttribute: "test", ownerGroupsAttribute: "team-a"}},
+		{ID: "2", ClientID: "b", Attributes: map[string]string{ownerAttribute: "other", ownerGroupsAttribute: "team-a,team-b"}},
+		{ID: "3", ClientID: "c"},
+	}, nil
+}
+
+func TestQuotaUsage(t *testing.T) {
+	testConfig := getUnitTestConfig()
+	testConfig.HTTPClient = &APIClientOwnedClientsMock{}
+	controller := &Controller{Config: testConfig}
+	caller := Caller{Name: "test", Groups: []string{"team-a"}}
+
+	quotas := NewQuotas(5, 0, map[string]int{"team-a": 2}, nil)
+	usage, err := quotas.usage(context.Background(), httptest.NewRecorder(), controller, "token", caller, false)
+
+	if err != nil {
+		t.Fatalf("Usage failed %s", err)
+	}
+
+	assert.Equal(t, usage.Entity.Clients, 1)
+	assert.Equal(t, usage.Groups[0].Clients, 2)
+
+	apiErr, ok := usage.exceeded().(*apierror.ApiError)
+
+	if !ok || apiErr.Code != "1025" {
+		t.Fatalf("Group quota should be exceeded %v", usage)
+	}
+}
+
+func TestQuotaCreatesPerHour(t *testing.T) {
+	now := time.Now()
+	quotas := NewQuotas(0, 2, nil, nil)
+	quotas.now = func() time.Time { return now }
+	caller := Caller{Name: "test", Groups: []string{}}
+
+	for i := 0; i < 2; i++ {
+		reservation, err := quotas.reserve(context.Background(), httptest.NewRecorder(), nil, "token", caller)
+		assert.NilError(t, err)
+		reservation.commit()
+	}
+
+	assert.Equal(t, quotas.recentCreates(entityKey("test")), 2)
+
+	now = now.Add(61 * time.Minute)
+	assert.Equal(t, quotas.recentCreates(entityKey("test")), 0)
+}
+
+func TestQuotaReservation(t *testing.T) {
+	apiClient := &APIClientOwnedClientsMock{}
+	testConfig := getUnitTestConfig()
+	testConfig.HTTPClient = apiClient
+	controller := &Controller{Config: testConfig}
+	caller := Caller{Name: "test", Groups: []string{"team-a"}}
+	quotas := NewQuotas(3, 0, nil, nil)
+	reserve := func() (*quotaReservation, error) {
+		return quotas.reserve(context.Background(), httptest.NewRecorder(), controller, "token", caller)
+	}
+
+	// create in progress holds its slot
+	first, err := reserve()
+	assert.NilError(t, err)
+	second, err := reserve()
+	assert.NilError(t, err)
+	_, err = reserve()
+	assert.Equal(t, apierror.CodeOf(err), "1025")
+
+	// released slot is given back, committed one is kept
+	first.release()
+	second.commit()
+	third, err := reserve()
+	assert.NilError(t, err)
+	third.release()
+
+	usage, err := quotas.usage(context.Background(), httptest.NewRecorder(), controller, "token", caller, false)
+	assert.NilError(t, err)
+	assert.Equal(t, usage.Entity.Clients, 2)
+
+	quotas.recordDelete(&ClientOut{Attributes: ownerAttributes(caller)})
+	usage, _ = quotas.usage(context.Background(), httptest.NewRecorder(), controller, "token", caller, false)
+	assert.Equal(t, usage.Entity.Clients, 1)
+	assert.Equal(t, usage.Groups[0].Clients, 2)
+
+	// realm is not listed on every create
+	assert.Equal(t, apiClient.lists, 1)
+}
+
+func TestQuotaReleasedOnRollback(t *testing.T) {
+	testConfig := getMockedTestConfig(&APIClientSecretFailureMock{})
+	testConfig.Quotas = NewQuotas(0, 1, nil, nil)
+
+	for i := 0; i < 2; i++ {
+		rr := sendRequest(t, testConfig, "POST", "/client", testPayload, nil)
+		assert.Equal(t, rr.Code, 504, rr.Body.String())
+	}
+
+	usage, err := testConfig.Quotas.usage(context.Background(), httptest.NewRecorder(), nil, "token", Caller{}, false)
+	assert.NilError(t, err)
+	assert.Equal(t, usage.Entity.CreatesLastHour, 0)
+	assert.Equal(t, usage.Entity.Clients, 0)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/p53/idp-api/apierror"
+	"github.com/p53/idp-api/logging"
+)
+
+// idleEntryTTL - buckets and failure records unused for this long are dropped
+const idleEntryTTL = time.Hour
+
+const authOutcomeKey contextKey = "authOutcome"
+
+// authOutcome - result of authentication of caller, recorded in context of request passed through rate limiter
+type authOutcome struct {
+	authenticated bool
+	rejected      bool
+}
+
+// markAuthenticated - records that basic auth credentials of caller were accepted by idp
+func markAuthenticated(ctx context.Context) {
+	if outcome, ok := ctx.Value(authOutcomeKey).(*authOutcome); ok {
+		outcome.authenticated = true
+	}
+}
+
+// markRejected - records that idp rejected credentials of caller, failed admin authentication
+// after caller was authenticated is not counted against caller
+func markRejected(ctx context.Context) {
+	if outcome, ok := ctx.Value(authOutcomeKey).(*authOutcome); ok && !outcome.authenticated {
+		outcome.rejected = true
+	}
+}
+
+// BucketPolicy - token bucket refilled by Rate tokens per second up to Burst, Rate 0 disables limit
+type BucketPolicy struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimiter - limits requests per client ip and per basic auth username and locks
+// out ip and username after MaxFailures failed authentications for Lockout
+type RateLimiter struct {
+	IP             BucketPolicy
+	User           BucketPolicy
+	MaxFailures    int
+	Lockout        time.Duration
+	TrustForwarded bool
+	rejections     uint64
+	lockouts       uint64
+	mu             sync.Mutex
+	buckets        map[string]*tokenBucket
+	failures       map[string]*failureRecord
+	lastSweep      time.Time
+	now            func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type failureRecord struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// NewRateLimiter - creates rate limiter with empty state
+func NewRateLimiter(ip BucketPolicy, user BucketPolicy, maxFailures int, lockout time.Duration) *RateLimiter {
+	return &RateLimiter{
+		IP:          ip,
+		User:        user,
+		MaxFailures: maxFailures,
+		Lockout:     lockout,
+		buckets:     map[string]*tokenBucket{},
+		failures:    map[string]*failureRecord{},
+		now:         time.Now,
+	}
+}
+
+// RateLimitStats - counters of rejected requests
+type RateLimitStats struct {
+	Rejections uint64
+	Lockouts   uint64
+}
+
+// Stats - returns counters of rejected requests
+func (limiter *RateLimiter) Stats() RateLimitStats {
+	return RateLimitStats{
+		Rejections: atomic.LoadUint64(&limiter.rejections),
+		Lockouts:   atomic.LoadUint64(&limiter.lockouts),
+	}
+}
+
+// Middleware - rejects locked out or too frequent callers with 429 and Retry-After,
+// 401 responses are counted as failed authentications, failures are cleared only
+// after credentials of caller were accepted, not by requests without authentication
+func (limiter *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.GetLogger()
+		keys := []string{"ip:" + limiter.clientIP(r)}
+		policies := []BucketPolicy{limiter.IP}
+
+		if username, _, ok := r.BasicAuth(); ok && username != "" {
+			keys = append(keys, "user:"+strings.ToLower(username))
+			policies = append(policies, limiter.User)
+		}
+
+		if locked, retryAfter := limiter.locked(keys); locked {
+			logger.Printf("Rejecting locked out caller %s", strings.Join(keys, " "))
+			atomic.AddUint64(&limiter.rejections, 1)
+			limiter.reject(w, r, apierror.AuthenticationLocked(), retryAfter)
+			return
+		}
+
+		for i, key := range keys {
+			if ok, retryAfter := limiter.take(key, policies[i]); !ok {
+				logger.Printf("Rate limit exceeded by %s", key)
+				atomic.AddUint64(&limiter.rejections, 1)
+				limiter.reject(w, r, apierror.TooManyRequests(), retryAfter)
+				return
+			}
+		}
+
+		// only rejected credentials count as failure, idp outages don't lock out callers
+		outcome := &authOutcome{}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authOutcomeKey, outcome)))
+
+		if outcome.rejected {
+			limiter.recordFailure(keys)
+		} else if outcome.authenticated {
+			limiter.recordSuccess(keys)
+		}
+	})
+}
+
+func (limiter *RateLimiter) reject(w http.ResponseWriter, r *http.Request, err error, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(r.Context(), w, apierror.WithDetail(err, fmt.Sprintf("Retry after %d seconds", seconds)))
+}
+
+// clientIP - returns ip of caller, first X-Forwarded-For address is used only when proxy is trusted
+func (limiter *RateLimiter) clientIP(r *http.Request) string {
+	if limiter.TrustForwarded {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// take - takes token from bucket of key, returns time to wait for next token when bucket is empty
+func (limiter *RateLimiter) take(key string, policy BucketPolicy) (bool, time.Duration) {
+	if policy.Rate <= 0 {
+		return true, 0
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := limiter.now()
+	limiter.sweep(now)
+	burst := math.Max(float64(policy.Burst), 1)
+	bucket, ok := limiter.buckets[key]
+
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		limiter.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*policy.Rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		wait := (1 - bucket.tokens) / policy.Rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// locked - checks if any key is locked out, returns remaining lockout
+func (limiter *RateLimiter) locked(keys []string) (bool, time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := limiter.now()
+	var remaining time.Duration
+
+	for _, key := range keys {
+		if record, ok := limiter.failures[key]; ok && record.lockedUntil.After(now) {
+			if left := record.lockedUntil.Sub(now); left > remaining {
+				remaining = left
+			}
+		}
+	}
+
+	return remaining > 0, remaining
+}
+
+// recordFailure - counts failed authentication, key is locked out after MaxFailures
+func (limiter *RateLimiter) recordFailure(keys []string) {
+	if limiter.MaxFailures <= 0 {
+		return
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := limiter.now()
+
+	for _, key := range keys {
+		record, ok := limiter.failures[key]
+
+		// failures older than lockout period are forgotten
+		if !ok || now.Sub(record.last) > limiter.Lockout {
+			record = &failureRecord{}
+			limiter.failures[key] = record
+		}
+
+		record.count++
+		record.last = now
+
+		if record.count >= limiter.MaxFailures {
+			logging.GetLogger().Printf("Locking out %s for %s after %d failed authentications", key, limiter.Lockout, record.count)
+			atomic.AddUint64(&limiter.lockouts, 1)
+			record.lockedUntil = now.Add(limiter.Lockout)
+			record.count = 0
+		}
+	}
+}
+
+// recordSuccess - clears failures of keys after successful authentication
+func (limiter *RateLimiter) recordSuccess(keys []string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := limiter.now()
+
+	for _, key := range keys {
+		if record, ok := limiter.failures[key]; ok && !record.lockedUntil.After(now) {
+			delete(limiter.failures, key)
+		}
+	}
+}
+
+// sweep - drops idle buckets and expired failure records, must be called with lock held
+func (limiter *RateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < time.Minute {
+		return
+	}
+
+	limiter.lastSweep = now
+
+	for key, bucket := range limiter.buckets {
+		if now.Sub(bucket.last) > idleEntryTTL {
+			delete(limiter.buckets, key)
+		}
+	}
+
+	for key, record := range limiter.failures {
+		if now.Sub(record.last) > idleEntryTTL && !record.lockedUntil.After(now) {
+			delete(limiter.failures, key)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+func TestRateLimiterBucket(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(BucketPolicy{}, BucketPolicy{}, 0, 0)
+	limiter.now = func() time.Time { return now }
+	policy := BucketPolicy{Rate: 1, Burst: 2}
+
+	ok, _ := limiter.take("ip:1", policy)
+	assert.Assert(t, ok)
+	ok, _ = limiter.take("ip:1", policy)
+	assert.Assert(t, ok)
+
+	ok, retryAfter := limiter.take("ip:1", policy)
+	assert.Assert(t, !ok)
+	assert.Equal(t, retryAfter, time.Second)
+
+	now = now.Add(time.Second)
+	ok, _ = limiter.take("ip:1", policy)
+	assert.Assert(t, ok)
+}
+
+func TestRateLimiterLockout(t *testing.T) {
+	limiter := NewRateLimiter(BucketPolicy{}, BucketPolicy{}, 3, time.Minute)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		markRejected(r.Context())
+		writeError(r.Context(), w, apierror.AuthenticationFailed())
+	}))
+
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest("POST", "/api/v1/client", nil)
+		req.SetBasicAuth("victim", "guess")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if i < 3 {
+			assert.Equal(t, rr.Code, 401)
+			continue
+		}
+
+		assert.Equal(t, rr.Code, 429)
+		assert.Equal(t, rr.Header().Get("Retry-After"), "60")
+	}
+
+	// username is locked from other addresses as well
+	req := httptest.NewRequest("POST", "/api/v1/client", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.SetBasicAuth("victim", "password")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, 429)
+	assert.Equal(t, limiter.Stats().Lockouts, uint64(2))
+}
+
+func TestRateLimiterTooManyRequests(t *testing.T) {
+	limiter := NewRateLimiter(BucketPolicy{Rate: 0.1, Burst: 1}, BucketPolicy{}, 0, 0)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	codes := []int{}
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/errors", nil))
+		codes = append(codes, rr.Code)
+	}
+
+	assert.DeepEqual(t, codes, []int{200, 429})
+}
+
+func TestRateLimiterSuccessRequiresAuthentication(t *testing.T) {
+	limiter := NewRateLimiter(BucketPolicy{}, BucketPolicy{}, 3, time.Minute)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, ok := r.BasicAuth(); ok {
+			if password != "password" {
+				markRejected(r.Context())
+				writeError(r.Context(), w, apierror.AuthenticationFailed())
+				return
+			}
+
+			markAuthenticated(r.Context())
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	send := func(path string, username string, password string) int {
+		req := httptest.NewRequest("GET", path, nil)
+
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// unauthenticated request does not reset failures of ip
+	codes := []int{
+		send("/api/v1/client", "alice", "guess"),
+		send("/api/v1/client", "bob", "guess"),
+		send("/api/v1/errors", "", ""),
+		send("/api/v1/client", "carol", "guess"),
+		send("/api/v1/client", "dave", "guess"),
+	}
+
+	assert.DeepEqual(t, codes, []int{401, 401, 200, 401, 429})
+
+	// successful authentication resets failures of ip
+	limiter.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	codes = []int{
+		send("/api/v1/client", "erin", "guess"),
+		send("/api/v1/client", "frank", "guess"),
+		send("/api/v1/client", "grace", "password"),
+		send("/api/v1/client", "heidi", "guess"),
+		send("/api/v1/client", "ivan", "guess"),
+	}
+
+	assert.DeepEqual(t, codes, []int{401, 401, 200, 401, 401})
+}
+
+func TestRateLimiterIdpFailureNotCounted(t *testing.T) {
+	status := 500
+	testConfig := getUnitTestConfig()
+	controller := &Controller{Config: testConfig}
+	apiClient := &APIClient{BaseClient: NewTestClient(func(req *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(`{"error": "unknown_error"}`)),
+			Header:     make(http.Header),
+		}
+	})}
+	limiter := NewRateLimiter(BucketPolicy{}, BucketPolicy{}, 3, time.Minute)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiClient.authenticate(w, r, controller, getAuthBodyFromBasicAuth)
+	}))
+	send := func() int {
+		req := httptest.NewRequest("POST", "/api/v1/client", nil)
+		req.SetBasicAuth("alice", "password")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for i := 0; i < limiter.MaxFailures+1; i++ {
+		assert.Equal(t, send(), 500)
+	}
+
+	assert.Equal(t, limiter.Stats().Lockouts, uint64(0))
+
+	// credentials rejected by idp are counted
+	status = 401
+	codes := []int{send(), send(), send(), send()}
+	assert.DeepEqual(t, codes, []int{401, 401, 401, 429})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/p53/idp-api/apierror"
+)
+
+const anyTeam = "*"
+
+const (
+	reasonSchemeNotAllowed  = "scheme not allowed"
+	reasonDomainNotAllowed  = "domain not allowed for caller"
+	reasonLocalhost         = "localhost not allowed"
+	reasonWildcard          = "wildcard not allowed"
+	reasonWildcardPlacement = "wildcard allowed only at end of path"
+)
+
+// RedirectPolicy - rules for redirect uris, web origins, root and admin url of clients
+type RedirectPolicy struct {
+	// AllowedSchemes - schemes of uris, empty allows any scheme
+	AllowedSchemes []string
+	// DomainSuffixes - allowed domain suffixes per caller group, suffixes under "*" apply
+	// to every caller, empty map allows any domain
+	DomainSuffixes map[string][]string
+	// AllowPathWildcards - allows "*" as last character of path, wildcard in host
+	// or bare "*" are never allowed
+	AllowPathWildcards bool
+	// AllowLocalhost - allows localhost and loopback addresses, should be off in production realms
+	AllowLocalhost bool
+}
+
+// check - evaluates policy on all uris of client, returns error listing every violation
+func (policy RedirectPolicy) check(caller Caller, client Client) error {
+	fieldErrors := []apierror.FieldError{}
+	add := func(field string, uri string) {
+		if reason := policy.uriReason(caller, uri); reason != "" {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: field, Reason: reason})
+		}
+	}
+
+	for i, uri := range client.RedirectUris {
+		add(fmt.Sprintf("redirectUris[%d]", i), uri)
+	}
+
+	for i, origin := range client.WebOrigins {
+		// "+" is keycloak shortcut for origins of redirect uris
+		if origin != "+" {
+			add(fmt.Sprintf("webOrigins[%d]", i), origin)
+		}
+	}
+
+	if client.RootUrl != "" {
+		add("rootUrl", client.RootUrl)
+	}
+
+	if client.AdminUrl != "" {
+		add("adminUrl", client.AdminUrl)
+	}
+
+	for i, uri := range client.PostLogoutRedirectUris {
+		if uri != "+" {
+			add(fmt.Sprintf("postLogoutRedirectUris[%d]", i), uri)
+		}
+	}
+
+	if client.BackchannelLogoutURL != "" {
+		add("backchannelLogoutUrl", client.BackchannelLogoutURL)
+	}
+
+	if client.Saml != nil {
+		settings, _ := client.Saml.resolve()
+
+		for i, uri := range settings.AcsUrls {
+			add(fmt.Sprintf("saml.acsUrls[%d]", i), uri)
+		}
+	}
+
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+
+	return apierror.WithFieldErrors(apierror.RedirectPolicyViolation(), fieldErrors)
+}
+
+// uriReason - returns why uri violates policy, empty string for allowed uri
+func (policy RedirectPolicy) uriReason(caller Caller, uri string) string {
+	if uri == "*" {
+		return reasonWildcard
+	}
+
+	parsed, err := url.Parse(uri)
+
+	if err != nil || !parsed.IsAbs() {
+		return reasonMalformedURI
+	}
+
+	if len(policy.AllowedSchemes) > 0 && !contains(policy.AllowedSchemes, strings.ToLower(parsed.Scheme)) {
+		return reasonSchemeNotAllowed
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+
+	if strings.Contains(host, "*") {
+		return reasonWildcard
+	}
+
+	if wildcard := strings.Index(uri, "*"); wildcard >= 0 {
+		if !policy.AllowPathWildcards {
+			return reasonWildcard
+		}
+
+		if wildcard != len(uri)-1 || parsed.RawQuery != "" {
+			return reasonWildcardPlacement
+		}
+	}
+
+	if isLocalhost(host) {
+		if !policy.AllowLocalhost {
+			return reasonLocalhost
+		}
+
+		return ""
+	}
+
+	if host != "" && !policy.domainAllowed(caller, host) {
+		return reasonDomainNotAllowed
+	}
+
+	return ""
+}
+
+// domainAllowed - checks host against suffixes of caller groups
+func (policy RedirectPolicy) domainAllowed(caller Caller, host string) bool {
+	if len(policy.DomainSuffixes) == 0 {
+		return true
+	}
+
+	// copied, appending to shared configuration would race with concurrent checks
+	suffixes := append([]string{}, policy.DomainSuffixes[anyTeam]...)
+
+	for _, group := range caller.Groups {
+		suffixes = append(suffixes, policy.DomainSuffixes[group]...)
+	}
+
+	for _, suffix := range suffixes {
+		suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isLocalhost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func contains(items []string, item string) bool {
+	for _, it := range items {
+		if it == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+func TestRedirectPolicy(t *testing.T) {
+	policy := RedirectPolicy{
+		AllowedSchemes: []string{"https"},
+		DomainSuffixes: map[string][]string{
+			"*":      {"shared.example.com"},
+			"team-a": {"team-a.example.com"},
+		},
+		AllowPathWildcards: true,
+		AllowLocalhost:     false,
+	}
+	caller := Caller{Name: "test", Groups: []string{"team-a"}}
+
+	cases := map[string]string{
+		"https://app.team-a.example.com/callback": "",
+		"https://team-a.example.com/*":            "",
+		"https://shared.example.com/cb":           "",
+		"https://team-b.example.com/cb":           reasonDomainNotAllowed,
+		"https://evilteam-a.example.com/cb":       reasonDomainNotAllowed,
+		"http://team-a.example.com/cb":            reasonSchemeNotAllowed,
+		"https://localhost:8080/cb":               reasonLocalhost,
+		"https://127.0.0.1/cb":                    reasonLocalhost,
+		"*":                                       reasonWildcard,
+		"https://team-a.example.com/*/cb":         reasonWildcardPlacement,
+	}
+
+	for uri, reason := range cases {
+		if got := policy.uriReason(caller, uri); got != reason {
+			t.Fatalf("Wrong reason %q for %s, expected %q", got, uri, reason)
+		}
+	}
+}
+
+func TestRedirectPolicyCheck(t *testing.T) {
+	policy := RedirectPolicy{AllowedSchemes: []string{"https"}}
+	client := Client{
+		ClientID:     "test",
+		RedirectUris: []string{"https://example.com/cb"},
+		WebOrigins:   []string{"+", "*"},
+		RootUrl:      "http://example.com",
+	}
+
+	err := policy.check(Caller{}, client)
+	apiErr, ok := err.(*apierror.ApiError)
+
+	if !ok || apiErr.Code != "1022" {
+		t.Fatalf("Policy should be violated %s", err)
+	}
+
+	if len(apiErr.Errors) != 2 || apiErr.Errors[0].Field != "webOrigins[1]" || apiErr.Errors[1].Field != "rootUrl" {
+		t.Fatalf("Wrong field errors %v", apiErr.Errors)
+	}
+}
+
+func TestRedirectPolicyConcurrentGroups(t *testing.T) {
+	// spare capacity would let appended group suffixes overwrite each other
+	shared := make([]string, 1, 4)
+	shared[0] = "shared.example.com"
+	policy := RedirectPolicy{
+		DomainSuffixes: map[string][]string{
+			"*":      shared,
+			"team-a": {"team-a.example.com"},
+			"team-b": {"team-b.example.com"},
+		},
+	}
+	callers := map[string]Caller{
+		"team-a.example.com": {Name: "alice", Groups: []string{"team-a"}},
+		"team-b.example.com": {Name: "bob", Groups: []string{"team-b"}},
+	}
+
+	var wg sync.WaitGroup
+
+	for host, caller := range callers {
+		wg.Add(1)
+
+		go func(host string, caller Caller) {
+			defer wg.Done()
+
+			for i := 0; i < 1000; i++ {
+				if !policy.domainAllowed(caller, host) {
+					t.Errorf("Domain %s should be allowed for %s", host, caller.Name)
+					return
+				}
+			}
+		}(host, caller)
+	}
+
+	wg.Wait()
+	// shared configuration is left untouched
+	assert.Equal(t, shared[:2][1], "")
+}
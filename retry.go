@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy - configuration of retries of failed idp calls
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// backoff - returns jittered exponential delay before retry number attempt (starting from 0)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+
+	for i := 0; i < attempt; i++ {
+		delay *= 2
+
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			break
+		}
+	}
+
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	// full jitter spreads retries of concurrent requests hitting restarting idp
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// retryable - decides whether failed attempt can be repeated, connection refused
+// is safe for every method as request never reached idp, 502/503 and other transport
+// errors only for idempotent methods
+func retryable(req *http.Request, status int, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	if status == 0 && errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	if !idempotent(req.Method) {
+		return false
+	}
+
+	if status == 0 {
+		return true
+	}
+
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	}
+
+	return false
+}
+
+// sleepContext - waits for delay, returns early with error when context is done
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
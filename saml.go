@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/p53/idp-api/apierror"
+)
+
+const (
+	oidcProtocol = "openid-connect"
+	samlProtocol = "saml"
+
+	samlAcsPostAttribute         = "saml_assertion_consumer_url_post"
+	samlClientSignatureAttribute = "saml.client.signature"
+	samlSigningCertAttribute     = "saml.signing.certificate"
+	samlServerSignatureAttribute = "saml.server.signature"
+	samlAssertionSignAttribute   = "saml.assertion.signature"
+	samlEncryptAttribute         = "saml.encrypt"
+	samlEncryptionCertAttribute  = "saml.encryption.certificate"
+	samlNameIDFormatAttribute    = "saml_name_id_format"
+
+	samlPostBinding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	// SamlMetadataContentType - media type of SAML metadata documents
+	SamlMetadataContentType = "application/samlmetadata+xml"
+)
+
+// samlNameIDFormats - keycloak name id formats by SAML name id format urn
+var samlNameIDFormats = map[string]string{
+	"urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified":  "username",
+	"urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress": "email",
+	"urn:oasis:names:tc:SAML:2.0:nameid-format:transient":    "transient",
+	"urn:oasis:names:tc:SAML:2.0:nameid-format:persistent":   "persistent",
+}
+
+// SamlSettings - service provider definition of saml client, given either explicitly
+// or as SP metadata, signature requirements from metadata are added to explicit ones
+type SamlSettings struct {
+	// EntityID - keycloak identifies saml clients by entity id, so it must equal clientId
+	EntityID              string   `json:"entityId,omitempty"`
+	AcsUrls               []string `json:"acsUrls,omitempty"`
+	SigningCertificate    string   `json:"signingCertificate,omitempty"`
+	EncryptionCertificate string   `json:"encryptionCertificate,omitempty"`
+	// NameIDFormat - username (default), email, transient or persistent
+	NameIDFormat            string `json:"nameIdFormat,omitempty"`
+	ClientSignatureRequired bool   `json:"clientSignatureRequired"`
+	SignDocuments           bool   `json:"signDocuments"`
+	SignAssertions          bool   `json:"signAssertions"`
+	// Metadata - SP metadata xml (EntityDescriptor), exclusive with explicit entity id, urls, certificates and name id format
+	Metadata string `json:"metadata,omitempty"`
+}
+
+// spEntityDescriptor - parts of SP metadata used by api
+type spEntityDescriptor struct {
+	XMLName         xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor *struct {
+		AuthnRequestsSigned  bool `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned bool `xml:"WantAssertionsSigned,attr"`
+		KeyDescriptors       []struct {
+			Use         string `xml:"use,attr"`
+			Certificate string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"KeyDescriptor"`
+		NameIDFormats             []string `xml:"NameIDFormat"`
+		AssertionConsumerServices []struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+		} `xml:"AssertionConsumerService"`
+	} `xml:"SPSSODescriptor"`
+}
+
+// resolve - returns effective settings with SP metadata expanded
+func (settings SamlSettings) resolve() (SamlSettings, []apierror.FieldError) {
+	if settings.Metadata == "" {
+		return settings, nil
+	}
+
+	if settings.EntityID != "" || len(settings.AcsUrls) > 0 || settings.SigningCertificate != "" ||
+		settings.EncryptionCertificate != "" || settings.NameIDFormat != "" {
+		return settings, []apierror.FieldError{{Field: "saml.metadata", Reason: reasonSamlMetadataExclusive}}
+	}
+
+	descriptor := spEntityDescriptor{}
+
+	if err := xml.Unmarshal([]byte(settings.Metadata), &descriptor); err != nil || descriptor.SPSSODescriptor == nil {
+		return settings, []apierror.FieldError{{Field: "saml.metadata", Reason: reasonMalformedSamlMetadata}}
+	}
+
+	sp := descriptor.SPSSODescriptor
+	resolved := SamlSettings{
+		EntityID:                descriptor.EntityID,
+		ClientSignatureRequired: settings.ClientSignatureRequired || sp.AuthnRequestsSigned,
+		SignDocuments:           settings.SignDocuments,
+		SignAssertions:          settings.SignAssertions || sp.WantAssertionsSigned,
+	}
+
+	for _, acs := range sp.AssertionConsumerServices {
+		// first url is used as POST binding url, so POST binding goes first
+		if acs.Binding == samlPostBinding {
+			resolved.AcsUrls = append([]string{acs.Location}, resolved.AcsUrls...)
+		} else {
+			resolved.AcsUrls = append(resolved.AcsUrls, acs.Location)
+		}
+	}
+
+	for _, key := range sp.KeyDescriptors {
+		cert := strings.Join(strings.Fields(key.Certificate), "")
+
+		if key.Use != "encryption" && resolved.SigningCertificate == "" {
+			resolved.SigningCertificate = cert
+		}
+
+		if key.Use == "encryption" && resolved.EncryptionCertificate == "" {
+			resolved.EncryptionCertificate = cert
+		}
+	}
+
+	for _, format := range sp.NameIDFormats {
+		if nameIDFormat, ok := samlNameIDFormats[strings.TrimSpace(format)]; ok {
+			resolved.NameIDFormat = nameIDFormat
+			break
+		}
+	}
+
+	return resolved, nil
+}
+
+// protocolOf - keycloak creates openid-connect client when protocol is missing
+func protocolOf(protocol string) string {
+	if protocol == "" {
+		return oidcProtocol
+	}
+
+	return protocol
+}
+
+// samlErrors - checks saml client, oidc flags have no meaning for it
+func samlErrors(client Client) []apierror.FieldError {
+	fieldErrors := []apierror.FieldError{}
+	oidcFields := map[string]bool{
+		"publicClient":                    client.PublicClient,
+		"standardFlowEnabled":             client.StandardFlowEnabled,
+		"implicitFlowEnabled":             client.ImplicitFlowEnabled,
+		"directAccessGrantsEnabled":       client.DirectAccessGrantsEnabled,
+		"serviceAccountsEnabled":          client.ServiceAccountsEnabled,
+		"redirectUris":                    len(client.RedirectUris) > 0,
+		"webOrigins":                      len(client.WebOrigins) > 0,
+		"clientAuthenticatorType":         client.ClientAuthenticatorType == clientJWTAuthenticator,
+		"jwks":                            client.JwksURL != "" || len(client.Jwks) > 0,
+		"backchannelLogoutUrl":            client.BackchannelLogoutURL != "",
+		"postLogoutRedirectUris":          len(client.PostLogoutRedirectUris) > 0,
+		"deviceAuthorizationGrantEnabled": client.DeviceAuthorizationGrantEnabled,
+		"cibaGrantEnabled":                client.CibaGrantEnabled,
+		"tokenExchangeEnabled":            client.TokenExchangeEnabled,
+	}
+
+	for field, set := range oidcFields {
+		if set {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: field, Reason: reasonNotSaml})
+		}
+	}
+
+	sort.Slice(fieldErrors, func(i, j int) bool {
+		return fieldErrors[i].Field < fieldErrors[j].Field
+	})
+
+	if client.Saml == nil {
+		return append(fieldErrors, apierror.FieldError{Field: "saml", Reason: reasonMissing})
+	}
+
+	settings, metadataErrors := client.Saml.resolve()
+
+	if len(metadataErrors) > 0 {
+		return append(fieldErrors, metadataErrors...)
+	}
+
+	if settings.EntityID != "" && settings.EntityID != client.ClientID {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "saml.entityId", Reason: reasonSamlEntityID})
+	}
+
+	if len(settings.AcsUrls) == 0 {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "saml.acsUrls", Reason: reasonMissing})
+	}
+
+	for i, uri := range settings.AcsUrls {
+		reason := redirectURIReason(uri)
+
+		if reason == "" && strings.Contains(uri, "*") {
+			reason = reasonWildcard
+		}
+
+		if reason != "" {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: fmt.Sprintf("saml.acsUrls[%d]", i), Reason: reason})
+		}
+	}
+
+	if settings.NameIDFormat != "" && !contains([]string{"username", "email", "transient", "persistent"}, settings.NameIDFormat) {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "saml.nameIdFormat", Reason: reasonSamlNameIDFormat})
+	}
+
+	if settings.ClientSignatureRequired && settings.SigningCertificate == "" {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "saml.signingCertificate", Reason: reasonSamlCertRequired})
+	}
+
+	certificates := map[string]string{
+		"saml.signingCertificate":    settings.SigningCertificate,
+		"saml.encryptionCertificate": settings.EncryptionCertificate,
+	}
+
+	for _, field := range []string{"saml.signingCertificate", "saml.encryptionCertificate"} {
+		if certificates[field] != "" {
+			if _, err := certificateBody(certificates[field]); err != nil {
+				fieldErrors = append(fieldErrors, apierror.FieldError{Field: field, Reason: reasonMalformedCertificate})
+			}
+		}
+	}
+
+	if !settings.SignDocuments && !settings.SignAssertions {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "saml.signAssertions", Reason: reasonSamlUnsigned})
+	}
+
+	return fieldErrors
+}
+
+// certificateBody - returns base64 DER of PEM or base64 DER certificate, as expected by keycloak
+func certificateBody(cert string) (string, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(cert), ""))
+
+	if block, _ := pem.Decode([]byte(cert)); block != nil {
+		der, err = block.Bytes, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := x509.ParseCertificate(der); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+// configureSaml - moves saml settings to keycloak attributes, acs urls become redirect uris
+// which keycloak uses to validate acs url of authentication request
+func configureSaml(client *Client) {
+	if client.Protocol != samlProtocol || client.Saml == nil {
+		return
+	}
+
+	settings, _ := client.Saml.resolve()
+	attributes := clientAttributes(client)
+
+	client.RedirectUris = settings.AcsUrls
+
+	if len(settings.AcsUrls) > 0 {
+		attributes[samlAcsPostAttribute] = settings.AcsUrls[0]
+	}
+
+	attributes[samlClientSignatureAttribute] = fmt.Sprint(settings.ClientSignatureRequired)
+	attributes[samlServerSignatureAttribute] = fmt.Sprint(settings.SignDocuments)
+	attributes[samlAssertionSignAttribute] = fmt.Sprint(settings.SignAssertions)
+	attributes[samlEncryptAttribute] = fmt.Sprint(settings.EncryptionCertificate != "")
+
+	if cert, err := certificateBody(settings.SigningCertificate); err == nil {
+		attributes[samlSigningCertAttribute] = cert
+	}
+
+	if cert, err := certificateBody(settings.EncryptionCertificate); err == nil {
+		attributes[samlEncryptionCertAttribute] = cert
+	}
+
+	if settings.NameIDFormat != "" {
+		attributes[samlNameIDFormatAttribute] = settings.NameIDFormat
+	}
+
+	// settings are not part of keycloak client representation
+	client.Saml = nil
+}
+
+// samlSettings - reads saml settings back from keycloak client
+func samlSettings(clientOut *ClientOut) *SamlSettings {
+	settings := &SamlSettings{
+		EntityID:                clientOut.ClientID,
+		AcsUrls:                 clientOut.RedirectUris,
+		SigningCertificate:      clientOut.Attributes[samlSigningCertAttribute],
+		NameIDFormat:            clientOut.Attributes[samlNameIDFormatAttribute],
+		ClientSignatureRequired: clientOut.Attributes[samlClientSignatureAttribute] == "true",
+		SignDocuments:           clientOut.Attributes[samlServerSignatureAttribute] == "true",
+		SignAssertions:          clientOut.Attributes[samlAssertionSignAttribute] == "true",
+	}
+
+	if clientOut.Attributes[samlEncryptAttribute] == "true" {
+		settings.EncryptionCertificate = clientOut.Attributes[samlEncryptionCertAttribute]
+	}
+
+	return settings
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+// APIClientSamlMock - creates saml client and returns it as stored by keycloak, looked up
+// client is returned until client is created
+type APIClientSamlMock struct {
+	APIClientRecordingMock
+}
+
+func (s *APIClientSamlMock) getClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client) (clientOut *ClientOut, err error) {
+	stored := client
+
+	if s.created != nil {
+		stored = *s.created
+	}
+
+	return &ClientOut{
+		ID:           "test-uid",
+		ClientID:     client.ClientID,
+		Protocol:     samlProtocol,
+		RedirectUris: stored.RedirectUris,
+		Attributes:   stored.Attributes,
+	}, nil
+}
+
+func TestSamlMetadataResolve(t *testing.T) {
+	settings, fieldErrors := SamlSettings{Metadata: testSamlMetadata, SignDocuments: true}.resolve()
+
+	assert.Equal(t, len(fieldErrors), 0)
+	assert.Equal(t, settings.EntityID, "https://sp.example.com/metadata")
+	assert.DeepEqual(t, settings.AcsUrls, []string{"https://sp.example.com/saml/acs", "https://sp.example.com/saml/acs-redirect"})
+	assert.Equal(t, settings.SigningCertificate, testSamlCertificate)
+	assert.Equal(t, settings.NameIDFormat, "persistent")
+	assert.Assert(t, settings.ClientSignatureRequired)
+	assert.Assert(t, settings.SignDocuments)
+	assert.Assert(t, settings.SignAssertions)
+}
+
+func TestSamlRules(t *testing.T) {
+	cases := map[string][]apierror.FieldError{
+		`{"clientId": "sp", "protocol": "saml", "standardFlowEnabled": true}`: {
+			{Field: "standardFlowEnabled", Reason: reasonNotSaml},
+			{Field: "saml", Reason: reasonMissing},
+		},
+		`{"clientId": "sp", "protocol": "saml", "saml": {"entityId": "other", "acsUrls": ["https://sp.example.com/*"], "clientSignatureRequired": true}}`: {
+			{Field: "saml.entityId", Reason: reasonSamlEntityID},
+			{Field: "saml.acsUrls[0]", Reason: reasonWildcard},
+			{Field: "saml.signingCertificate", Reason: reasonSamlCertRequired},
+			{Field: "saml.signAssertions", Reason: reasonSamlUnsigned},
+		},
+		`{"clientId": "sp", "protocol": "saml", "saml": {"acsUrls": ["https://sp.example.com/acs"], "signingCertificate": "bm90IGNlcnQ=", "signDocuments": true}}`: {
+			{Field: "saml.signingCertificate", Reason: reasonMalformedCertificate},
+		},
+		`{"clientId": "sp", "protocol": "saml", "saml": {"metadata": "<xml/>", "signDocuments": true}}`: {
+			{Field: "saml.metadata", Reason: reasonMalformedSamlMetadata},
+		},
+		`{"clientId": "sp", "protocol": "saml", "saml": {"metadata": "<xml/>", "nameIdFormat": "email"}}`: {
+			{Field: "saml.metadata", Reason: reasonSamlMetadataExclusive},
+		},
+		`{"clientId": "sp", "saml": {}}`: {
+			{Field: "saml", Reason: reasonSamlWithoutProtocol},
+		},
+		`{"clientId": "sp", "protocol": "wsfed"}`: {
+			{Field: "protocol", Reason: reasonUnsupportedProtocol},
+		},
+	}
+
+	for payload, expected := range cases {
+		assert.DeepEqual(t, validateClient(mustDecodeClient(t, payload)), expected)
+	}
+}
+
+func TestCreateSamlClient(t *testing.T) {
+	apiClient := &APIClientSamlMock{}
+	rr := sendRequest(t, getMockedTestConfig(apiClient), "POST", "/client", testSamlPayload, nil)
+
+	if rr.Code != 201 {
+		t.Fatalf("Wrong response code %d %s", rr.Code, rr.Body.String())
+	}
+
+	created := apiClient.created
+	assert.Assert(t, created.Saml == nil)
+	assert.DeepEqual(t, created.RedirectUris, []string{"https://sp.example.com/saml/acs"})
+	assert.Equal(t, created.Attributes[samlAcsPostAttribute], "https://sp.example.com/saml/acs")
+	assert.Equal(t, created.Attributes[samlSigningCertAttribute], testSamlCertificate)
+	assert.Equal(t, created.Attributes[samlClientSignatureAttribute], "true")
+	assert.Equal(t, created.Attributes[samlNameIDFormatAttribute], "email")
+
+	client := &Client{}
+
+	if err := json.Unmarshal(rr.Body.Bytes(), client); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, client.Protocol, samlProtocol)
+	assert.Equal(t, client.Saml.EntityID, "https://sp.example.com/metadata")
+	assert.Equal(t, client.Saml.NameIDFormat, "email")
+	assert.Equal(t, len(client.RedirectUris), 0)
+}
+
+func TestSamlClientEntityID(t *testing.T) {
+	apiClient := &APIClientSamlMock{APIClientRecordingMock{caller: "alice"}}
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.NamingPolicy = NamingPolicy{
+		Pattern:         regexp.MustCompile(defaultClientIDPattern),
+		EntityIDPattern: regexp.MustCompile(defaultEntityIDPattern),
+		MaxLength:       64,
+	}
+	rr := sendRequest(t, testConfig, "POST", "/client", testSamlPayload, nil)
+	assert.Equal(t, rr.Code, 201, rr.Body.String())
+
+	// entity id is percent encoded as single path segment
+	rr = sendRequest(t, testConfig, "GET", "/client/"+url.PathEscape("https://sp.example.com/metadata"), "", nil)
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+	assert.Equal(t, mustDecodeClient(t, rr.Body.String()).ClientID, "https://sp.example.com/metadata")
+
+	// oidc clients still have to match client id pattern
+	rr = sendRequest(t, testConfig, "POST", "/client", `{"clientId": "https://sp.example.com/metadata", "directAccessGrantsEnabled": true}`, nil)
+	assert.Equal(t, rr.Code, 400, rr.Body.String())
+	assert.Equal(t, fieldErrorsOf(t, rr)[0].Reason, "does not match pattern "+defaultClientIDPattern)
+
+	payload := `{"clientId": "legacy app", "protocol": "saml", "saml": {"acsUrls": ["https://legacy.example.org/saml/acs"], "signDocuments": true}}`
+	rr = sendRequest(t, testConfig, "POST", "/client", payload, nil)
+	assert.Equal(t, rr.Code, 400, rr.Body.String())
+	assert.Equal(t, fieldErrorsOf(t, rr)[0].Reason, "does not match pattern "+defaultEntityIDPattern)
+}
+
+func TestSamlMetadata(t *testing.T) {
+	testConfig := getUnitTestConfig()
+	testConfig.HTTPClient = &APIClientMock{}
+	ctrl := &Controller{Config: testConfig}
+
+	req, err := http.NewRequest("GET", "/saml/metadata", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ctrl.SamlMetadata).ServeHTTP(rr, req)
+
+	assert.Equal(t, rr.Code, 200)
+	assert.Equal(t, rr.Header().Get("Content-Type"), SamlMetadataContentType)
+	assert.Equal(t, rr.Body.String(), testSamlDescriptor)
+}
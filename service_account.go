@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/p53/idp-api/apierror"
+	"github.com/p53/idp-api/logging"
+)
+
+const (
+	reasonNoServiceAccount = "service accounts not enabled for client"
+	reasonUnknownClient    = "unknown client"
+	reasonUnknownRealmRole = "unknown realm role"
+	reasonGrantNotAllowed  = "role can't be granted by client owner"
+)
+
+// ServiceAccountUser - keycloak user backing service account of client
+type ServiceAccountUser struct {
+	ID string `json:"id"`
+}
+
+// ClientMappings - client roles mapped to user
+type ClientMappings struct {
+	ID       string `json:"id"`
+	Client   string `json:"client"`
+	Mappings []Role `json:"mappings"`
+}
+
+// RoleMappings - keycloak realm and client roles mapped to user, client mappings are keyed by clientId
+type RoleMappings struct {
+	RealmMappings  []Role                    `json:"realmMappings"`
+	ClientMappings map[string]ClientMappings `json:"clientMappings"`
+}
+
+// RoleGrants - roles granted to service account, client roles are keyed by clientId
+type RoleGrants struct {
+	RealmRoles  []string            `json:"realmRoles"`
+	ClientRoles map[string][]string `json:"clientRoles"`
+}
+
+// ServiceAccountPolicy - roles which client owners may grant to service accounts of their clients,
+// roles of client itself are always grantable
+type ServiceAccountPolicy struct {
+	// RealmRoles - grantable realm roles
+	RealmRoles []string
+	// ClientRoles - grantable roles of other clients by clientId, "*" allows every role of client
+	ClientRoles map[string][]string
+	// OwnedClientRoles - allows roles of other clients owned by caller
+	OwnedClientRoles bool
+}
+
+// roleGrant - resolved role of grant request, client is nil for realm role
+type roleGrant struct {
+	field  string
+	client *ClientOut
+	role   Role
+}
+
+// allows - checks if caller may grant role to service account of client
+func (policy ServiceAccountPolicy) allows(caller Caller, clientInfo *ClientOut, grant roleGrant) bool {
+	if grant.client == nil {
+		return contains(policy.RealmRoles, grant.role.Name)
+	}
+
+	if grant.client.ID == clientInfo.ID {
+		return true
+	}
+
+	allowed := policy.ClientRoles[grant.client.ClientID]
+
+	if contains(allowed, anyTeam) || contains(allowed, grant.role.Name) {
+		return true
+	}
+
+	return policy.OwnedClientRoles && isOwner(caller, grant.client)
+}
+
+// check - returns error listing every role caller may not grant
+func (policy ServiceAccountPolicy) check(caller Caller, clientInfo *ClientOut, grants []roleGrant) error {
+	fieldErrors := []apierror.FieldError{}
+
+	for _, grant := range grants {
+		if !policy.allows(caller, clientInfo, grant) {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: grant.field, Reason: reasonGrantNotAllowed})
+		}
+	}
+
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+
+	return apierror.WithFieldErrors(apierror.RoleGrantNotAllowed(), fieldErrors)
+}
+
+// GetServiceAccountRoles - returns roles granted to service account of client
+func (controller *Controller) GetServiceAccountRoles(w http.ResponseWriter, r *http.Request) {
+	token, _, clientInfo, err := controller.ownedClient(w, r)
+
+	if err != nil {
+		return
+	}
+
+	userID, err := controller.serviceAccountUser(r.Context(), w, token, clientInfo)
+
+	if err != nil {
+		return
+	}
+
+	controller.writeGrants(r.Context(), w, token, userID)
+}
+
+// GrantServiceAccountRoles - grants realm and client roles to service account of client
+func (controller *Controller) GrantServiceAccountRoles(w http.ResponseWriter, r *http.Request) {
+	controller.changeServiceAccountRoles(w, r, true)
+}
+
+// RevokeServiceAccountRoles - revokes realm and client roles from service account of client
+func (controller *Controller) RevokeServiceAccountRoles(w http.ResponseWriter, r *http.Request) {
+	controller.changeServiceAccountRoles(w, r, false)
+}
+
+// changeServiceAccountRoles - grants or revokes roles, only grants are subject to policy
+func (controller *Controller) changeServiceAccountRoles(w http.ResponseWriter, r *http.Request, grant bool) {
+	logger := logging.GetLogger()
+	httpClient := controller.Config.HTTPClient
+	token, caller, clientInfo, err := controller.ownedClient(w, r)
+
+	if err != nil {
+		return
+	}
+
+	userID, err := controller.serviceAccountUser(r.Context(), w, token, clientInfo)
+
+	if err != nil {
+		return
+	}
+
+	var grants RoleGrants
+	defer r.Body.Close()
+
+	if errDec := decodeStrict(r.Body, &grants); errDec != nil {
+		logger.Println(errDec)
+		writeError(r.Context(), w, errDec)
+		return
+	}
+
+	resolved, err := controller.resolveGrants(r.Context(), w, token, grants)
+
+	if err != nil {
+		return
+	}
+
+	if grant {
+		if inverr := controller.Config.ServiceAccountPolicy.check(caller, clientInfo, resolved); inverr != nil {
+			logger.Println(inverr)
+			writeError(r.Context(), w, inverr)
+			return
+		}
+	}
+
+	// keycloak maps roles per container, so roles are sent grouped by client
+	byClient := map[string][]Role{}
+	names := []string{}
+
+	for _, item := range resolved {
+		clientUID, container := "", "realm"
+
+		if item.client != nil {
+			clientUID, container = item.client.ID, item.client.ClientID
+		}
+
+		byClient[clientUID] = append(byClient[clientUID], item.role)
+		names = append(names, container+":"+item.role.Name)
+	}
+
+	action := "client.service-account.grant"
+	change := httpClient.addRoleMappings
+
+	if !grant {
+		action = "client.service-account.revoke"
+		change = httpClient.removeRoleMappings
+	}
+
+	for clientUID, roles := range byClient {
+		err = change(r.Context(), w, controller, token, userID, clientUID, roles)
+
+		if err != nil {
+			break
+		}
+	}
+
+	outcome := auditSucceeded
+
+	if err != nil {
+		outcome = auditFailed
+	}
+
+	audit(r.Context(), AuditEvent{
+		Actor:    caller.Name,
+		Action:   action,
+		ClientID: clientInfo.ClientID,
+		Outcome:  outcome,
+		Detail:   fmt.Sprintf("roles %s", strings.Join(names, ", ")),
+	})
+
+	if err != nil {
+		return
+	}
+
+	controller.writeGrants(r.Context(), w, token, userID)
+}
+
+// serviceAccountUser - returns id of service account user of client
+func (controller *Controller) serviceAccountUser(
+	ctx context.Context,
+	w http.ResponseWriter,
+	token string,
+	clientInfo *ClientOut) (string, error) {
+	if !clientInfo.ServiceAccountsEnabled {
+		inverr := validationError([]apierror.FieldError{{Field: "serviceAccountsEnabled", Reason: reasonNoServiceAccount}})
+		writeError(ctx, w, inverr)
+		return "", inverr
+	}
+
+	return controller.Config.HTTPClient.getServiceAccountUser(ctx, w, controller, token, clientInfo.ID)
+}
+
+// resolveGrants - looks up requested roles in idp, unknown roles and clients are reported as field errors
+func (controller *Controller) resolveGrants(
+	ctx context.Context,
+	w http.ResponseWriter,
+	token string,
+	grants RoleGrants) ([]roleGrant, error) {
+	httpClient := controller.Config.HTTPClient
+	// lookup failures are written only when they aren't caused by unknown role
+	dw := newDiscardWriter()
+	resolved := []roleGrant{}
+	fieldErrors := []apierror.FieldError{}
+	lookupError := func(err error, field string, reason string) error {
+		if apierror.StatusOf(err) == http.StatusNotFound {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: field, Reason: reason})
+			return nil
+		}
+
+		writeError(ctx, w, err)
+		return err
+	}
+
+	for i, name := range grants.RealmRoles {
+		field := fmt.Sprintf("realmRoles[%d]", i)
+		role, err := httpClient.getRealmRole(ctx, dw, controller, token, name)
+
+		if err != nil {
+			if errLookup := lookupError(err, field, reasonUnknownRealmRole); errLookup != nil {
+				return nil, errLookup
+			}
+
+			continue
+		}
+
+		resolved = append(resolved, roleGrant{field: field, role: *role})
+	}
+
+	clientIDs := []string{}
+
+	for clientID := range grants.ClientRoles {
+		clientIDs = append(clientIDs, clientID)
+	}
+
+	sort.Strings(clientIDs)
+
+	for _, clientID := range clientIDs {
+		target, err := httpClient.getClient(ctx, dw, controller, token, Client{ClientID: clientID})
+
+		if err != nil {
+			if errLookup := lookupError(err, "clientRoles."+clientID, reasonUnknownClient); errLookup != nil {
+				return nil, errLookup
+			}
+
+			continue
+		}
+
+		roles, err := httpClient.listClientRoles(ctx, dw, controller, token, target.ID)
+
+		if err != nil {
+			writeError(ctx, w, err)
+			return nil, err
+		}
+
+		for i, name := range grants.ClientRoles[clientID] {
+			field := fmt.Sprintf("clientRoles.%s[%d]", clientID, i)
+
+			if role := findRole(roles, name); role != nil {
+				resolved = append(resolved, roleGrant{field: field, client: target, role: *role})
+			} else {
+				fieldErrors = append(fieldErrors, apierror.FieldError{Field: field, Reason: reasonUnknownRole})
+			}
+		}
+	}
+
+	if inverr := validationError(fieldErrors); inverr != nil {
+		writeError(ctx, w, inverr)
+		return nil, inverr
+	}
+
+	return resolved, nil
+}
+
+// serviceAccountGrants - roles mapped to service account user
+func (controller *Controller) serviceAccountGrants(
+	ctx context.Context,
+	w http.ResponseWriter,
+	token string,
+	userID string) (*RoleGrants, error) {
+	mappings, err := controller.Config.HTTPClient.getRoleMappings(ctx, w, controller, token, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	grants := &RoleGrants{RealmRoles: []string{}, ClientRoles: map[string][]string{}}
+
+	for _, role := range mappings.RealmMappings {
+		grants.RealmRoles = append(grants.RealmRoles, role.Name)
+	}
+
+	for clientID, clientMappings := range mappings.ClientMappings {
+		for _, role := range clientMappings.Mappings {
+			grants.ClientRoles[clientID] = append(grants.ClientRoles[clientID], role.Name)
+		}
+
+		sort.Strings(grants.ClientRoles[clientID])
+	}
+
+	sort.Strings(grants.RealmRoles)
+	return grants, nil
+}
+
+// writeGrants - writes roles of service account as json response
+func (controller *Controller) writeGrants(ctx context.Context, w http.ResponseWriter, token string, userID string) {
+	grants, err := controller.serviceAccountGrants(ctx, w, token, userID)
+
+	if err != nil {
+		return
+	}
+
+	grantsOut, err := json.Marshal(grants)
+
+	if err != nil {
+		logging.GetLogger().Println(err)
+		writeError(ctx, w, apierror.InternalServerError())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(grantsOut)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+// APIClientServiceAccountMock - keeps role mappings of service account of client "test" in memory,
+// billing-api is owned by caller, orders-api is not
+type APIClientServiceAccountMock struct {
+	APIClientRecordingMock
+	mappings RoleMappings
+}
+
+var testServiceAccountClients = map[string]*ClientOut{
+	"test":        {ID: "test-uid", ClientID: "test", ServiceAccountsEnabled: true},
+	"billing-api": {ID: "billing-uid", ClientID: "billing-api", Attributes: map[string]string{ownerAttribute: "alice"}},
+	"orders-api":  {ID: "orders-uid", ClientID: "orders-api"},
+}
+
+func newAPIClientServiceAccountMock() *APIClientServiceAccountMock {
+	return &APIClientServiceAccountMock{
+		APIClientRecordingMock: APIClientRecordingMock{caller: "alice"},
+		mappings:               RoleMappings{ClientMappings: map[string]ClientMappings{}},
+	}
+}
+
+func (s *APIClientServiceAccountMock) getClient(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	client Client) (clientOut *ClientOut, err error) {
+	if clientOut, ok := testServiceAccountClients[client.ClientID]; ok {
+		return clientOut, nil
+	}
+
+	inverr := apierror.ClientNotFound()
+	writeError(ctx, w, inverr)
+	return nil, inverr
+}
+
+func (s *APIClientServiceAccountMock) listClientRoles(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	clientUID string) (roles []Role, err error) {
+	for _, name := range []string{"read", "write"} {
+		roles = append(roles, Role{ID: clientUID + "-" + name, Name: name, ClientRole: true, ContainerID: clientUID})
+	}
+
+	return roles, nil
+}
+
+func (s *APIClientServiceAccountMock) getRealmRole(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	roleName string) (role *Role, err error) {
+	if roleName != "offline_access" && roleName != "admin" {
+		inverr := apierror.ResourceNotFound()
+		writeError(ctx, w, inverr)
+		return nil, inverr
+	}
+
+	return &Role{ID: roleName + "-id", Name: roleName}, nil
+}
+
+func (s *APIClientServiceAccountMock) getRoleMappings(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	userID string) (mappings *RoleMappings, err error) {
+	return &s.mappings, nil
+}
+
+func (s *APIClientServiceAccountMock) addRoleMappings(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	userID string,
+	clientUID string,
+	roles []Role) (err error) {
+	if clientUID == "" {
+		s.mappings.RealmMappings = append(s.mappings.RealmMappings, roles...)
+		return nil
+	}
+
+	for clientID, clientOut := range testServiceAccountClients {
+		if clientOut.ID == clientUID {
+			clientMappings := s.mappings.ClientMappings[clientID]
+			clientMappings.Mappings = append(clientMappings.Mappings, roles...)
+			s.mappings.ClientMappings[clientID] = clientMappings
+		}
+	}
+
+	return nil
+}
+
+func (s *APIClientServiceAccountMock) removeRoleMappings(
+	ctx context.Context,
+	w http.ResponseWriter,
+	controller *Controller,
+	token string,
+	userID string,
+	clientUID string,
+	roles []Role) (err error) {
+	kept := []Role{}
+
+	for _, role := range s.mappings.RealmMappings {
+		if clientUID != "" || findRole(roles, role.Name) == nil {
+			kept = append(kept, role)
+		}
+	}
+
+	s.mappings.RealmMappings = kept
+	return nil
+}
+
+// serviceAccountTestConfig - unit test config allowing offline_access realm role, read role
+// of orders-api and roles of clients owned by caller
+func serviceAccountTestConfig(apiClient APIClientIntf) *Config {
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.ServiceAccountPolicy = ServiceAccountPolicy{
+		RealmRoles:       []string{"offline_access"},
+		ClientRoles:      map[string][]string{"orders-api": {"read"}},
+		OwnedClientRoles: true,
+	}
+	return testConfig
+}
+
+func TestGrantServiceAccountRoles(t *testing.T) {
+	apiClient := newAPIClientServiceAccountMock()
+	payload := `{"realmRoles": ["offline_access"], "clientRoles": {"test": ["write"], "billing-api": ["read"], "orders-api": ["read"]}}`
+	rr := sendRequest(t, serviceAccountTestConfig(apiClient), "POST", "/client/test/service-account/roles", payload, nil)
+
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+
+	grants := RoleGrants{}
+
+	if err := json.Unmarshal(rr.Body.Bytes(), &grants); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.DeepEqual(t, grants, RoleGrants{
+		RealmRoles: []string{"offline_access"},
+		ClientRoles: map[string][]string{
+			"test":        {"write"},
+			"billing-api": {"read"},
+			"orders-api":  {"read"},
+		},
+	})
+
+	rr = sendRequest(t, serviceAccountTestConfig(apiClient), "GET", "/client/test", "", nil)
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+
+	view := ClientView{}
+
+	if err := json.Unmarshal(rr.Body.Bytes(), &view); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.DeepEqual(t, view.ServiceAccountRoles.RealmRoles, []string{"offline_access"})
+}
+
+func TestGrantServiceAccountRolesRejected(t *testing.T) {
+	cases := map[string][2]interface{}{
+		"1035": {`{"realmRoles": ["admin"], "clientRoles": {"orders-api": ["write"]}}`, []apierror.FieldError{
+			{Field: "realmRoles[0]", Reason: reasonGrantNotAllowed},
+			{Field: "clientRoles.orders-api[0]", Reason: reasonGrantNotAllowed},
+		}},
+		"1021": {`{"realmRoles": ["unknown"], "clientRoles": {"unknown": ["read"], "orders-api": ["delete"]}}`, []apierror.FieldError{
+			{Field: "realmRoles[0]", Reason: reasonUnknownRealmRole},
+			{Field: "clientRoles.orders-api[0]", Reason: reasonUnknownRole},
+			{Field: "clientRoles.unknown", Reason: reasonUnknownClient},
+		}},
+	}
+
+	for code, c := range cases {
+		apiClient := newAPIClientServiceAccountMock()
+		rr := sendRequest(t, serviceAccountTestConfig(apiClient), "POST", "/client/test/service-account/roles", c[0].(string), nil)
+
+		retErr := &apierror.ApiError{}
+
+		if errAPI := json.Unmarshal(rr.Body.Bytes(), retErr); errAPI != nil {
+			t.Fatalf("Single problem expected %s", rr.Body.String())
+		}
+
+		assert.Equal(t, retErr.Code, code)
+		assert.DeepEqual(t, retErr.Errors, c[1])
+		assert.Equal(t, len(apiClient.mappings.RealmMappings), 0)
+	}
+}
+
+func TestRevokeServiceAccountRoles(t *testing.T) {
+	apiClient := newAPIClientServiceAccountMock()
+	apiClient.mappings.RealmMappings = []Role{{ID: "admin-id", Name: "admin"}}
+
+	rr := sendRequest(t, serviceAccountTestConfig(apiClient), "DELETE", "/client/test/service-account/roles", `{"realmRoles": ["admin"]}`, nil)
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+	assert.Equal(t, len(apiClient.mappings.RealmMappings), 0)
+
+	rr = sendRequest(t, serviceAccountTestConfig(apiClient), "GET", "/client/orders-api/service-account/roles", "", nil)
+	assert.Equal(t, rr.Code, 400, rr.Body.String())
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/p53/idp-api/apierror"
+	"github.com/p53/idp-api/logging"
+)
+
+const (
+	reasonUnknownTemplate = "unknown template"
+	reasonNotOverridable  = "not overridable by template"
+)
+
+// ClientTemplate - named client definition, callers creating client from template
+// supply clientId and may set only overridable fields
+type ClientTemplate struct {
+	Description string `json:"description"`
+	Client      Client `json:"client"`
+	// Overridable - json names of client fields callers may set
+	Overridable []string `json:"overridable"`
+}
+
+// ClientTemplates - templates by name
+type ClientTemplates map[string]ClientTemplate
+
+// TemplateView - template as listed by api
+type TemplateView struct {
+	Name string `json:"name"`
+	ClientTemplate
+}
+
+// defaultTemplates - templates used when CLIENT_TEMPLATES_FILE is not set
+var defaultTemplates = ClientTemplates{
+	"m2m": {
+		Description: "backend service authenticated by client credentials",
+		Client:      Client{ServiceAccountsEnabled: true},
+		Overridable: []string{"clientAuthenticatorType", "jwksUrl", "jwks", "defaultClientScopes", "optionalClientScopes", "protocolMappers"},
+	},
+	"webapp": {
+		Description: "server side web application using standard flow",
+		Client:      Client{StandardFlowEnabled: true},
+		Overridable: []string{"redirectUris", "rootUrl", "adminUrl", "webOrigins", "defaultClientScopes", "optionalClientScopes", "protocolMappers"},
+	},
+	"spa": {
+		Description: "single page or native application, public client using standard flow with PKCE",
+		Client:      Client{PublicClient: true, StandardFlowEnabled: true},
+		Overridable: []string{"redirectUris", "rootUrl", "webOrigins", "defaultClientScopes", "optionalClientScopes"},
+	},
+}
+
+// getEnvTemplates - reads templates from json file named by env var, returns defaults when unset,
+// exits when file can't be read or any of its templates is invalid
+func getEnvTemplates(name string) ClientTemplates {
+	path := os.Getenv(name)
+
+	if path == "" {
+		return defaultTemplates
+	}
+
+	templates, err := loadTemplates(path)
+
+	if err != nil {
+		logging.GetLogger().Fatalf("Invalid templates %s in %s %s", path, name, err)
+	}
+
+	return templates
+}
+
+// loadTemplates - reads templates from json file and validates client of each template
+func loadTemplates(path string) (ClientTemplates, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	templates := ClientTemplates{}
+
+	if err := decodeStrict(file, &templates); err != nil {
+		return nil, err
+	}
+
+	for name, template := range templates {
+		if err := template.validate(); err != nil {
+			return nil, fmt.Errorf("template %s %s", name, err)
+		}
+	}
+
+	return templates, nil
+}
+
+// validate - checks client of template as created with placeholder clientId,
+// errors of overridable fields are left to callers who supply them
+func (template ClientTemplate) validate() error {
+	client := template.Client
+
+	if client.ClientID == "" {
+		client.ClientID = "template"
+	}
+
+	fieldErrors := []apierror.FieldError{}
+
+	for _, fieldErr := range validateClient(client) {
+		field := strings.FieldsFunc(fieldErr.Field, func(r rune) bool { return r == '.' || r == '[' })
+
+		if len(field) == 0 || !contains(template.Overridable, field[0]) {
+			fieldErrors = append(fieldErrors, fieldErr)
+		}
+	}
+
+	return validationError(fieldErrors)
+}
+
+// decode - decodes client from payload, payload with template field is expanded into client
+// of that template, caller fields override template only when overridable (clientId always)
+func (templates ClientTemplates) decode(body io.Reader) (Client, error) {
+	var client Client
+	payload, err := ioutil.ReadAll(body)
+
+	if err != nil {
+		return client, apierror.InvalidRequestPayload()
+	}
+
+	fields := map[string]json.RawMessage{}
+
+	if err := json.Unmarshal(payload, &fields); err != nil || fields["template"] == nil {
+		return client, decodeStrict(bytes.NewReader(payload), &client)
+	}
+
+	var name string
+
+	if err := json.Unmarshal(fields["template"], &name); err != nil {
+		return client, validationError([]apierror.FieldError{{Field: "template", Reason: "expected string"}})
+	}
+
+	template, ok := templates[name]
+
+	if !ok {
+		return client, validationError([]apierror.FieldError{{Field: "template", Reason: reasonUnknownTemplate}})
+	}
+
+	delete(fields, "template")
+	merged, fieldErrors := template.merge(name, fields)
+
+	if inverr := validationError(fieldErrors); inverr != nil {
+		return client, inverr
+	}
+
+	return client, decodeStrict(bytes.NewReader(merged), &client)
+}
+
+// merge - overlays caller fields over client of template, returns merged json payload
+func (template ClientTemplate) merge(name string, fields map[string]json.RawMessage) ([]byte, []apierror.FieldError) {
+	fieldErrors := []apierror.FieldError{}
+	base := map[string]json.RawMessage{}
+	templateClient, _ := json.Marshal(template.Client)
+	json.Unmarshal(templateClient, &base)
+
+	for field, value := range fields {
+		if field != "clientId" && !contains(template.Overridable, field) {
+			fieldErrors = append(fieldErrors, apierror.FieldError{
+				Field:  field,
+				Reason: fmt.Sprintf("%s %s", reasonNotOverridable, name),
+			})
+			continue
+		}
+
+		base[field] = value
+	}
+
+	sort.Slice(fieldErrors, func(i, j int) bool {
+		return fieldErrors[i].Field < fieldErrors[j].Field
+	})
+
+	merged, _ := json.Marshal(base)
+	return merged, fieldErrors
+}
+
+// ListTemplates - returns client templates sorted by name
+func (controller *Controller) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	views := []TemplateView{}
+
+	for name, template := range controller.Config.Templates {
+		views = append(views, TemplateView{Name: name, ClientTemplate: template})
+	}
+
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].Name < views[j].Name
+	})
+
+	templatesOut, err := json.Marshal(views)
+
+	if err != nil {
+		logging.GetLogger().Println(err)
+		writeError(r.Context(), w, apierror.InternalServerError())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(templatesOut)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+func TestTemplateExpanded(t *testing.T) {
+	client, err := defaultTemplates.decode(bytes.NewBufferString(
+		`{"template": "spa", "clientId": "app", "redirectUris": ["https://app.example.com/cb"]}`))
+
+	assert.NilError(t, err)
+	assert.Equal(t, client.ClientID, "app")
+	assert.Assert(t, client.PublicClient)
+	assert.Assert(t, client.StandardFlowEnabled)
+	assert.Assert(t, !client.ServiceAccountsEnabled)
+	assert.DeepEqual(t, client.RedirectUris, []string{"https://app.example.com/cb"})
+}
+
+func TestTemplateNotOverridable(t *testing.T) {
+	_, err := defaultTemplates.decode(bytes.NewBufferString(
+		`{"template": "m2m", "clientId": "svc", "serviceAccountsEnabled": false, "redirectUris": ["https://svc.example.com"]}`))
+
+	assert.DeepEqual(t, err.(*apierror.ApiError).Errors, []apierror.FieldError{
+		{Field: "redirectUris", Reason: reasonNotOverridable + " m2m"},
+		{Field: "serviceAccountsEnabled", Reason: reasonNotOverridable + " m2m"},
+	})
+}
+
+func TestTemplateUnknown(t *testing.T) {
+	_, err := defaultTemplates.decode(bytes.NewBufferString(`{"template": "batch", "clientId": "svc"}`))
+
+	assert.DeepEqual(t, err.(*apierror.ApiError).Errors, []apierror.FieldError{
+		{Field: "template", Reason: reasonUnknownTemplate},
+	})
+}
+
+func TestTemplateNotShared(t *testing.T) {
+	templates := ClientTemplates{"web": {
+		Client:      Client{StandardFlowEnabled: true, RedirectUris: []string{"https://default.example.com/cb"}},
+		Overridable: []string{"redirectUris"},
+	}}
+
+	client, err := templates.decode(bytes.NewBufferString(`{"template": "web", "clientId": "app", "redirectUris": ["https://app.example.com/cb"]}`))
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, client.RedirectUris, []string{"https://app.example.com/cb"})
+	assert.DeepEqual(t, templates["web"].Client.RedirectUris, []string{"https://default.example.com/cb"})
+}
+
+func TestCreateClientFromTemplate(t *testing.T) {
+	apiClient := newAPIClientScopesMock()
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.Templates = defaultTemplates
+
+	rr := sendRequest(t, testConfig, "POST", "/client", `{"template": "m2m", "clientId": "svc"}`, nil)
+
+	assert.Equal(t, rr.Code, 201, rr.Body.String())
+	assert.Equal(t, apiClient.created.ClientID, "svc")
+	assert.Assert(t, apiClient.created.ServiceAccountsEnabled)
+	assert.Assert(t, !apiClient.created.StandardFlowEnabled)
+}
+
+func TestListTemplates(t *testing.T) {
+	testConfig := getUnitTestConfig()
+	testConfig.Templates = defaultTemplates
+	ctrl := &Controller{Config: testConfig}
+
+	req, _ := http.NewRequest("GET", "/templates", nil)
+	rr := httptest.NewRecorder()
+	ctrl.ListTemplates(rr, req)
+
+	assert.Equal(t, rr.Code, 200)
+	views := []TemplateView{}
+
+	if err := json.Unmarshal(rr.Body.Bytes(), &views); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(views), 3)
+	assert.Equal(t, views[0].Name, "m2m")
+	assert.Assert(t, views[0].Client.ServiceAccountsEnabled)
+	assert.Equal(t, views[2].Name, "webapp")
+}
+
+func TestLoadTemplates(t *testing.T) {
+	writeTemplates := func(content string) string {
+		file, err := ioutil.TempFile("", "templates")
+		assert.NilError(t, err)
+		t.Cleanup(func() { os.Remove(file.Name()) })
+
+		file.WriteString(content)
+		file.Close()
+		return file.Name()
+	}
+
+	templates, err := loadTemplates(writeTemplates(`{"batch": {"description": "batch job", "client": {"serviceAccountsEnabled": true}, "overridable": []}}`))
+	assert.NilError(t, err)
+	assert.Equal(t, len(templates), 1)
+	assert.Assert(t, templates["batch"].Client.ServiceAccountsEnabled)
+
+	_, err = loadTemplates(writeTemplates(`{"batch": {"client": {"serviceAccountsEnabled": true}, "overridable": []}`))
+	assert.ErrorContains(t, err, "")
+
+	_, err = loadTemplates(writeTemplates(`{"batch": {"client": {"publicClient": true, "serviceAccountsEnabled": true}}}`))
+	assert.ErrorContains(t, err, "template batch")
+	assert.ErrorContains(t, err, reasonSAPublic)
+
+	// redirect uri required by public client is supplied by callers
+	_, err = loadTemplates(writeTemplates(`{"spa": {"client": {"publicClient": true, "standardFlowEnabled": true}, "overridable": ["redirectUris"]}}`))
+	assert.NilError(t, err)
+
+	_, err = loadTemplates(writeTemplates(`{"batch": {"client": {"serviceAccount": true}}}`))
+	assert.ErrorContains(t, err, "")
+
+	_, err = loadTemplates(os.TempDir() + "/templates-missing")
+	assert.ErrorContains(t, err, "")
+
+	for name, template := range defaultTemplates {
+		assert.NilError(t, template.validate(), name)
+	}
+
+	os.Unsetenv("TEST_CLIENT_TEMPLATES_FILE")
+	assert.Equal(t, len(getEnvTemplates("TEST_CLIENT_TEMPLATES_FILE")), len(defaultTemplates))
+}
@@ -2,13 +2,20 @@ package main
 
 var testPayload = `{
   "clientId":"test",
-  "publicClient": true,
+  "publicClient": false,
   "directAccessGrantsEnabled": true,
   "serviceAccountsEnabled": false,
   "standardFlowEnabled": false,
   "implicitFlowEnabled": false
 }`
 
+var testPublicPayload = `{
+  "clientId":"test",
+  "publicClient": true,
+  "standardFlowEnabled": true,
+  "redirectUris": ["https://example.com/callback"]
+}`
+
 var testBadPayload = `{
   "clientIdDDDDDDD":"test",
   "directAccessGrantsEnabled": true,
@@ -17,6 +24,21 @@ var testBadPayload = `{
   "implicitFlowEnabled": false
 }`
 
+var testMissingClientIDPayload = `{
+  "directAccessGrantsEnabled": true,
+  "serviceAccountsEnabled": false,
+  "standardFlowEnabled": false,
+  "implicitFlowEnabled": false
+}`
+
+var testInvalidClientPayload = `{
+  "clientId":"test",
+  "serviceAccountsEnabled": true,
+  "standardFlowEnabled": true,
+  "implicitFlowEnabled": true,
+  "redirectUris": ["https://example.com/callback", "/callback", "https://example.com/cb#state"]
+}`
+
 var testSecretPayload = `{
   "clientId":"test",
   "directAccessGrantsEnabled": true,
@@ -215,3 +237,40 @@ var testServiceClient = `{
   "standardFlowEnabled": false,
   "implicitFlowEnabled": false
 }`
+
+var testSamlCertificate = "MIICDjCCAXegAwIBAgIUfqWeSNO2zxN08a7OZxBr8quA1TwwDQYJKoZIhvcNAQELBQAwGTEXMBUGA1UEAwwOc3AuZXhhbXBsZS5jb20wHhcNMjYxMDE4MTcxNzI3WhcNMzYxMDE1MTcxNzI3WjAZMRcwFQYDVQQDDA5zcC5leGFtcGxlLmNvbTCBnzANBgkqhkiG9w0BAQEFAAOBjQAwgYkCgYEAxxEW/RDfqCLiJXMrlNZES5dSRy75SRX0Eq7qJMtHCC7mEl+CMIJUphcZT9VOu0TU7YW3jm0p1gB9yuaUG/1pqnip22oXquNeXYD0xkH5Fra9yegf5R4PWyiXN9Rmg6hvQ4XS6jbRTlQ6+IMJrBejPw8GA2m8TQwgMagVEmFXn88CAwEAAaNTMFEwHQYDVR0OBBYEFCp2/mgfKyKPdktVlKoMRjdFPNQTMB8GA1UdIwQYMBaAFCp2/mgfKyKPdktVlKoMRjdFPNQTMA8GA1UdEwEB/wQFMAMBAf8wDQYJKoZIhvcNAQELBQADgYEAv8ECOGYr4Od336raf2UuIZwqIx2EwaXvyGhIxkk6FnYKgr/IlmuWeWHePuHHIPC5MXgPbWTdGIS+txUUo4+dmyeXH0bipoWrM4H/wnkcO7AJvscsDKIsmCxVfoYGIefxlDrhiKfwBjQXcjuGCtt3WfhBGKfhQADklxPWDo2fsEc="
+
+var testSamlPayload = `{
+  "clientId": "https://sp.example.com/metadata",
+  "protocol": "saml",
+  "saml": {
+    "acsUrls": ["https://sp.example.com/saml/acs"],
+    "signingCertificate": "MIICDjCCAXegAwIBAgIUfqWeSNO2zxN08a7OZxBr8quA1TwwDQYJKoZIhvcNAQELBQAwGTEXMBUGA1UEAwwOc3AuZXhhbXBsZS5jb20wHhcNMjYxMDE4MTcxNzI3WhcNMzYxMDE1MTcxNzI3WjAZMRcwFQYDVQQDDA5zcC5leGFtcGxlLmNvbTCBnzANBgkqhkiG9w0BAQEFAAOBjQAwgYkCgYEAxxEW/RDfqCLiJXMrlNZES5dSRy75SRX0Eq7qJMtHCC7mEl+CMIJUphcZT9VOu0TU7YW3jm0p1gB9yuaUG/1pqnip22oXquNeXYD0xkH5Fra9yegf5R4PWyiXN9Rmg6hvQ4XS6jbRTlQ6+IMJrBejPw8GA2m8TQwgMagVEmFXn88CAwEAAaNTMFEwHQYDVR0OBBYEFCp2/mgfKyKPdktVlKoMRjdFPNQTMB8GA1UdIwQYMBaAFCp2/mgfKyKPdktVlKoMRjdFPNQTMA8GA1UdEwEB/wQFMAMBAf8wDQYJKoZIhvcNAQELBQADgYEAv8ECOGYr4Od336raf2UuIZwqIx2EwaXvyGhIxkk6FnYKgr/IlmuWeWHePuHHIPC5MXgPbWTdGIS+txUUo4+dmyeXH0bipoWrM4H/wnkcO7AJvscsDKIsmCxVfoYGIefxlDrhiKfwBjQXcjuGCtt3WfhBGKfhQADklxPWDo2fsEc=",
+    "nameIdFormat": "email",
+    "clientSignatureRequired": true,
+    "signDocuments": true,
+    "signAssertions": true
+  }
+}`
+
+var testSamlMetadata = `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" xmlns:ds="http://www.w3.org/2000/09/xmldsig#" entityID="https://sp.example.com/metadata">
+  <md:SPSSODescriptor AuthnRequestsSigned="true" WantAssertionsSigned="true" protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <md:KeyDescriptor use="signing">
+      <ds:KeyInfo><ds:X509Data><ds:X509Certificate>
+        MIICDjCCAXegAwIBAgIUfqWeSNO2zxN08a7OZxBr8quA1TwwDQYJKoZIhvcNAQELBQAwGTEXMBUGA1UEAwwOc3AuZXhhbXBsZS5jb20wHhcNMjYxMDE4MTcxNzI3WhcNMzYxMDE1MTcxNzI3WjAZMRcwFQYDVQQDDA5zcC5leGFtcGxlLmNvbTCBnzANBgkqhkiG9w0BAQEFAAOBjQAwgYkCgYEAxxEW/RDfqCLiJXMrlNZES5dSRy75SRX0Eq7qJMtHCC7mEl+CMIJUphcZT9VOu0TU7YW3jm0p1gB9yuaUG/1pqnip22oXquNeXYD0xkH5Fra9yegf5R4PWyiXN9Rmg6hvQ4XS6jbRTlQ6+IMJrBejPw8GA2m8TQwgMagVEmFXn88CAwEAAaNTMFEwHQYDVR0OBBYEFCp2/mgfKyKPdktVlKoMRjdFPNQTMB8GA1UdIwQYMBaAFCp2/mgfKyKPdktVlKoMRjdFPNQTMA8GA1UdEwEB/wQFMAMBAf8wDQYJKoZIhvcNAQELBQADgYEAv8ECOGYr4Od336raf2UuIZwqIx2EwaXvyGhIxkk6FnYKgr/IlmuWeWHePuHHIPC5MXgPbWTdGIS+txUUo4+dmyeXH0bipoWrM4H/wnkcO7AJvscsDKIsmCxVfoYGIefxlDrhiKfwBjQXcjuGCtt3WfhBGKfhQADklxPWDo2fsEc=
+      </ds:X509Certificate></ds:X509Data></ds:KeyInfo>
+    </md:KeyDescriptor>
+    <md:NameIDFormat>urn:oasis:names:tc:SAML:2.0:nameid-format:persistent</md:NameIDFormat>
+    <md:AssertionConsumerService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="https://sp.example.com/saml/acs-redirect" index="1"/>
+    <md:AssertionConsumerService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://sp.example.com/saml/acs" index="0"/>
+  </md:SPSSODescriptor>
+</md:EntityDescriptor>`
+
+var testSamlDescriptor = `<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://fake.com/auth/realms/master"></md:EntityDescriptor>`
+
+var testClientScopes = []ClientScope{
+	{ID: "scope-profile", Name: "profile", Protocol: "openid-connect"},
+	{ID: "scope-email", Name: "email", Protocol: "openid-connect"},
+	{ID: "scope-audience", Name: "orders-audience", Protocol: "openid-connect"},
+	{ID: "scope-saml-role-list", Name: "role_list", Protocol: "saml"},
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/p53/idp-api/apierror"
+)
+
+const (
+	accessTokenLifespanAttribute      = "access.token.lifespan"
+	clientSessionIdleTimeoutAttribute = "client.session.idle.timeout"
+	clientSessionMaxLifespanAttribute = "client.session.max.lifespan"
+	useRefreshTokensAttribute         = "use.refresh.tokens"
+	reasonNegativeSeconds             = "must not be negative"
+)
+
+// TokenSettings - token lifetimes of client in seconds, 0 uses realm default, stored in attributes
+// so they are never sent to idp
+type TokenSettings struct {
+	AccessTokenLifespan      int `json:"accessTokenLifespan,omitempty"`
+	ClientSessionIdleTimeout int `json:"clientSessionIdleTimeout,omitempty"`
+	ClientSessionMaxLifespan int `json:"clientSessionMaxLifespan,omitempty"`
+	// UseRefreshTokens - refresh token is issued with access token, default true
+	UseRefreshTokens *bool `json:"useRefreshTokens,omitempty"`
+}
+
+// Bounds - allowed range of seconds, 0 means no limit
+type Bounds struct {
+	Min int
+	Max int
+}
+
+// reason - returns why value is out of bounds, empty string when within, 0 (realm default) is always within
+func (bounds Bounds) reason(value int) string {
+	if value == 0 {
+		return ""
+	}
+
+	if bounds.Min > 0 && value < bounds.Min {
+		return fmt.Sprintf("must be at least %d seconds", bounds.Min)
+	}
+
+	if bounds.Max > 0 && value > bounds.Max {
+		return fmt.Sprintf("must be at most %d seconds", bounds.Max)
+	}
+
+	return ""
+}
+
+// TokenPolicy - bounds of token settings of clients
+type TokenPolicy struct {
+	AccessTokenLifespan      Bounds
+	ClientSessionIdleTimeout Bounds
+	ClientSessionMaxLifespan Bounds
+}
+
+// check - evaluates bounds on token settings of client, returns error listing every violation,
+// clientInfo is client before change, nil on create, settings it already has are kept unchecked
+func (policy TokenPolicy) check(client Client, clientInfo *ClientOut) error {
+	if client.TokenSettings == nil {
+		return nil
+	}
+
+	current := &TokenSettings{}
+
+	if clientInfo != nil {
+		if kept := tokenSettings(clientInfo); kept != nil {
+			current = kept
+		}
+	}
+
+	fieldErrors := []apierror.FieldError{}
+	settings := client.TokenSettings
+	fields := []struct {
+		name    string
+		value   int
+		current int
+		bounds  Bounds
+	}{
+		{"accessTokenLifespan", settings.AccessTokenLifespan, current.AccessTokenLifespan, policy.AccessTokenLifespan},
+		{"clientSessionIdleTimeout", settings.ClientSessionIdleTimeout, current.ClientSessionIdleTimeout, policy.ClientSessionIdleTimeout},
+		{"clientSessionMaxLifespan", settings.ClientSessionMaxLifespan, current.ClientSessionMaxLifespan, policy.ClientSessionMaxLifespan},
+	}
+
+	for _, field := range fields {
+		if clientInfo != nil && field.value == field.current {
+			continue
+		}
+
+		if reason := field.bounds.reason(field.value); reason != "" {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: "tokenSettings." + field.name, Reason: reason})
+		}
+	}
+
+	return validationError(fieldErrors)
+}
+
+// tokenSettingsErrors - lifetimes are positive seconds or 0 for realm default
+func tokenSettingsErrors(client Client) []apierror.FieldError {
+	fieldErrors := []apierror.FieldError{}
+
+	if client.TokenSettings == nil {
+		return fieldErrors
+	}
+
+	settings := client.TokenSettings
+	values := []struct {
+		name  string
+		value int
+	}{
+		{"accessTokenLifespan", settings.AccessTokenLifespan},
+		{"clientSessionIdleTimeout", settings.ClientSessionIdleTimeout},
+		{"clientSessionMaxLifespan", settings.ClientSessionMaxLifespan},
+	}
+
+	for _, v := range values {
+		if v.value < 0 {
+			fieldErrors = append(fieldErrors, apierror.FieldError{Field: "tokenSettings." + v.name, Reason: reasonNegativeSeconds})
+		}
+	}
+
+	return fieldErrors
+}
+
+// configureTokenSettings - stores token settings in keycloak attributes, empty value resets
+// attribute to realm default, omitted settings leave attributes unchanged
+func configureTokenSettings(client *Client) {
+	settings := client.TokenSettings
+
+	if settings == nil {
+		return
+	}
+
+	attributes := clientAttributes(client)
+	attributes[accessTokenLifespanAttribute] = secondsAttribute(settings.AccessTokenLifespan)
+	attributes[clientSessionIdleTimeoutAttribute] = secondsAttribute(settings.ClientSessionIdleTimeout)
+	attributes[clientSessionMaxLifespanAttribute] = secondsAttribute(settings.ClientSessionMaxLifespan)
+	attributes[useRefreshTokensAttribute] = strconv.FormatBool(settings.UseRefreshTokens == nil || *settings.UseRefreshTokens)
+
+	// settings are not part of keycloak client representation
+	client.TokenSettings = nil
+}
+
+// secondsAttribute - 0 is stored as empty attribute, which keycloak treats as realm default
+func secondsAttribute(seconds int) string {
+	if seconds == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(seconds)
+}
+
+// tokenSettings - reads token settings back from keycloak attributes, nil when client uses realm defaults
+func tokenSettings(clientOut *ClientOut) *TokenSettings {
+	attributes := clientOut.Attributes
+	settings := &TokenSettings{}
+	settings.AccessTokenLifespan, _ = strconv.Atoi(attributes[accessTokenLifespanAttribute])
+	settings.ClientSessionIdleTimeout, _ = strconv.Atoi(attributes[clientSessionIdleTimeoutAttribute])
+	settings.ClientSessionMaxLifespan, _ = strconv.Atoi(attributes[clientSessionMaxLifespanAttribute])
+
+	if value, ok := attributes[useRefreshTokensAttribute]; ok && value != "" {
+		useRefreshTokens := value == "true"
+		settings.UseRefreshTokens = &useRefreshTokens
+	}
+
+	if *settings == (TokenSettings{}) {
+		return nil
+	}
+
+	return settings
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/p53/idp-api/apierror"
+	"gotest.tools/assert"
+)
+
+func TestConfigureTokenSettings(t *testing.T) {
+	client := mustDecodeClient(t, `{"clientId": "test", "tokenSettings": {"accessTokenLifespan": 300, "useRefreshTokens": false}}`)
+	configureTokenSettings(&client)
+
+	assert.Assert(t, client.TokenSettings == nil)
+	assert.DeepEqual(t, client.Attributes, map[string]string{
+		accessTokenLifespanAttribute:      "300",
+		clientSessionIdleTimeoutAttribute: "",
+		clientSessionMaxLifespanAttribute: "",
+		useRefreshTokensAttribute:         "false",
+	})
+
+	settings := tokenSettings(&ClientOut{Attributes: client.Attributes})
+	assert.Equal(t, settings.AccessTokenLifespan, 300)
+	assert.Equal(t, settings.ClientSessionIdleTimeout, 0)
+	assert.Assert(t, !*settings.UseRefreshTokens)
+}
+
+func TestTokenSettingsOmitted(t *testing.T) {
+	client := mustDecodeClient(t, `{"clientId": "test"}`)
+	configureTokenSettings(&client)
+
+	assert.Assert(t, client.Attributes == nil)
+	assert.Assert(t, tokenSettings(&ClientOut{}) == nil)
+}
+
+func TestTokenPolicy(t *testing.T) {
+	policy := TokenPolicy{
+		AccessTokenLifespan:      Bounds{Min: 60, Max: 3600},
+		ClientSessionMaxLifespan: Bounds{Max: 86400},
+	}
+	client := mustDecodeClient(t, `{"clientId": "test", "tokenSettings": {"accessTokenLifespan": 30, "clientSessionIdleTimeout": 999999, "clientSessionMaxLifespan": 90000}}`)
+
+	err := policy.check(client, nil)
+
+	assert.DeepEqual(t, err.(*apierror.ApiError).Errors, []apierror.FieldError{
+		{Field: "tokenSettings.accessTokenLifespan", Reason: "must be at least 60 seconds"},
+		{Field: "tokenSettings.clientSessionMaxLifespan", Reason: "must be at most 86400 seconds"},
+	})
+	assert.NilError(t, policy.check(mustDecodeClient(t, `{"clientId": "test", "tokenSettings": {"clientSessionIdleTimeout": 1800}}`), nil))
+}
+
+func TestTokenSettingsNegative(t *testing.T) {
+	client := mustDecodeClient(t, `{"clientId": "test", "tokenSettings": {"clientSessionIdleTimeout": -1}}`)
+
+	assert.DeepEqual(t, tokenSettingsErrors(client), []apierror.FieldError{
+		{Field: "tokenSettings.clientSessionIdleTimeout", Reason: reasonNegativeSeconds},
+	})
+}
+
+func TestCreateClientTokenSettingsOutOfBounds(t *testing.T) {
+	apiClient := newAPIClientScopesMock()
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.TokenPolicy = TokenPolicy{AccessTokenLifespan: Bounds{Max: 3600}}
+
+	payload := `{"clientId": "test", "directAccessGrantsEnabled": true, "tokenSettings": {"accessTokenLifespan": 7200}}`
+	rr := sendRequest(t, testConfig, "POST", "/client", payload, nil)
+
+	assert.Equal(t, rr.Code, 400, rr.Body.String())
+	assert.DeepEqual(t, fieldErrorsOf(t, rr), []apierror.FieldError{
+		{Field: "tokenSettings.accessTokenLifespan", Reason: "must be at most 3600 seconds"},
+	})
+	assert.Assert(t, apiClient.created == nil)
+}
+
+func TestPatchClientTokenSettingsKept(t *testing.T) {
+	apiClient := newAPIClientScopesMock()
+	apiClient.client.Attributes = map[string]string{accessTokenLifespanAttribute: "7200"}
+	testConfig := getMockedTestConfig(apiClient)
+	testConfig.TokenPolicy = TokenPolicy{AccessTokenLifespan: Bounds{Max: 3600}}
+
+	// lifespan set before policy is kept when patch doesn't change it
+	rr := sendRequest(t, testConfig, "PATCH", "/client/test", `{"description": "changed"}`, nil)
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+
+	rr = sendRequest(t, testConfig, "PATCH", "/client/test", `{"tokenSettings": {"clientSessionIdleTimeout": 1800}}`, nil)
+	assert.Equal(t, rr.Code, 200, rr.Body.String())
+
+	rr = sendRequest(t, testConfig, "PATCH", "/client/test", `{"tokenSettings": {"accessTokenLifespan": 5400}}`, nil)
+	assert.Equal(t, rr.Code, 400, rr.Body.String())
+	assert.DeepEqual(t, fieldErrorsOf(t, rr), []apierror.FieldError{
+		{Field: "tokenSettings.accessTokenLifespan", Reason: "must be at most 3600 seconds"},
+	})
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/p53/idp-api/apierror"
+	validator "gopkg.in/validator.v2"
+)
+
+const (
+	reasonMissing               = "missing required field"
+	reasonUnknown               = "unknown field"
+	reasonMalformedURI          = "malformed URI"
+	reasonRelativeURI           = "must be absolute URI"
+	reasonMissingHost           = "must contain host"
+	reasonURIFragment           = "must not contain fragment"
+	reasonImplicitWithSA        = "implicit flow can not be combined with service accounts on confidential client"
+	reasonSAPublic              = "service accounts require confidential client"
+	reasonManagedByAPI          = "managed by api"
+	reasonImplicitPublic        = "implicit flow not allowed for public client, use standard flow with PKCE"
+	reasonPublicWildcard        = "wildcards not allowed in redirect uris of public client"
+	reasonPublicRedirect        = "public client with standard flow requires redirect uri"
+	reasonTypeChange            = "client type can't be changed, create new client"
+	reasonUnsupportedAuth       = "unsupported authenticator, use client-secret or client-jwt"
+	reasonKeysWithoutJWT        = "jwks and jwksUrl require client-jwt authenticator"
+	reasonPublicJWT             = "public client has no credentials"
+	reasonJwksExclusive         = "jwks and jwksUrl are mutually exclusive"
+	reasonJwksURL               = "must be absolute https url"
+	reasonMalformedJwks         = "malformed json web key set"
+	reasonPrivateKey            = "private key material not allowed"
+	reasonUnsupportedProtocol   = "unsupported protocol, use openid-connect or saml"
+	reasonSamlWithoutProtocol   = "saml settings require saml protocol"
+	reasonNotSaml               = "not supported by saml client"
+	reasonSamlMetadataExclusive = "metadata is exclusive with entity id, acs urls, certificates and name id format"
+	reasonMalformedSamlMetadata = "malformed SP metadata, EntityDescriptor with SPSSODescriptor expected"
+	reasonSamlEntityID          = "must equal clientId, keycloak identifies saml clients by entity id"
+	reasonSamlNameIDFormat      = "unsupported name id format, use username, email, transient or persistent"
+	reasonSamlCertRequired      = "required when client signature is required"
+	reasonMalformedCertificate  = "malformed x509 certificate"
+	reasonSamlUnsigned          = "idp must sign documents or assertions"
+	reasonProtocolChange        = "protocol can't be changed, create new client"
+	reasonUnknownScope          = "unknown client scope"
+	reasonScopeNotAllowed       = "client scope not allowed"
+	reasonScopeProtocol         = "client scope of other protocol"
+	reasonScopeDefaultOptional  = "client scope can't be both default and optional"
+)
+
+// decodeStrict - decodes json payload into v, fields not present in v are rejected
+func decodeStrict(body io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+
+	if err == nil {
+		return nil
+	}
+
+	if field, ok := unknownField(err); ok {
+		return validationError([]apierror.FieldError{{Field: field, Reason: reasonUnknown}})
+	}
+
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+		reason := fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)
+		return validationError([]apierror.FieldError{{Field: typeErr.Field, Reason: reason}})
+	}
+
+	return apierror.InvalidRequestPayload()
+}
+
+// unknownField - returns name of field from json decoder unknown field error
+func unknownField(err error) (string, bool) {
+	prefix := "json: unknown field "
+
+	if !strings.HasPrefix(err.Error(), prefix) {
+		return "", false
+	}
+
+	field, errUnq := strconv.Unquote(strings.TrimPrefix(err.Error(), prefix))
+
+	if errUnq != nil {
+		return "", false
+	}
+
+	return field, true
+}
+
+// requiredFieldErrors - reports fields violating validate tags of v under their json names
+func requiredFieldErrors(v interface{}) []apierror.FieldError {
+	err := validator.Validate(v)
+
+	if err == nil {
+		return nil
+	}
+
+	errMap, ok := err.(validator.ErrorMap)
+
+	if !ok {
+		return []apierror.FieldError{{Field: "", Reason: err.Error()}}
+	}
+
+	fieldErrors := []apierror.FieldError{}
+
+	for name := range errMap {
+		field := name[strings.LastIndex(name, ".")+1:]
+		fieldErrors = append(fieldErrors, apierror.FieldError{
+			Field:  jsonFieldName(reflect.TypeOf(v), field),
+			Reason: reasonMissing,
+		})
+	}
+
+	sort.Slice(fieldErrors, func(i, j int) bool {
+		return fieldErrors[i].Field < fieldErrors[j].Field
+	})
+
+	return fieldErrors
+}
+
+// jsonFieldName - returns json name of struct field, including fields of embedded structs
+func jsonFieldName(t reflect.Type, name string) string {
+	field, ok := t.FieldByName(name)
+
+	if !ok {
+		return name
+	}
+
+	tag := strings.Split(field.Tag.Get("json"), ",")[0]
+
+	if tag == "" {
+		return name
+	}
+
+	return tag
+}
+
+// validateClient - reports every offending field of client definition
+func validateClient(client Client) []apierror.FieldError {
+	return append(requiredFieldErrors(client), clientRuleErrors(client)...)
+}
+
+// validateClientWithSecret - reports every offending field of client definition with secret
+func validateClientWithSecret(client ClientWithSecret) []apierror.FieldError {
+	return append(requiredFieldErrors(client), clientRuleErrors(client.Client)...)
+}
+
+// clientRuleErrors - checks client according to its protocol
+func clientRuleErrors(client Client) []apierror.FieldError {
+	fieldErrors := []apierror.FieldError{}
+
+	switch client.Protocol {
+	case "", oidcProtocol:
+		fieldErrors = append(fieldErrors, oidcRuleErrors(client)...)
+	case samlProtocol:
+		fieldErrors = append(fieldErrors, samlErrors(client)...)
+	default:
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "protocol", Reason: reasonUnsupportedProtocol})
+	}
+
+	if len(client.Attributes) > 0 {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "attributes", Reason: reasonManagedByAPI})
+	}
+
+	fieldErrors = append(fieldErrors, clientScopeErrors(client)...)
+	fieldErrors = append(fieldErrors, protocolMapperErrors(client)...)
+	fieldErrors = append(fieldErrors, tokenSettingsErrors(client)...)
+
+	return fieldErrors
+}
+
+// oidcRuleErrors - checks redirect uris and combinations of flags
+func oidcRuleErrors(client Client) []apierror.FieldError {
+	fieldErrors := []apierror.FieldError{}
+
+	if client.Saml != nil {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "saml", Reason: reasonSamlWithoutProtocol})
+	}
+
+	for i, uri := range client.RedirectUris {
+		if reason := redirectURIReason(uri); reason != "" {
+			fieldErrors = append(fieldErrors, apierror.FieldError{
+				Field:  fmt.Sprintf("redirectUris[%d]", i),
+				Reason: reason,
+			})
+		}
+	}
+
+	if client.ImplicitFlowEnabled && client.ServiceAccountsEnabled && !client.PublicClient {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "implicitFlowEnabled", Reason: reasonImplicitWithSA})
+	}
+
+	if client.ServiceAccountsEnabled && client.PublicClient {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "serviceAccountsEnabled", Reason: reasonSAPublic})
+	}
+
+	if client.PublicClient {
+		fieldErrors = append(fieldErrors, publicClientErrors(client)...)
+	}
+
+	fieldErrors = append(fieldErrors, clientFlagErrors(client)...)
+	return append(fieldErrors, clientJWTErrors(client)...)
+}
+
+// publicClientErrors - public clients use only standard flow with PKCE and exact redirect uris
+func publicClientErrors(client Client) []apierror.FieldError {
+	fieldErrors := []apierror.FieldError{}
+
+	if client.ImplicitFlowEnabled {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "implicitFlowEnabled", Reason: reasonImplicitPublic})
+	}
+
+	if client.StandardFlowEnabled && len(client.RedirectUris) == 0 {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "redirectUris", Reason: reasonPublicRedirect})
+	}
+
+	for i, uri := range client.RedirectUris {
+		if strings.Contains(uri, "*") {
+			fieldErrors = append(fieldErrors, apierror.FieldError{
+				Field:  fmt.Sprintf("redirectUris[%d]", i),
+				Reason: reasonPublicWildcard,
+			})
+		}
+	}
+
+	return fieldErrors
+}
+
+// clientTypeError - public client can't become confidential and vice versa, protocol can't be changed
+func clientTypeError(clientInfo *ClientOut, client Client) error {
+	fieldErrors := []apierror.FieldError{}
+
+	if clientInfo.PublicClient != client.PublicClient {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "publicClient", Reason: reasonTypeChange})
+	}
+
+	if protocolOf(clientInfo.Protocol) != protocolOf(client.Protocol) {
+		fieldErrors = append(fieldErrors, apierror.FieldError{Field: "protocol", Reason: reasonProtocolChange})
+	}
+
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+
+	return validationError(fieldErrors)
+}
+
+// redirectURIReason - returns why redirect uri is not acceptable, empty string for valid uri
+func redirectURIReason(uri string) string {
+	parsed, err := url.Parse(uri)
+
+	if err != nil {
+		return reasonMalformedURI
+	}
+
+	if !parsed.IsAbs() {
+		return reasonRelativeURI
+	}
+
+	if (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host == "" {
+		return reasonMissingHost
+	}
+
+	if parsed.Fragment != "" {
+		return reasonURIFragment
+	}
+
+	return ""
+}
+
+// validationError - builds api error listing offending fields, payload missing only
+// required fields is reported as MissingRequiredFieldsPayload, nil if there is no error
+func validationError(fieldErrors []apierror.FieldError) error {
+	if len(fieldErrors) == 0 {
+		return nil
+	}
+
+	apiErr := apierror.MissingRequiredFieldsPayload()
+
+	for _, fieldErr := range fieldErrors {
+		if fieldErr.Reason != reasonMissing {
+			apiErr = apierror.ValidationFailed()
+			break
+		}
+	}
+
+	return apierror.WithFieldErrors(apiErr, fieldErrors)
+}